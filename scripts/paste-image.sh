@@ -39,6 +39,7 @@ case "${IMAGE_PATH,,}" in
     *.jpg|*.jpeg) MIME="image/jpeg" ;;
     *.gif)  MIME="image/gif" ;;
     *.webp) MIME="image/webp" ;;
+    *.avif) MIME="image/avif" ;;
     *)      MIME="image/png" ;;
 esac
 
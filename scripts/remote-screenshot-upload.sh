@@ -3,6 +3,8 @@
 # Remote Screenshot Uploader for ssbnk
 # Run this on remote machines. Watches for new screenshots and POSTs them
 # directly to the ssbnk host, which treats them like local screenshots.
+# Screen recordings are uploaded once finished; the host converts them to
+# GIFs in the background and the uploader polls until the URL is ready.
 #
 # Usage:
 #   SSBNK_HOST=https://ss.delo.sh SSBNK_UPLOAD_KEY=your-key ./remote-screenshot-upload.sh
@@ -11,6 +13,7 @@
 # automatically if present.
 #
 # Supports multiple watch directories via SSBNK_SCREENSHOT_DIR (colon-separated).
+# Set SSBNK_REPO (e.g. delorenj/ssbnk) to tag uploads with a repository.
 # Linux uses inotifywait (inotify-tools); macOS uses fswatch (brew install fswatch).
 #
 # Install as a service with scripts/install-remote-client.sh from the ssbnk repo.
@@ -110,6 +113,46 @@ wait_for_stable_file() {
     [ "$size" -gt 0 ]
 }
 
+# Wait until a recording stops growing (recorders write for minutes)
+wait_for_finished_recording() {
+    local file="$1" last_size=-1 size=0 stable=0 i
+    for i in $(seq 1 900); do
+        [ -f "$file" ] || return 1
+        size=$(stat -c %s "$file" 2>/dev/null || stat -f %z "$file" 2>/dev/null || echo 0)
+        if [ "$size" -gt 0 ] && [ "$size" = "$last_size" ]; then
+            stable=$((stable + 1))
+            [ "$stable" -ge 2 ] && return 0
+        else
+            stable=0
+        fi
+        last_size=$size
+        sleep 2
+    done
+    return 1
+}
+
+# Poll a conversion job until it finishes; prints the URL on success
+wait_for_job() {
+    local status_url="$1" body status i
+    for i in $(seq 1 300); do
+        sleep 2
+        body=$(curl -sS -H "X-Upload-Key: $SSBNK_UPLOAD_KEY" "$status_url" 2>&1) || continue
+        status=$(echo "$body" | grep -o '"status":"[^"]*"' | cut -d'"' -f4)
+        case "$status" in
+            done)
+                echo "$body" | grep -o '"url":"[^"]*"' | cut -d'"' -f4
+                return 0
+                ;;
+            failed)
+                echo "  Conversion failed: $(echo "$body" | grep -o '"error":"[^"]*"' | cut -d'"' -f4)" >&2
+                return 1
+                ;;
+        esac
+    done
+    echo "  Conversion still running, giving up: $status_url" >&2
+    return 1
+}
+
 copy_to_clipboard() {
     local text="$1"
     if command -v wl-copy &>/dev/null; then
@@ -132,7 +175,13 @@ upload_screenshot() {
         return 0  # another event for this file is already being handled
     fi
 
-    if ! wait_for_stable_file "$file"; then
+    if is_video_file "$file"; then
+        if ! wait_for_finished_recording "$file"; then
+            echo "Skipping (gone or still growing): $filename"
+            rmdir "$lock" 2>/dev/null || true
+            return 0
+        fi
+    elif ! wait_for_stable_file "$file"; then
         echo "Skipping (gone or empty): $filename"
         rmdir "$lock" 2>/dev/null || true
         return 0
@@ -140,12 +189,18 @@ upload_screenshot() {
 
     echo "Uploading: $filename"
 
+    local repo_args=()
+    if [ -n "${SSBNK_REPO:-}" ]; then
+        repo_args=(-F "repo=$SSBNK_REPO")
+    fi
+
     local attempt=1 response http_code body url rc=1
     while [ "$attempt" -le "$UPLOAD_RETRIES" ]; do
         response=$(curl -sS -w "\n%{http_code}" \
             -X POST \
             -H "X-Upload-Key: $SSBNK_UPLOAD_KEY" \
             -F "file=@$file" \
+            ${repo_args[@]+"${repo_args[@]}"} \
             "$SSBNK_HOST/upload" 2>&1) || true
 
         http_code=$(echo "$response" | tail -1)
@@ -164,6 +219,19 @@ upload_screenshot() {
             break
         fi
 
+        # Recordings are converted in the background; poll the job
+        if [ "$http_code" = "202" ]; then
+            local status_url
+            status_url=$(echo "$body" | grep -o '"status_url":"[^"]*"' | cut -d'"' -f4)
+            echo "  Converting: $status_url"
+            if url=$(wait_for_job "$status_url"); then
+                echo "  OK: $url"
+                copy_to_clipboard "$url"
+                rc=0
+            fi
+            break
+        fi
+
         echo "  Attempt $attempt/$UPLOAD_RETRIES failed ($http_code): $body"
         attempt=$((attempt + 1))
         [ "$attempt" -le "$UPLOAD_RETRIES" ] && sleep 2
@@ -181,11 +249,18 @@ is_image_file() {
     esac
 }
 
+is_video_file() {
+    case "$(printf '%s' "$1" | tr '[:upper:]' '[:lower:]')" in
+        *.mp4|*.avi|*.mov|*.mkv|*.webm|*.flv|*.wmv) return 0 ;;
+        *) return 1 ;;
+    esac
+}
+
 # Watch for new screenshots and upload as they appear
 if [ "$WATCHER_CMD" = "inotifywait" ]; then
     inotifywait -m -r -e create,moved_to "${WATCH_DIRS[@]}" --format '%w%f' |
     while read -r file; do
-        if is_image_file "$file"; then
+        if is_image_file "$file" || is_video_file "$file"; then
             upload_screenshot "$file" &
         fi
     done
@@ -193,7 +268,7 @@ else
     # fswatch: recursive by default; latency coalesces duplicate events
     fswatch -0 -r --latency=0.5 --event Created --event MovedTo "${WATCH_DIRS[@]}" |
     while IFS= read -r -d '' file; do
-        if [ -f "$file" ] && is_image_file "$file"; then
+        if [ -f "$file" ] && { is_image_file "$file" || is_video_file "$file"; }; then
             upload_screenshot "$file" &
         fi
     done
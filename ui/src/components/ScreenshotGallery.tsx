@@ -8,6 +8,13 @@ interface Screenshot {
   timestamp: string;
   size: number;
   original_name?: string;
+  thumbnails?: Thumbnail[];
+}
+
+interface Thumbnail {
+  width: number;
+  height: number;
+  url: string;
 }
 
 interface APIResponse {
@@ -26,6 +33,15 @@ function formatSize(bytes: number): string {
   return `${(bytes / (1024 * 1024)).toFixed(1)} MB`;
 }
 
+// thumbnailURL picks the smallest thumbnail at least minWidth wide,
+// falling back to the original
+function thumbnailURL(s: Screenshot, minWidth: number): string {
+  const fits = (s.thumbnails ?? [])
+    .filter((t) => t.width >= minWidth)
+    .sort((a, b) => a.width - b.width);
+  return fits.length > 0 ? fits[0].url : s.url;
+}
+
 function formatDate(ts: string): string {
   const d = new Date(ts);
   return d.toLocaleDateString("en-US", {
@@ -97,7 +113,7 @@ export default function ScreenshotGallery() {
             >
               <div className="aspect-video w-full overflow-hidden bg-muted">
                 <img
-                  src={s.url}
+                  src={thumbnailURL(s, 512)}
                   alt={s.filename}
                   loading="lazy"
                   className="h-full w-full object-cover transition-transform group-hover:scale-105"
@@ -125,7 +141,7 @@ export default function ScreenshotGallery() {
             >
               <div className="h-12 w-20 shrink-0 overflow-hidden rounded bg-muted">
                 <img
-                  src={s.url}
+                  src={thumbnailURL(s, 160)}
                   alt={s.filename}
                   loading="lazy"
                   className="h-full w-full object-cover"
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// urlSecretFile holds the generated signing secret when SSBNK_URL_SECRET is
+// not set. It lives next to the metadata, which deployments persist, and
+// outside the served hosted directory.
+const urlSecretFile = ".url-secret"
+
+// defaultSignedURLTTL is how long signed links handed out for private
+// screenshots stay valid unless SSBNK_SIGNED_URL_TTL says otherwise
+const defaultSignedURLTTL = 24 * time.Hour
+
+var (
+	errSignatureInvalid = errors.New("invalid signature")
+	errSignatureExpired = errors.New("link expired")
+)
+
+// loadURLSecret returns SSBNK_URL_SECRET, or the secret persisted in
+// DataDir/metadata (generated on first start)
+func loadURLSecret(dataDir string) ([]byte, error) {
+	if secret := os.Getenv("SSBNK_URL_SECRET"); secret != "" {
+		return []byte(secret), nil
+	}
+
+	path := filepath.Join(dataDir, "metadata", urlSecretFile)
+	if data, err := os.ReadFile(path); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return []byte(strings.TrimSpace(string(data))), nil
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	secret := hex.EncodeToString(random)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			// Another process generated it first
+			data, err := os.ReadFile(path)
+			return []byte(strings.TrimSpace(string(data))), err
+		}
+		return nil, fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(secret + "\n"); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	log.Printf("🔑 Generated URL signing secret in %s", path)
+	return []byte(secret), nil
+}
+
+func urlSignature(secret []byte, filename string, expires int64) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%d", filename, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signURL returns a link to a hosted file that is valid until expires
+func signURL(config Config, filename string, expires time.Time) (string, error) {
+	if len(config.URLSecret) == 0 {
+		return "", errors.New("URL signing is not configured")
+	}
+	exp := expires.Unix()
+	query := url.Values{
+		"exp": {strconv.FormatInt(exp, 10)},
+		"sig": {urlSignature(config.URLSecret, filename, exp)},
+	}
+	return fmt.Sprintf("%s/%s?%s", config.BaseURL, filename, query.Encode()), nil
+}
+
+// verifySignedURL checks the exp and sig parameters of a request for filename
+func verifySignedURL(config Config, filename string, query url.Values) error {
+	if len(config.URLSecret) == 0 {
+		return errSignatureInvalid
+	}
+	exp, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil {
+		return errSignatureInvalid
+	}
+	expected := urlSignature(config.URLSecret, filename, exp)
+	if !hmac.Equal([]byte(query.Get("sig")), []byte(expected)) {
+		return errSignatureInvalid
+	}
+	if time.Now().Unix() > exp {
+		return errSignatureExpired
+	}
+	return nil
+}
+
+// signedURLTTL is the lifetime of links generated for private screenshots
+func signedURLTTL(config Config) time.Duration {
+	if config.SignedURLTTL > 0 {
+		return config.SignedURLTTL
+	}
+	return defaultSignedURLTTL
+}
+
+// accessURL is the URL to hand out for a screenshot: a signed link for
+// private ones, the plain hosted URL otherwise
+func accessURL(config Config, metadata ScreenshotMetadata) string {
+	if !metadata.Private {
+		return metadata.URL
+	}
+	signed, err := signURL(config, metadata.Filename, time.Now().Add(signedURLTTL(config)))
+	if err != nil {
+		log.Printf("Warning: Failed to sign URL for %s: %v", metadata.Filename, err)
+		return metadata.URL
+	}
+	return signed
+}
+
+// serveHostedFile serves an image from the hosted storage after checking
+// access: expired screenshots are gone, requests carrying a signature must
+// present a valid, unexpired one, and private screenshots are only served
+// to signed requests. ?w=256 serves the smallest thumbnail at least that
+// wide instead, falling back to the original. A screencast's video is
+// served under the same checks as its GIF.
+func serveHostedFile(w http.ResponseWriter, r *http.Request, config Config) {
+	// Errors mustn't outlive a restore or a change of privacy in caches
+	w = noStoreErrors{w}
+	filename := strings.TrimPrefix(r.URL.Path, "/")
+	query := r.URL.Query()
+
+	// Hosted files are flat; subdirectories (thumbs/) are only reachable
+	// through their original so they share its access checks
+	if strings.Contains(filename, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	metadata, found, err := config.metadataRepo().GetByFilename(filename)
+	if err == nil && !found && isVideoFile(filename) {
+		// Videos share the access checks of the GIF they were made with
+		metadata, found, err = videoOwner(config, filename)
+	}
+	if err != nil {
+		log.Printf("Error looking up %s: %v", filename, err)
+		http.Error(w, "Failed to read metadata", http.StatusInternalServerError)
+		return
+	}
+	if found && isExpired(metadata, time.Now()) {
+		http.Error(w, "Screenshot expired", http.StatusGone)
+		return
+	}
+	if found && metadata.ExpiresAt != nil {
+		// Caches may keep it until the deadline, not past it
+		maxAge := min(time.Until(*metadata.ExpiresAt), 24*time.Hour) / time.Second
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	}
+
+	name := filename
+	if val := query.Get("w"); val != "" {
+		width, err := strconv.Atoi(val)
+		if err != nil || width <= 0 {
+			http.Error(w, "Invalid width", http.StatusBadRequest)
+			return
+		}
+		if thumbnail, ok := thumbnailFor(metadata, width); found && ok && filename == metadata.Filename {
+			name = thumbnail.Filename
+		}
+	}
+
+	// Links are signed for the screenshot's own file, which covers its video
+	signedName := filename
+	if found {
+		signedName = metadata.Filename
+	}
+	if query.Has("sig") {
+		switch err := verifySignedURL(config, signedName, query); err {
+		case nil:
+			w.Header().Set("Cache-Control", "private, no-store")
+			config.hostedStorage().ServeFile(w, r, name)
+		case errSignatureExpired:
+			http.Error(w, "Link expired", http.StatusGone)
+		default:
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		}
+		return
+	}
+
+	if found && metadata.Private {
+		// Don't reveal that the file exists
+		http.NotFound(w, r)
+		return
+	}
+	config.hostedStorage().ServeFile(w, r, name)
+}
+
+// noStoreErrors marks error responses as uncacheable, overriding the
+// long-lived Cache-Control set for hosted files in withHeaders
+type noStoreErrors struct {
+	http.ResponseWriter
+}
+
+func (w noStoreErrors) WriteHeader(code int) {
+	if code >= http.StatusBadRequest {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// handleSignScreenshot issues a signed link for a screenshot at
+// POST /api/screenshots/{id}/sign?ttl=1h
+func handleSignScreenshot(w http.ResponseWriter, r *http.Request, config Config, metadata ScreenshotMetadata) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ttl := signedURLTTL(config)
+	if val := r.URL.Query().Get("ttl"); val != "" {
+		parsed, err := time.ParseDuration(val)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid ttl", http.StatusBadRequest)
+			return
+		}
+		ttl = parsed
+	}
+
+	expires := time.Now().Add(ttl)
+	signed, err := signURL(config, metadata.Filename, expires)
+	if err != nil {
+		log.Printf("API: Failed to sign %s: %v", metadata.Filename, err)
+		http.Error(w, "URL signing not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	response := map[string]string{
+		"url":        signed,
+		"expires_at": expires.UTC().Format(time.RFC3339),
+	}
+	if metadata.Video != nil {
+		// The GIF's signature also opens its video
+		response["video_url"] = metadata.Video.URL + signed[strings.IndexByte(signed, '?'):]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// publicMetadataAt returns the offset-th newest screenshot that is neither
+// private nor expired, paging through the repository
+func publicMetadataAt(config Config, offset int) (ScreenshotMetadata, bool, error) {
+	const pageSize = 50
+	if offset < 0 {
+		return ScreenshotMetadata{}, false, nil
+	}
+	now := time.Now()
+	seen := 0
+	for start := 0; ; start += pageSize {
+		page, _, err := config.metadataRepo().Page(start, pageSize, nil)
+		if err != nil {
+			return ScreenshotMetadata{}, false, err
+		}
+		for _, metadata := range page {
+			if metadata.Private || isExpired(metadata, now) {
+				continue
+			}
+			if seen == offset {
+				return metadata, true, nil
+			}
+			seen++
+		}
+		if len(page) < pageSize {
+			return ScreenshotMetadata{}, false, nil
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createPrivateScreenshot(t *testing.T, config Config) ScreenshotMetadata {
+	t.Helper()
+	metadata := ScreenshotMetadata{
+		ID:        "private-id",
+		Filename:  "secret.png",
+		URL:       config.BaseURL + "/secret.png",
+		Timestamp: time.Now(),
+		Private:   true,
+	}
+	if err := os.WriteFile(filepath.Join(config.DataDir, "hosted", metadata.Filename), []byte("private pixels"), 0644); err != nil {
+		t.Fatalf("Failed to create hosted file: %v", err)
+	}
+	if err := config.metadataRepo().Save(metadata); err != nil {
+		t.Fatalf("Failed to save metadata: %v", err)
+	}
+	return metadata
+}
+
+func TestSignedURLs(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.URLSecret = []byte("secret")
+
+	signed, err := signURL(config, "shot.png", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("signURL failed: %v", err)
+	}
+	parsed, _ := url.Parse(signed)
+	if parsed.Path != "/shot.png" {
+		t.Errorf("Unexpected signed URL %s", signed)
+	}
+	if err := verifySignedURL(config, "shot.png", parsed.Query()); err != nil {
+		t.Errorf("Valid signature rejected: %v", err)
+	}
+	if err := verifySignedURL(config, "other.png", parsed.Query()); err != errSignatureInvalid {
+		t.Errorf("Signature for another file accepted: %v", err)
+	}
+
+	tampered := parsed.Query()
+	tampered.Set("exp", strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10))
+	if err := verifySignedURL(config, "shot.png", tampered); err != errSignatureInvalid {
+		t.Errorf("Tampered expiry accepted: %v", err)
+	}
+
+	expired, _ := signURL(config, "shot.png", time.Now().Add(-time.Minute))
+	parsed, _ = url.Parse(expired)
+	if err := verifySignedURL(config, "shot.png", parsed.Query()); err != errSignatureExpired {
+		t.Errorf("Expected expired link, got %v", err)
+	}
+
+	if _, err := signURL(Config{}, "shot.png", time.Now()); err == nil {
+		t.Error("Expected signing without a secret to fail")
+	}
+}
+
+func TestServeHostedFileEnforcesPrivacy(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.URLSecret = []byte("secret")
+	metadata := createPrivateScreenshot(t, config)
+
+	serve := func(target string) int {
+		w := httptest.NewRecorder()
+		serveHostedFile(w, httptest.NewRequest("GET", target, nil), config)
+		return w.Code
+	}
+
+	if code := serve("/secret.png"); code != http.StatusNotFound {
+		t.Errorf("Expected private file to be hidden, got %d", code)
+	}
+
+	signed := accessURL(config, metadata)
+	if !strings.Contains(signed, "sig=") {
+		t.Fatalf("Expected a signed URL for a private screenshot, got %s", signed)
+	}
+	if code := serve(strings.TrimPrefix(signed, config.BaseURL)); code != http.StatusOK {
+		t.Errorf("Expected signed request to be served, got %d", code)
+	}
+	if code := serve("/secret.png?exp=9999999999&sig=forged"); code != http.StatusForbidden {
+		t.Errorf("Expected forged signature to be rejected, got %d", code)
+	}
+
+	expired, _ := signURL(config, "secret.png", time.Now().Add(-time.Minute))
+	if code := serve(strings.TrimPrefix(expired, config.BaseURL)); code != http.StatusGone {
+		t.Errorf("Expected expired link to return %d, got %d", http.StatusGone, code)
+	}
+}
+
+func TestPrivateScreenshotsHiddenFromPublicListings(t *testing.T) {
+	t.Setenv("SSBNK_API_KEY", "test-key")
+	config, _ := createTestConfig(t)
+	config.URLSecret = []byte("secret")
+	createPrivateScreenshot(t, config)
+
+	list := func(req *http.Request) int {
+		w := httptest.NewRecorder()
+		handleAPIScreenshots(w, req, config)
+		var response struct {
+			Total int `json:"total"`
+		}
+		json.NewDecoder(w.Body).Decode(&response)
+		return response.Total
+	}
+
+	if total := list(httptest.NewRequest("GET", "/api/screenshots", nil)); total != 0 {
+		t.Errorf("Expected private screenshot to be hidden, got %d entries", total)
+	}
+	if total := list(apiRequest("GET", "/api/screenshots", "")); total != 1 {
+		t.Errorf("Expected API key holders to see private screenshots, got %d entries", total)
+	}
+
+	w := httptest.NewRecorder()
+	handleLatest(w, httptest.NewRequest("GET", "/latest", nil), config)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected /latest to skip private screenshots, got %d (%s)", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestFilesystemLookupSkipsHiddenScreenshots(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.URLSecret = []byte("secret")
+	createPrivateScreenshot(t, config)
+	past := time.Now().Add(-time.Minute)
+	expired := ScreenshotMetadata{ID: "expired-id", Filename: "expired.png", Timestamp: time.Now(), ExpiresAt: &past}
+	os.WriteFile(filepath.Join(config.DataDir, "hosted", expired.Filename), []byte("expired pixels"), 0644)
+	config.metadataRepo().Save(expired)
+
+	// An older file without metadata is the only one the scan may return
+	unknown := filepath.Join(config.DataDir, "hosted", "unknown.png")
+	os.WriteFile(unknown, []byte("unknown pixels"), 0644)
+	os.Chtimes(unknown, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+
+	for _, target := range []string{"/stateless/0", "/hybrid/0"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", target, nil)
+		if strings.HasPrefix(target, "/stateless") {
+			handleLatestStateless(w, req, config)
+		} else {
+			handleLatestHybrid(w, req, config)
+		}
+		if location := w.Header().Get("Location"); w.Code != http.StatusFound || location != config.BaseURL+"/unknown.png" {
+			t.Errorf("%s: expected a redirect to unknown.png, got %d (%s)", target, w.Code, location)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleLatestStateless(w, httptest.NewRequest("GET", "/stateless/1", nil), config)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected /stateless/1 to skip hidden screenshots, got %d (%s)", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestSignScreenshotEndpoint(t *testing.T) {
+	t.Setenv("SSBNK_API_KEY", "test-key")
+	config, _ := createTestConfig(t)
+	config.URLSecret = []byte("secret")
+	createPrivateScreenshot(t, config)
+
+	w := httptest.NewRecorder()
+	handleAPIScreenshot(w, apiRequest("POST", "/api/screenshots/private-id/sign?ttl=10m", ""), config)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response map[string]string
+	json.NewDecoder(w.Body).Decode(&response)
+
+	parsed, _ := url.Parse(response["url"])
+	if err := verifySignedURL(config, "secret.png", parsed.Query()); err != nil {
+		t.Errorf("Issued URL does not verify: %v", err)
+	}
+	expires, _ := time.Parse(time.RFC3339, response["expires_at"])
+	if until := time.Until(expires); until <= 0 || until > 10*time.Minute {
+		t.Errorf("Unexpected expiry %s", response["expires_at"])
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIScreenshot(w, apiRequest("POST", "/api/screenshots/private-id/sign?ttl=forever", ""), config)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid ttl to be rejected, got %d", w.Code)
+	}
+}
+
+func TestLoadURLSecretPersists(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "metadata"), 0755)
+	first, err := loadURLSecret(dir)
+	if err != nil || len(first) == 0 {
+		t.Fatalf("loadURLSecret failed: %v", err)
+	}
+	second, _ := loadURLSecret(dir)
+	if string(first) != string(second) {
+		t.Error("Expected the generated secret to be reused")
+	}
+
+	t.Setenv("SSBNK_URL_SECRET", "from-env")
+	if env, _ := loadURLSecret(dir); string(env) != "from-env" {
+		t.Errorf("Expected SSBNK_URL_SECRET to win, got %q", env)
+	}
+}
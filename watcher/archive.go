@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var errRestoreConflict = errors.New("a hosted file with that name already exists")
+
+// archivedItem is a screenshot in DataDir/archive/YYYY-MM-DD. Files archived
+// without metadata are listed with the information the file itself provides.
+type archivedItem struct {
+	ScreenshotMetadata
+	ArchivedOn  string `json:"archived_on"`
+	HasMetadata bool   `json:"has_metadata"`
+
+	filePath     string
+	metadataPath string
+}
+
+// listArchive returns every archived item, most recently archived first
+func listArchive(config Config) ([]archivedItem, error) {
+	days, err := os.ReadDir(archiveRoot(config))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []archivedItem{}, nil
+		}
+		return nil, fmt.Errorf("failed to read archive directory: %w", err)
+	}
+
+	items := []archivedItem{}
+	for _, day := range days {
+		if !day.IsDir() {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", day.Name()); err != nil {
+			continue
+		}
+		dayItems, err := listArchiveDay(config, day.Name())
+		if err != nil {
+			log.Printf("⚠️  %v", err)
+			continue
+		}
+		items = append(items, dayItems...)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].ArchivedOn != items[j].ArchivedOn {
+			return items[i].ArchivedOn > items[j].ArchivedOn
+		}
+		return items[i].Timestamp.After(items[j].Timestamp)
+	})
+	return items, nil
+}
+
+func listArchiveDay(config Config, day string) ([]archivedItem, error) {
+	dir := filepath.Join(archiveRoot(config), day)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive %s: %w", day, err)
+	}
+
+	byFilename := make(map[string]*archivedItem)
+	var order []string
+	item := func(filename string) *archivedItem {
+		if existing, ok := byFilename[filename]; ok {
+			return existing
+		}
+		byFilename[filename] = &archivedItem{ArchivedOn: day}
+		order = append(order, filename)
+		return byFilename[filename]
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		metadata, err := readMetadataFile(path)
+		if err != nil || metadata.Filename == "" {
+			log.Printf("⚠️  Skipping archived metadata %s: %v", path, err)
+			continue
+		}
+		archived := item(metadata.Filename)
+		archived.ScreenshotMetadata = metadata
+		archived.HasMetadata = true
+		archived.metadataPath = path
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isImageFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		archived := item(entry.Name())
+		archived.filePath = filepath.Join(dir, entry.Name())
+		if !archived.HasMetadata {
+			archived.Filename = entry.Name()
+			archived.URL = fmt.Sprintf("%s/%s", config.BaseURL, entry.Name())
+			archived.Timestamp = info.ModTime()
+			archived.Size = info.Size()
+		}
+	}
+
+	items := make([]archivedItem, 0, len(order))
+	for _, filename := range order {
+		items = append(items, *byFilename[filename])
+	}
+	return items, nil
+}
+
+// findArchived looks an item up by metadata ID or filename, preferring the
+// most recent archive day
+func findArchived(config Config, key string) (archivedItem, bool, error) {
+	items, err := listArchive(config)
+	if err != nil {
+		return archivedItem{}, false, err
+	}
+	for _, item := range items {
+		if (item.ID != "" && item.ID == key) || item.Filename == key {
+			return item, true, nil
+		}
+	}
+	return archivedItem{}, false, nil
+}
+
+// restoreArchived moves an archived file back into the hosted storage
+// under its original name and puts its metadata back in the repository,
+// so the original URL works again. RestoredAt restarts the retention clock.
+func restoreArchived(config Config, item archivedItem) (ScreenshotMetadata, error) {
+	// A retention pass would take the file for untracked until the
+	// metadata is back, and archive it again
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+
+	if hostedExists(config, item.Filename) {
+		return ScreenshotMetadata{}, errRestoreConflict
+	}
+
+	moved := false
+	if item.filePath != "" {
+		if err := importHosted(config, item.filePath, item.Filename); err != nil {
+			if errors.Is(err, os.ErrExist) {
+				return ScreenshotMetadata{}, errRestoreConflict
+			}
+			return ScreenshotMetadata{}, fmt.Errorf("failed to restore %s: %w", item.Filename, err)
+		}
+		moved = true
+	}
+
+	metadata := item.ScreenshotMetadata
+	if item.HasMetadata {
+		now := time.Now()
+		metadata.RestoredAt = &now
+		// The thumbnails were deleted when the screenshot was archived
+		metadata.Thumbnails = nil
+		if err := config.metadataRepo().Save(metadata); err != nil {
+			if moved {
+				if undoErr := exportHosted(config, item.Filename, item.filePath); undoErr != nil {
+					log.Printf("Warning: Failed to move %s back to the archive: %v", item.Filename, undoErr)
+				}
+			}
+			return ScreenshotMetadata{}, fmt.Errorf("failed to restore metadata for %s: %w", item.Filename, err)
+		}
+		if err := os.Remove(item.metadataPath); err != nil {
+			log.Printf("Warning: Failed to remove %s: %v", item.metadataPath, err)
+		}
+	}
+
+	dayDir := filepath.Join(archiveRoot(config), item.ArchivedOn)
+	if item.HasMetadata {
+		restoreOriginal(config, metadata, dayDir)
+		restoreVideo(config, metadata, dayDir)
+		scheduleThumbnails(config, metadata)
+	}
+
+	// Drop the day directory once it is empty
+	os.Remove(dayDir)
+
+	log.Printf("Restored: %s from archive %s", item.Filename, item.ArchivedOn)
+	return metadata, nil
+}
+
+// handleAPIArchive lists archived screenshots at GET /api/archive and
+// restores one at POST /api/archive/{id}/restore (the ID may also be the
+// filename)
+func handleAPIArchive(w http.ResponseWriter, r *http.Request, config Config) {
+	if !authorizeAPIRequest(w, r) {
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/archive"), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		items, err := listArchive(config)
+		if err != nil {
+			log.Printf("API: Failed to list archive: %v", err)
+			http.Error(w, "Failed to read archive", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"archived": items,
+			"total":    len(items),
+		})
+		return
+	}
+
+	key, ok := strings.CutSuffix(path, "/restore")
+	if !ok || key == "" || strings.Contains(key, "/") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	item, found, err := findArchived(config, key)
+	if err != nil {
+		log.Printf("API: Failed to search archive: %v", err)
+		http.Error(w, "Failed to read archive", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Archived screenshot not found", http.StatusNotFound)
+		return
+	}
+
+	metadata, err := restoreArchived(config, item)
+	if errors.Is(err, errRestoreConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("API: %v", err)
+		http.Error(w, "Failed to restore screenshot", http.StatusInternalServerError)
+		return
+	}
+	writeScreenshot(w, metadata)
+}
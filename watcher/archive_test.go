@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchiveListing(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.RetentionDays = 30
+	now := time.Now()
+	createRetentionData(t, config, now)
+	if report := runRetention(config, now, false); len(report.Errors) > 0 {
+		t.Fatalf("Retention failed: %v", report.Errors)
+	}
+
+	items, err := listArchive(config)
+	if err != nil {
+		t.Fatalf("listArchive failed: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 archived items, got %d: %+v", len(items), items)
+	}
+	byName := map[string]archivedItem{}
+	for _, item := range items {
+		byName[item.Filename] = item
+		if item.ArchivedOn != now.Format("2006-01-02") {
+			t.Errorf("Expected %s to be archived on %s, got %s", item.Filename, now.Format("2006-01-02"), item.ArchivedOn)
+		}
+	}
+	if old := byName["old.png"]; !old.HasMetadata || old.ID != "old" {
+		t.Errorf("Expected old.png to carry its metadata, got %+v", old)
+	}
+	if untracked := byName["untracked.png"]; untracked.HasMetadata || untracked.URL == "" {
+		t.Errorf("Expected untracked.png without metadata but with a URL, got %+v", untracked)
+	}
+}
+
+func TestRestoreArchived(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.RetentionDays = 30
+	now := time.Now()
+	createRetentionData(t, config, now)
+	original, _, _ := config.metadataRepo().Get("old")
+	runRetention(config, now, false)
+
+	item, found, err := findArchived(config, "old")
+	if err != nil || !found {
+		t.Fatalf("Expected to find archived item: found=%v err=%v", found, err)
+	}
+	restored, err := restoreArchived(config, item)
+	if err != nil {
+		t.Fatalf("restoreArchived failed: %v", err)
+	}
+	if restored.URL != original.URL || restored.Filename != original.Filename || restored.RestoredAt == nil {
+		t.Errorf("Unexpected restored metadata: %+v", restored)
+	}
+
+	if !fileExists(filepath.Join(config.DataDir, "hosted", "old.png")) {
+		t.Error("Restored file is not hosted")
+	}
+	if fileExists(filepath.Join(archiveRoot(config), now.Format("2006-01-02"), "old.json")) {
+		t.Error("Archived metadata was left behind")
+	}
+	if metadata, found, _ := config.metadataRepo().GetByFilename("old.png"); !found || metadata.ID != "old" {
+		t.Error("Restored metadata is not in the repository")
+	}
+
+	// The restore restarts the retention clock
+	if report := runRetention(config, now.Add(time.Minute), true); len(report.Archived) != 0 {
+		t.Errorf("Expected restored screenshot to stay hosted, got %+v", report.Archived)
+	}
+
+	// Untracked files are found by filename
+	item, found, _ = findArchived(config, "untracked.png")
+	if !found {
+		t.Fatal("Expected to find untracked.png")
+	}
+	if _, err := restoreArchived(config, item); err != nil {
+		t.Fatalf("Failed to restore untracked file: %v", err)
+	}
+	if fileExists(filepath.Join(archiveRoot(config), now.Format("2006-01-02"))) {
+		t.Error("Expected the empty archive day to be removed")
+	}
+}
+
+func TestRestoreWaitsForRetention(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.RetentionDays = 30
+	now := time.Now()
+	createRetentionData(t, config, now)
+	runRetention(config, now, false)
+	item, _, _ := findArchived(config, "old")
+
+	retentionMu.Lock()
+	done := make(chan error)
+	go func() {
+		_, err := restoreArchived(config, item)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if hostedExists(config, "old.png") {
+		t.Error("Restore moved the file while a retention pass held the lock")
+	}
+	retentionMu.Unlock()
+
+	if err := <-done; err != nil {
+		t.Fatalf("restoreArchived failed: %v", err)
+	}
+	if !hostedExists(config, "old.png") {
+		t.Error("Restored file is not hosted")
+	}
+}
+
+func TestArchiveAPI(t *testing.T) {
+	t.Setenv("SSBNK_API_KEY", "test-key")
+	config, _ := createTestConfig(t)
+	config.RetentionDays = 30
+	now := time.Now()
+	createRetentionData(t, config, now)
+	runRetention(config, now, false)
+
+	w := httptest.NewRecorder()
+	handleAPIArchive(w, httptest.NewRequest("GET", "/api/archive", nil), config)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without key, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIArchive(w, apiRequest("GET", "/api/archive", ""), config)
+	var listing struct {
+		Archived []archivedItem `json:"archived"`
+		Total    int            `json:"total"`
+	}
+	json.NewDecoder(w.Body).Decode(&listing)
+	if w.Code != http.StatusOK || listing.Total != 2 {
+		t.Fatalf("Unexpected listing %d: %+v", w.Code, listing)
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIArchive(w, apiRequest("GET", "/api/archive/old/restore", ""), config)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d for GET restore, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIArchive(w, apiRequest("POST", "/api/archive/missing/restore", ""), config)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for unknown item, got %d", http.StatusNotFound, w.Code)
+	}
+
+	// A new file took the name while the original was archived
+	hostedPath := filepath.Join(config.DataDir, "hosted", "old.png")
+	os.WriteFile(hostedPath, []byte("new"), 0644)
+	w = httptest.NewRecorder()
+	handleAPIArchive(w, apiRequest("POST", "/api/archive/old/restore", ""), config)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d on a name clash, got %d", http.StatusConflict, w.Code)
+	}
+	os.Remove(hostedPath)
+
+	w = httptest.NewRecorder()
+	handleAPIArchive(w, apiRequest("POST", "/api/archive/old/restore", ""), config)
+	var restored ScreenshotMetadata
+	json.NewDecoder(w.Body).Decode(&restored)
+	if w.Code != http.StatusOK || restored.ID != "old" {
+		t.Errorf("Unexpected restore response %d: %+v", w.Code, restored)
+	}
+	if data, _ := os.ReadFile(hostedPath); string(data) != "old" {
+		t.Errorf("Expected the archived content to be restored, got %q", data)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// batchTracker groups locally captured screenshots that arrive within
+// Config.BatchWindow of each other into one batch.
+type batchTracker struct {
+	mu      sync.Mutex
+	lastID  string
+	lastAt  time.Time
+	batchID string
+}
+
+var localBatches = &batchTracker{}
+
+func newBatchID() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")[:12]
+}
+
+// join registers screenshot id ingested at now. It returns the batch the
+// screenshot belongs to (empty if none) and, when this screenshot opened
+// a new batch, the ID of the previous screenshot that must join it too.
+func (b *batchTracker) join(id string, now time.Time, window time.Duration) (batchID, prevID string) {
+	if window <= 0 {
+		return "", ""
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.lastID != "" && now.Sub(b.lastAt) <= window {
+		if b.batchID == "" {
+			b.batchID = newBatchID()
+			prevID = b.lastID
+		}
+		batchID = b.batchID
+	} else {
+		b.batchID = ""
+	}
+	b.lastID = id
+	b.lastAt = now
+	return batchID, prevID
+}
+
+// assignBatch sets metadata.BatchID for local captures and back-fills the
+// previous screenshot when a new batch starts
+func assignBatch(config Config, metadata *ScreenshotMetadata) {
+	batchID, prevID := localBatches.join(metadata.ID, metadata.Timestamp, config.BatchWindow)
+	metadata.BatchID = batchID
+	if prevID == "" {
+		return
+	}
+
+	// The previous screenshot may have OCR or thumbnail jobs still running
+	_, err := updateMetadata(config, prevID, func(prev *ScreenshotMetadata) {
+		prev.BatchID = batchID
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to add %s to batch %s: %v", prevID, batchID, err)
+	}
+}
+
+func batchURL(config Config, batchID string) string {
+	return fmt.Sprintf("%s/b/%s", config.BaseURL, batchID)
+}
+
+// shareURL is the URL copied to the clipboard: a signed link for private
+// screenshots, the album for batched ones, the image itself otherwise
+func shareURL(config Config, metadata ScreenshotMetadata) string {
+	if metadata.Private {
+		return accessURL(config, metadata)
+	}
+	if metadata.BatchID != "" {
+		return batchURL(config, metadata.BatchID)
+	}
+	return metadata.URL
+}
+
+// loadBatch returns the public, unexpired screenshots of a batch in
+// capture order
+func loadBatch(config Config, batchID string) []ScreenshotMetadata {
+	now := time.Now()
+	var batch []ScreenshotMetadata
+	for _, metadata := range loadAllMetadata(config) {
+		if metadata.BatchID == batchID && !metadata.Private && !isExpired(metadata, now) {
+			batch = append(batch, metadata)
+		}
+	}
+	sort.SliceStable(batch, func(i, j int) bool {
+		return batch[i].Timestamp.Before(batch[j].Timestamp)
+	})
+	return batch
+}
+
+// handleAPIBatch returns a batch as JSON at /api/batches/{batchID}
+func handleAPIBatch(w http.ResponseWriter, r *http.Request, config Config) {
+	batchID := strings.TrimPrefix(r.URL.Path, "/api/batches/")
+	batch := loadBatch(config, batchID)
+	if batchID == "" || len(batch) == 0 {
+		http.Error(w, "Batch not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"batch_id":    batchID,
+		"url":         batchURL(config, batchID),
+		"screenshots": batch,
+	})
+}
+
+var batchPageTemplate = template.Must(template.New("batch").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>ssbnk · {{len .Screenshots}} screenshots</title>
+<style>
+body { margin: 0; padding: 2rem; background: #0b0b0f; color: #e5e5e5; font-family: system-ui, sans-serif; }
+h1 { font-size: 1rem; font-weight: 500; color: #a3a3a3; }
+figure { margin: 0 0 2rem; }
+img { display: block; max-width: 100%; border-radius: 6px; }
+figcaption { margin-top: .5rem; font-size: .85rem; color: #a3a3a3; }
+a { color: inherit; }
+</style>
+</head>
+<body>
+<h1>{{len .Screenshots}} screenshots · {{.Started.Format "2006-01-02 15:04"}}</h1>
+{{range .Screenshots}}<figure>
+<a href="{{.URL}}"><img src="{{.URL}}" alt="{{.OriginalName}}" loading="lazy"></a>
+<figcaption>{{if .Description}}{{.Description}} · {{end}}<a href="{{.URL}}">{{.Filename}}</a></figcaption>
+</figure>
+{{end}}</body>
+</html>
+`))
+
+// handleBatchPage renders the album page at /b/{batchID}
+func handleBatchPage(w http.ResponseWriter, r *http.Request, config Config) {
+	batchID := strings.TrimPrefix(r.URL.Path, "/b/")
+	batch := loadBatch(config, batchID)
+	if batchID == "" || len(batch) == 0 {
+		http.Error(w, "Batch not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := batchPageTemplate.Execute(w, map[string]interface{}{
+		"Screenshots": batch,
+		"Started":     batch[0].Timestamp,
+	})
+	if err != nil {
+		log.Printf("Error rendering batch page: %v", err)
+	}
+}
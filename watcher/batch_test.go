@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchTrackerJoin(t *testing.T) {
+	tracker := &batchTracker{}
+	start := time.Now()
+	window := 10 * time.Second
+
+	if batchID, prevID := tracker.join("a", start, window); batchID != "" || prevID != "" {
+		t.Errorf("First screenshot should not start a batch, got %q/%q", batchID, prevID)
+	}
+
+	batchID, prevID := tracker.join("b", start.Add(5*time.Second), window)
+	if batchID == "" || prevID != "a" {
+		t.Fatalf("Second screenshot within window should open a batch with a, got %q/%q", batchID, prevID)
+	}
+
+	// The window slides with each capture
+	if next, prevID := tracker.join("c", start.Add(14*time.Second), window); next != batchID || prevID != "" {
+		t.Errorf("Third screenshot should join %q, got %q/%q", batchID, next, prevID)
+	}
+
+	if next, _ := tracker.join("d", start.Add(time.Minute), window); next != "" {
+		t.Errorf("Screenshot after the window should not be batched, got %q", next)
+	}
+
+	if next, _ := tracker.join("e", start.Add(time.Minute), 0); next != "" {
+		t.Errorf("Batching should be disabled with a zero window, got %q", next)
+	}
+}
+
+func multipartUpload(t *testing.T, files map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatalf("Failed to create form file: %v", err)
+		}
+		part.Write([]byte(content))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-Upload-Key", "upload-key")
+	return req
+}
+
+func TestMultiFileUploadCreatesBatch(t *testing.T) {
+	t.Setenv("SSBNK_UPLOAD_KEY", "upload-key")
+	config, _ := createTestConfig(t)
+
+	w := httptest.NewRecorder()
+	handleUpload(w, multipartUpload(t, map[string]string{"one.png": "1", "two.png": "2"}), config)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response struct {
+		URL     string              `json:"url"`
+		BatchID string              `json:"batch_id"`
+		Files   []map[string]string `json:"files"`
+	}
+	json.NewDecoder(w.Body).Decode(&response)
+	if response.BatchID == "" || len(response.Files) != 2 {
+		t.Fatalf("Unexpected upload response: %+v", response)
+	}
+	if response.URL != batchURL(config, response.BatchID) {
+		t.Errorf("Expected album URL, got %s", response.URL)
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIBatch(w, httptest.NewRequest("GET", "/api/batches/"+response.BatchID, nil), config)
+	var batch struct {
+		Screenshots []ScreenshotMetadata `json:"screenshots"`
+	}
+	json.NewDecoder(w.Body).Decode(&batch)
+	if len(batch.Screenshots) != 2 {
+		t.Errorf("Expected 2 screenshots in batch, got %d", len(batch.Screenshots))
+	}
+
+	w = httptest.NewRecorder()
+	handleBatchPage(w, httptest.NewRequest("GET", "/b/"+response.BatchID, nil), config)
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), "<figure>") != 2 {
+		t.Errorf("Batch page did not list both screenshots: %d\n%s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handleBatchPage(w, httptest.NewRequest("GET", "/b/missing", nil), config)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for unknown batch, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestUploadRequestLimits(t *testing.T) {
+	t.Setenv("SSBNK_UPLOAD_KEY", "upload-key")
+	config, _ := createTestConfig(t)
+	config.MaxUploadSize = 1000
+	config.MaxUploadFiles = 2
+
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		// The first file is spooled before the body runs out
+		{"OverSize", map[string]string{"one.png": strings.Repeat("1", 600), "two.png": strings.Repeat("2", 600)}},
+		{"TooManyFiles", map[string]string{"one.png": "1", "two.png": "2", "three.png": "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleUpload(w, multipartUpload(t, tt.files), config)
+			if w.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("Expected status %d, got %d: %s", http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+			}
+			if entries, _ := os.ReadDir(uploadDir(config)); len(entries) != 0 {
+				t.Errorf("Spooled upload left behind: %v", entries)
+			}
+			if all := loadAllMetadata(config); len(all) != 0 {
+				t.Errorf("Rejected upload was stored: %+v", all)
+			}
+		})
+	}
+}
+
+func TestAssignBatchBackfillsPrevious(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.BatchWindow = time.Minute
+	localBatches = &batchTracker{}
+	t.Cleanup(func() { localBatches = &batchTracker{} })
+
+	now := time.Now()
+	first := ScreenshotMetadata{ID: "first", Filename: "first.png", Timestamp: now}
+	assignBatch(config, &first)
+	config.metadataRepo().Save(first)
+
+	second := ScreenshotMetadata{ID: "second", Filename: "second.png", Timestamp: now.Add(time.Second)}
+	assignBatch(config, &second)
+
+	stored, _, _ := config.metadataRepo().Get("first")
+	if second.BatchID == "" || stored.BatchID != second.BatchID {
+		t.Errorf("Expected both screenshots in one batch, got %q and %q", stored.BatchID, second.BatchID)
+	}
+	if shareURL(config, second) != batchURL(config, second.BatchID) {
+		t.Errorf("Expected share URL to point to the album, got %s", shareURL(config, second))
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// findDuplicate returns the newest stored screenshot whose content has the
+// given SHA-256, provided its hosted file still exists and it can stand in
+// for incoming. Screenshots with an expiry are never reused, since the copy
+// would vanish with them, and neither are ones whose privacy differs: a
+// private upload must not get a public URL, nor a public one a signed link.
+// Incoming screenshots with an expiry are always stored on their own so the
+// requested deadline isn't dropped.
+func findDuplicate(config Config, hash string, incoming ScreenshotMetadata) (ScreenshotMetadata, bool) {
+	if incoming.ExpiresAt != nil {
+		return ScreenshotMetadata{}, false
+	}
+	matches, err := config.metadataRepo().ListByHash(hash)
+	if err != nil {
+		log.Printf("Warning: Failed to look up content hash: %v", err)
+		return ScreenshotMetadata{}, false
+	}
+	for _, existing := range matches {
+		if existing.ExpiresAt != nil || existing.Private != incoming.Private {
+			continue
+		}
+		if hostedExists(config, existing.Filename) {
+			return existing, true
+		}
+	}
+	return ScreenshotMetadata{}, false
+}
+
+// reuseDuplicate handles a local capture whose content is already hosted:
+// the source is dropped and the existing URL is copied instead
+func reuseDuplicate(config Config, sourcePath string, existing ScreenshotMetadata) {
+	if err := os.Remove(sourcePath); err != nil {
+		log.Printf("Warning: Failed to remove duplicate file: %v", err)
+	}
+
+	writeLastScreenshotPath(existing.Filename)
+
+	if err := copyToClipboard(shareURL(config, existing)); err != nil {
+		log.Printf("Warning: Failed to copy to clipboard: %v", err)
+	}
+
+	log.Printf("♻️  Duplicate of %s: %s -> %s", existing.Filename, filepath.Base(sourcePath), existing.URL)
+}
+
+// backfillHashes records the content hash of entries stored before hashes
+// were tracked, so they take part in deduplication. Ingest hashes the file
+// as it came in, so entries whose hosted file was changed on the way
+// (sanitized, optimized, redacted) are skipped: their hash would never match.
+func backfillHashes(config Config) {
+	repo := config.metadataRepo()
+	allMetadata, err := repo.List()
+	if err != nil {
+		log.Printf("Warning: Failed to list metadata for hashing: %v", err)
+		return
+	}
+
+	updated := 0
+	for _, metadata := range allMetadata {
+		if metadata.SHA256 != "" || len(metadata.Sanitized) > 0 || metadata.OriginalSize != 0 || metadata.Redaction != nil {
+			continue
+		}
+		content, err := config.hostedStorage().Open(metadata.Filename)
+		if err != nil {
+			continue
+		}
+		hash, err := hashReader(content)
+		content.Close()
+		if err != nil {
+			continue
+		}
+		found, err := updateMetadata(config, metadata.ID, func(current *ScreenshotMetadata) {
+			current.SHA256 = hash
+		})
+		if err != nil {
+			log.Printf("Warning: Failed to save hash for %s: %v", metadata.Filename, err)
+			continue
+		}
+		if found {
+			updated++
+		}
+	}
+
+	if updated > 0 {
+		log.Printf("🔑 Recorded content hashes for %d existing screenshots", updated)
+	}
+}
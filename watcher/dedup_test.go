@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessScreenshotReusesDuplicate(t *testing.T) {
+	config, _ := createTestConfig(t)
+
+	for _, name := range []string{"first.png", "second.png"} {
+		sourcePath := filepath.Join(config.ScreenshotDir, name)
+		if err := os.WriteFile(sourcePath, []byte("same pixels"), 0644); err != nil {
+			t.Fatalf("Failed to create screenshot: %v", err)
+		}
+		if err := processScreenshot(sourcePath, config); err != nil {
+			t.Fatalf("processScreenshot failed: %v", err)
+		}
+		if fileExists(sourcePath) {
+			t.Errorf("Source %s was not removed", name)
+		}
+	}
+
+	all := loadAllMetadata(config)
+	if len(all) != 1 {
+		t.Fatalf("Expected 1 metadata entry, got %d", len(all))
+	}
+	if all[0].SHA256 == "" || all[0].OriginalName != "first.png" {
+		t.Errorf("Unexpected metadata: %+v", all[0])
+	}
+	if hosted, _ := os.ReadDir(filepath.Join(config.DataDir, "hosted")); len(hosted) != 1 {
+		t.Errorf("Expected 1 hosted file, got %d", len(hosted))
+	}
+}
+
+func TestProcessScreenshotStoresAgainWhenHostedFileIsGone(t *testing.T) {
+	config, _ := createTestConfig(t)
+
+	sourcePath := filepath.Join(config.ScreenshotDir, "shot.png")
+	os.WriteFile(sourcePath, []byte("same pixels"), 0644)
+	if err := processScreenshot(sourcePath, config); err != nil {
+		t.Fatalf("processScreenshot failed: %v", err)
+	}
+	first := loadAllMetadata(config)[0]
+	os.Remove(filepath.Join(config.DataDir, "hosted", first.Filename))
+
+	os.WriteFile(sourcePath, []byte("same pixels"), 0644)
+	if err := processScreenshot(sourcePath, config); err != nil {
+		t.Fatalf("processScreenshot failed: %v", err)
+	}
+	if all := loadAllMetadata(config); len(all) != 2 {
+		t.Errorf("Expected the screenshot to be stored again, got %d entries", len(all))
+	}
+}
+
+func TestUploadDuplicateReturnsExisting(t *testing.T) {
+	t.Setenv("SSBNK_UPLOAD_KEY", "upload-key")
+	config, _ := createTestConfig(t)
+
+	upload := func() map[string]interface{} {
+		w := httptest.NewRecorder()
+		handleUpload(w, multipartUpload(t, map[string]string{"retry.png": "identical"}), config)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var response map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&response)
+		return response
+	}
+
+	first := upload()
+	second := upload()
+	if first["duplicate"] != false || second["duplicate"] != true {
+		t.Errorf("Unexpected duplicate flags: %v, %v", first["duplicate"], second["duplicate"])
+	}
+	if first["url"] != second["url"] || first["filename"] != second["filename"] {
+		t.Errorf("Duplicate upload returned %v, expected %v", second, first)
+	}
+	if all := loadAllMetadata(config); len(all) != 1 {
+		t.Errorf("Expected 1 metadata entry, got %d", len(all))
+	}
+}
+
+func TestUploadDuplicateKeepsPrivacy(t *testing.T) {
+	t.Setenv("SSBNK_UPLOAD_KEY", "upload-key")
+	config, _ := createTestConfig(t)
+	config.URLSecret = []byte("secret")
+
+	upload := func(content, private string) map[string]interface{} {
+		req := multipartUpload(t, map[string]string{"shot.png": content})
+		req.URL.RawQuery = "private=" + private
+		w := httptest.NewRecorder()
+		handleUpload(w, req, config)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var response map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&response)
+		return response
+	}
+
+	t.Run("PublicThenPrivate", func(t *testing.T) {
+		public := upload("public first", "false")
+		private := upload("public first", "true")
+		if private["duplicate"] != false || private["filename"] == public["filename"] {
+			t.Errorf("Private upload reused the public item: %v", private)
+		}
+		if url, _ := private["url"].(string); !strings.Contains(url, "sig=") {
+			t.Errorf("Expected a signed URL for the private upload, got %q", url)
+		}
+
+		// The newer private copy doesn't hide the public original
+		again := upload("public first", "false")
+		if again["duplicate"] != true || again["filename"] != public["filename"] {
+			t.Errorf("Expected a duplicate of %v, got %v", public["filename"], again)
+		}
+	})
+
+	t.Run("PrivateThenPublic", func(t *testing.T) {
+		private := upload("private first", "true")
+		public := upload("private first", "false")
+		if public["duplicate"] != false || public["filename"] == private["filename"] {
+			t.Errorf("Public upload reused the private item: %v", public)
+		}
+		if url, _ := public["url"].(string); strings.Contains(url, "sig=") {
+			t.Errorf("Expected a plain URL for the public upload, got %q", url)
+		}
+	})
+}
+
+func TestBackfillHashes(t *testing.T) {
+	config, _ := createTestConfig(t)
+	createTestData(t, config)
+
+	// An optimized file no longer has the content that was ingested
+	optimized, _, _ := config.metadataRepo().Get("test-id-2")
+	optimized.OriginalSize = optimized.Size + 100
+	config.metadataRepo().Save(optimized)
+
+	backfillHashes(config)
+
+	expected, _ := fileSHA256(filepath.Join(config.DataDir, "hosted", "20240101-1200.png"))
+	existing, found := findDuplicate(config, expected, ScreenshotMetadata{})
+	if !found || existing.Filename != "20240101-1200.png" {
+		t.Errorf("Expected backfilled hash to resolve 20240101-1200.png, got %+v (%v)", existing, found)
+	}
+	if current, _, _ := config.metadataRepo().Get(optimized.ID); current.SHA256 != "" {
+		t.Errorf("Hashed the optimized %s", current.Filename)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expirySuffixPattern matches the ".expires-<ttl>" suffix that sets a TTL
+// on files saved to a watch directory, e.g. "bug.expires-1h.png"
+var expirySuffixPattern = regexp.MustCompile(`\.expires-([0-9][0-9a-z.]*)$`)
+
+// parseExpiresIn parses a TTL such as "90s", "1h30m" or "7d"
+func parseExpiresIn(value string) (time.Duration, error) {
+	var ttl time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid expiry %q", value)
+		}
+		ttl = time.Duration(n) * 24 * time.Hour
+	} else {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid expiry %q", value)
+		}
+		ttl = parsed
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("expiry %q must be positive", value)
+	}
+	return ttl, nil
+}
+
+// splitExpirySuffix strips a ".expires-<ttl>" suffix from a filename and
+// returns the cleaned name and the TTL (zero if there was none)
+func splitExpirySuffix(filename string) (string, time.Duration) {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	match := expirySuffixPattern.FindStringSubmatch(base)
+	if match == nil {
+		return filename, 0
+	}
+	ttl, err := parseExpiresIn(match[1])
+	if err != nil {
+		log.Printf("Warning: Ignoring %v in %s", err, filename)
+		return filename, 0
+	}
+	return strings.TrimSuffix(base, match[0]) + ext, ttl
+}
+
+// expiryAfter returns the deadline for ttl from now, or nil without a TTL
+func expiryAfter(now time.Time, ttl time.Duration) *time.Time {
+	if ttl <= 0 {
+		return nil
+	}
+	expiresAt := now.Add(ttl)
+	return &expiresAt
+}
+
+// isExpired reports whether a screenshot's deadline has passed
+func isExpired(metadata ScreenshotMetadata, now time.Time) bool {
+	return metadata.ExpiresAt != nil && !now.Before(*metadata.ExpiresAt)
+}
+
+// startExpiryReaper deletes expired screenshots every interval
+func startExpiryReaper(config Config, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for {
+		time.Sleep(interval)
+		reapExpired(config, time.Now())
+	}
+}
+
+// reapExpired deletes the hosted file and metadata of every screenshot
+// whose deadline has passed and returns how many were removed
+func reapExpired(config Config, now time.Time) int {
+	reaped := 0
+	for _, metadata := range loadAllMetadata(config) {
+		if !isExpired(metadata, now) {
+			continue
+		}
+		if err := deleteScreenshot(config, metadata); err != nil {
+			log.Printf("⚠️  Failed to delete expired %s: %v", metadata.Filename, err)
+			continue
+		}
+		log.Printf("⌛ Expired: %s", metadata.Filename)
+		reaped++
+	}
+	return reaped
+}
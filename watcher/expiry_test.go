@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseExpiresIn(t *testing.T) {
+	tests := map[string]time.Duration{
+		"90s":   90 * time.Second,
+		"1h30m": 90 * time.Minute,
+		"7d":    7 * 24 * time.Hour,
+	}
+	for value, expected := range tests {
+		if got, err := parseExpiresIn(value); err != nil || got != expected {
+			t.Errorf("parseExpiresIn(%q) = %v, %v; expected %v", value, got, err, expected)
+		}
+	}
+	for _, value := range []string{"", "soon", "-1h", "0s", "xd"} {
+		if _, err := parseExpiresIn(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestSplitExpirySuffix(t *testing.T) {
+	tests := []struct {
+		filename string
+		name     string
+		ttl      time.Duration
+	}{
+		{"bug.expires-1h.png", "bug.png", time.Hour},
+		{"demo.expires-2d.mp4", "demo.mp4", 48 * time.Hour},
+		{"plain.png", "plain.png", 0},
+		{"bad.expires-1x.png", "bad.expires-1x.png", 0},
+	}
+	for _, test := range tests {
+		if name, ttl := splitExpirySuffix(test.filename); name != test.name || ttl != test.ttl {
+			t.Errorf("splitExpirySuffix(%q) = %q, %v; expected %q, %v", test.filename, name, ttl, test.name, test.ttl)
+		}
+	}
+}
+
+func TestProcessScreenshotWithExpirySuffix(t *testing.T) {
+	config, _ := createTestConfig(t)
+
+	sourcePath := filepath.Join(config.ScreenshotDir, "bug.expires-1h.png")
+	os.WriteFile(sourcePath, []byte("temporary"), 0644)
+	if err := processScreenshot(sourcePath, config); err != nil {
+		t.Fatalf("processScreenshot failed: %v", err)
+	}
+
+	all := loadAllMetadata(config)
+	if len(all) != 1 || all[0].ExpiresAt == nil || all[0].OriginalName != "bug.png" {
+		t.Fatalf("Unexpected metadata: %+v", all)
+	}
+	if until := time.Until(*all[0].ExpiresAt); until <= 59*time.Minute || until > time.Hour {
+		t.Errorf("Unexpected expiry %v", all[0].ExpiresAt)
+	}
+}
+
+func TestUploadWithExpiry(t *testing.T) {
+	t.Setenv("SSBNK_UPLOAD_KEY", "upload-key")
+	config, _ := createTestConfig(t)
+
+	upload := func(expiresIn string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("expires_in", expiresIn)
+		part, _ := writer.CreateFormFile("file", "temp.png")
+		part.Write([]byte("temp " + expiresIn))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/upload", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-Upload-Key", "upload-key")
+		w := httptest.NewRecorder()
+		handleUpload(w, req, config)
+		return w
+	}
+
+	if w := upload("never"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid expiry to be rejected, got %d", w.Code)
+	}
+
+	w := upload("1h")
+	var response struct {
+		Filename  string     `json:"filename"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	json.NewDecoder(w.Body).Decode(&response)
+	if w.Code != http.StatusOK || response.ExpiresAt == nil {
+		t.Fatalf("Expected expires_at in response, got %d: %+v", w.Code, response)
+	}
+}
+
+func TestExpiringCopyOfPermanentScreenshot(t *testing.T) {
+	t.Setenv("SSBNK_UPLOAD_KEY", "upload-key")
+	config, _ := createTestConfig(t)
+
+	for _, name := range []string{"shot.png", "shot.expires-1h.png"} {
+		sourcePath := filepath.Join(config.ScreenshotDir, name)
+		os.WriteFile(sourcePath, []byte("same pixels"), 0644)
+		if err := processScreenshot(sourcePath, config); err != nil {
+			t.Fatalf("processScreenshot failed: %v", err)
+		}
+	}
+	all := loadAllMetadata(config)
+	if len(all) != 2 || (all[0].ExpiresAt == nil) == (all[1].ExpiresAt == nil) {
+		t.Fatalf("Expected a permanent and an expiring entry, got %+v", all)
+	}
+
+	// The same goes for an upload matching a permanent screenshot
+	req := multipartUpload(t, map[string]string{"again.png": "same pixels"})
+	req.URL.RawQuery = "expires_in=1h"
+	w := httptest.NewRecorder()
+	handleUpload(w, req, config)
+	var response struct {
+		Duplicate bool       `json:"duplicate"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	json.NewDecoder(w.Body).Decode(&response)
+	if w.Code != http.StatusOK || response.Duplicate || response.ExpiresAt == nil {
+		t.Errorf("Expected a new expiring upload, got %d: %+v", w.Code, response)
+	}
+}
+
+func TestExpiredScreenshotsAreGoneAndReaped(t *testing.T) {
+	config, _ := createTestConfig(t)
+	hostedDir := filepath.Join(config.DataDir, "hosted")
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	for _, metadata := range []ScreenshotMetadata{
+		{ID: "expired", Filename: "expired.png", Timestamp: time.Now(), ExpiresAt: &past},
+		{ID: "pending", Filename: "pending.png", Timestamp: time.Now(), ExpiresAt: &future},
+	} {
+		os.WriteFile(filepath.Join(hostedDir, metadata.Filename), []byte(metadata.ID), 0644)
+		config.metadataRepo().Save(metadata)
+	}
+
+	serve := func(target string) int {
+		w := httptest.NewRecorder()
+		serveHostedFile(w, httptest.NewRequest("GET", target, nil), config)
+		return w.Code
+	}
+	if code := serve("/expired.png"); code != http.StatusGone {
+		t.Errorf("Expected status %d for expired screenshot, got %d", http.StatusGone, code)
+	}
+	if code := serve("/pending.png"); code != http.StatusOK {
+		t.Errorf("Expected status %d before the deadline, got %d", http.StatusOK, code)
+	}
+
+	w := httptest.NewRecorder()
+	handleAPIScreenshots(w, httptest.NewRequest("GET", "/api/screenshots", nil), config)
+	var listing struct {
+		Total int `json:"total"`
+	}
+	json.NewDecoder(w.Body).Decode(&listing)
+	if listing.Total != 1 {
+		t.Errorf("Expected expired screenshot to be unlisted, got %d entries", listing.Total)
+	}
+
+	if reaped := reapExpired(config, time.Now()); reaped != 1 {
+		t.Errorf("Expected 1 reaped screenshot, got %d", reaped)
+	}
+	if fileExists(filepath.Join(hostedDir, "expired.png")) || !fileExists(filepath.Join(hostedDir, "pending.png")) {
+		t.Error("Reaper removed the wrong files")
+	}
+	if _, found, _ := config.metadataRepo().Get("expired"); found {
+		t.Error("Expired metadata was not deleted")
+	}
+}
+
+func TestHostedFileCacheHeaders(t *testing.T) {
+	config, _ := createTestConfig(t)
+	store := func(filename string, expiresAt *time.Time) {
+		os.WriteFile(filepath.Join(config.DataDir, "hosted", filename), []byte(filename), 0644)
+		config.metadataRepo().Save(ScreenshotMetadata{ID: filename, Filename: filename, ExpiresAt: expiresAt})
+	}
+	soon, past := time.Now().Add(time.Hour), time.Now().Add(-time.Hour)
+	store("soon.png", &soon)
+	store("gone.png", &past)
+
+	serve := func(filename string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		// What withHeaders sets for every hosted file
+		w.Header().Set("Cache-Control", "public, max-age=86400, immutable")
+		serveHostedFile(w, httptest.NewRequest("GET", "/"+filename, nil), config)
+		return w
+	}
+
+	w := serve("soon.png")
+	var maxAge int
+	if _, err := fmt.Sscanf(w.Header().Get("Cache-Control"), "public, max-age=%d", &maxAge); err != nil || maxAge > 3600 || maxAge < 3500 {
+		t.Errorf("Expected caching up to the expiry, got %q", w.Header().Get("Cache-Control"))
+	}
+	for _, filename := range []string{"gone.png", "missing.png"} {
+		if w := serve(filename); w.Code < 400 || w.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("%s: expected an uncached error, got %d %q", filename, w.Code, w.Header().Get("Cache-Control"))
+		}
+	}
+}
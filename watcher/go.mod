@@ -3,10 +3,9 @@ module ssbnk-watcher
 go 1.21
 
 require (
-    github.com/fsnotify/fsnotify v1.7.0
-    github.com/google/uuid v1.6.0
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/google/uuid v1.6.0
+	go.etcd.io/bbolt v1.3.10
 )
 
-require (
-    golang.org/x/sys v0.4.0 // indirect
-)
+require golang.org/x/sys v0.4.0 // indirect
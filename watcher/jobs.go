@@ -0,0 +1,622 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job kinds
+const (
+	jobScreenshot = "screenshot" // an image in the screenshot directory
+	jobVideo      = "video"      // a finished recording in the screencast directory
+	jobUpload     = "upload"     // a recording sent to /upload, spooled under DataDir/uploads
+)
+
+// Job states
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+const (
+	// jobRetention is how long finished jobs stay listed and pollable
+	jobRetention = 24 * time.Hour
+	// failedJobRetention keeps failed jobs around longer so they can be retried
+	failedJobRetention = 7 * 24 * time.Hour
+	// maxJobBackoff caps the delay between attempts
+	maxJobBackoff = 10 * time.Minute
+
+	// screenshotSettleDelay gives a new screenshot a moment to be fully written
+	screenshotSettleDelay = 100 * time.Millisecond
+
+	defaultJobWorkers  = 2
+	defaultJobAttempts = 3
+	defaultJobBackoff  = 10 * time.Second
+)
+
+var (
+	errJobNotFailed = errors.New("only failed jobs can be retried")
+	// errJobInvalid marks jobs that can't succeed however often they're tried
+	errJobInvalid = errors.New("invalid job")
+	// errJobPanicked marks jobs that crashed; they'd only crash again
+	errJobPanicked = errors.New("job panicked")
+)
+
+// Job is a unit of media processing. Jobs are persisted as JSON under
+// DataDir/jobs so queued work survives a restart.
+type Job struct {
+	ID           string       `json:"id"`
+	Kind         string       `json:"kind"`
+	Status       string       `json:"status"`
+	Path         string       `json:"path"`
+	OriginalName string       `json:"original_name"`
+	Upload       *videoSource `json:"upload,omitempty"` // conversion settings of an upload
+	Attempts     int          `json:"attempts"`
+	NextAttempt  time.Time    `json:"next_attempt"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+	Error        string       `json:"error,omitempty"`
+	URL          string       `json:"url,omitempty"`
+	Filename     string       `json:"filename,omitempty"`
+	VideoURL     string       `json:"video_url,omitempty"`
+	Duplicate    bool         `json:"duplicate,omitempty"`
+	ExpiresAt    *time.Time   `json:"expires_at,omitempty"`
+}
+
+func (job *Job) finished() bool {
+	return job.Status == jobDone || job.Status == jobFailed
+}
+
+// jobQueue runs jobs on a fixed number of workers, retrying failures with
+// exponential backoff
+type jobQueue struct {
+	config Config
+	dir    string
+	wake   chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// jobQueues holds one running queue per data directory
+var jobQueues = struct {
+	sync.Mutex
+	byDir map[string]*jobQueue
+}{byDir: make(map[string]*jobQueue)}
+
+// jobQueue returns the queue for the data directory, loading the persisted
+// jobs and starting the workers on first use. Jobs interrupted by a restart
+// are queued again.
+func (c Config) jobQueue() *jobQueue {
+	jobQueues.Lock()
+	defer jobQueues.Unlock()
+	if queue, ok := jobQueues.byDir[c.DataDir]; ok {
+		return queue
+	}
+
+	workers := c.JobWorkers
+	if workers <= 0 {
+		workers = defaultJobWorkers
+	}
+	queue := &jobQueue{
+		config: c,
+		dir:    filepath.Join(c.DataDir, "jobs"),
+		wake:   make(chan struct{}, workers),
+		jobs:   make(map[string]*Job),
+	}
+	if err := queue.load(); err != nil {
+		log.Printf("Warning: Failed to load jobs: %v", err)
+	}
+	for i := 0; i < workers; i++ {
+		go queue.work()
+	}
+	jobQueues.byDir[c.DataDir] = queue
+	return queue
+}
+
+// load reads the persisted jobs, dropping expired finished ones
+func (q *jobQueue) load() error {
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return err
+	}
+	files, err := os.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+
+	resumed := 0
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(q.dir, file.Name()))
+		if err != nil {
+			log.Printf("⚠️  Failed to read job %s: %v", file.Name(), err)
+			continue
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil || job.ID == "" {
+			log.Printf("⚠️  Invalid job file %s", file.Name())
+			continue
+		}
+		if job.Status == jobRunning {
+			job.Status = jobQueued
+			q.save(&job)
+		}
+		if !job.finished() {
+			resumed++
+		}
+		q.jobs[job.ID] = &job
+	}
+	q.prune()
+	if resumed > 0 {
+		log.Printf("📋 Jobs: resumed %d unfinished jobs", resumed)
+	}
+	return nil
+}
+
+// save persists a job; callers hold q.mu (or own the job exclusively)
+func (q *jobQueue) save(job *Job) {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err == nil {
+		path := filepath.Join(q.dir, job.ID+".json")
+		if err = os.WriteFile(path+".tmp", data, 0644); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to save job %s: %v", job.ID, err)
+	}
+}
+
+// prune forgets finished jobs past their retention; callers hold q.mu
+func (q *jobQueue) prune() {
+	now := time.Now()
+	for id, job := range q.jobs {
+		retention := jobRetention
+		if job.Status == jobFailed {
+			retention = failedJobRetention
+		} else if job.Status != jobDone {
+			continue
+		}
+		if now.Sub(job.UpdatedAt) <= retention {
+			continue
+		}
+		// Failed uploads kept their spooled recording for a retry
+		if job.Kind == jobUpload {
+			os.Remove(job.Path)
+		}
+		os.Remove(filepath.Join(q.dir, id+".json"))
+		delete(q.jobs, id)
+	}
+}
+
+// Enqueue adds a job for path unless one of the same kind is already
+// pending for it, and returns the pending or new job
+func (q *jobQueue) Enqueue(kind, path string, upload *videoSource) Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune()
+
+	for _, job := range q.jobs {
+		if job.Kind == kind && job.Path == path && !job.finished() {
+			return *job
+		}
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:           uuid.New().String(),
+		Kind:         kind,
+		Status:       jobQueued,
+		Path:         path,
+		OriginalName: filepath.Base(path),
+		Upload:       upload,
+		NextAttempt:  now,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if upload != nil {
+		job.OriginalName = upload.OriginalName
+	}
+	if kind == jobScreenshot {
+		job.NextAttempt = now.Add(screenshotSettleDelay)
+	}
+	q.jobs[job.ID] = job
+	q.save(job)
+	q.notify()
+	return *job
+}
+
+// Handled reports whether a file needs no new job: one is pending for it,
+// or one failed since the file last changed (a rejected file stays put
+// until it is replaced or the job is retried)
+func (q *jobQueue) Handled(kind, path string, modTime time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range q.jobs {
+		if job.Kind != kind || job.Path != path {
+			continue
+		}
+		if !job.finished() || (job.Status == jobFailed && job.UpdatedAt.After(modTime)) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify wakes an idle worker
+func (q *jobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Get returns a copy of the job with the given ID
+func (q *jobQueue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// List returns the jobs with the given status (all if empty), newest first
+func (q *jobQueue) List(status string) []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune()
+
+	list := make([]Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		if status == "" || job.Status == status {
+			list = append(list, *job)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Retry queues a failed job again with a fresh set of attempts
+func (q *jobQueue) Retry(id string) (Job, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false, nil
+	}
+	if job.Status != jobFailed {
+		return *job, true, errJobNotFailed
+	}
+	job.Status = jobQueued
+	job.Attempts = 0
+	job.Error = ""
+	job.NextAttempt = time.Now()
+	job.UpdatedAt = job.NextAttempt
+	q.save(job)
+	q.notify()
+	return *job, true, nil
+}
+
+// claim marks the next due job as running. With nothing due it returns nil
+// and how long to wait for the next one.
+func (q *jobQueue) claim() (*Job, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var next *Job
+	for _, job := range q.jobs {
+		if job.Status != jobQueued {
+			continue
+		}
+		if next == nil || job.NextAttempt.Before(next.NextAttempt) ||
+			(job.NextAttempt.Equal(next.NextAttempt) && job.CreatedAt.Before(next.CreatedAt)) {
+			next = job
+		}
+	}
+	if next == nil {
+		return nil, time.Minute
+	}
+	if wait := next.NextAttempt.Sub(now); wait > 0 {
+		return nil, wait
+	}
+
+	next.Status = jobRunning
+	next.Attempts++
+	next.UpdatedAt = now
+	q.save(next)
+	claimed := *next
+	return &claimed, 0
+}
+
+func (q *jobQueue) work() {
+	for {
+		job, wait := q.claim()
+		if job == nil {
+			timer := time.NewTimer(wait)
+			select {
+			case <-q.wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+			continue
+		}
+		q.run(job)
+	}
+}
+
+// run executes a claimed job and records the outcome
+func (q *jobQueue) run(job *Job) {
+	err := q.executeSafely(job)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	current, ok := q.jobs[job.ID]
+	if !ok {
+		return
+	}
+	current.UpdatedAt = time.Now()
+
+	if err == nil {
+		current.Status = jobDone
+		current.Error = ""
+		current.URL, current.Filename, current.VideoURL = job.URL, job.Filename, job.VideoURL
+		current.Duplicate, current.ExpiresAt = job.Duplicate, job.ExpiresAt
+		q.save(current)
+		return
+	}
+
+	current.Error = err.Error()
+	// Missing sources and invalid content fail right away
+	permanent := errors.Is(err, os.ErrNotExist) || errors.Is(err, errInvalidImage) ||
+		errors.Is(err, errInvalidVideo) || errors.Is(err, errJobInvalid) || errors.Is(err, errJobPanicked)
+	if permanent || current.Attempts >= q.attempts() {
+		current.Status = jobFailed
+		log.Printf("❌ Job %s (%s %s) failed after %d attempts: %v", job.ID, job.Kind, job.OriginalName, current.Attempts, err)
+	} else {
+		backoff := q.backoff(current.Attempts)
+		current.Status = jobQueued
+		current.NextAttempt = current.UpdatedAt.Add(backoff)
+		log.Printf("⚠️  Job %s (%s %s) failed, retrying in %s: %v", job.ID, job.Kind, job.OriginalName, backoff, err)
+	}
+	q.save(current)
+}
+
+func (q *jobQueue) attempts() int {
+	if q.config.JobAttempts > 0 {
+		return q.config.JobAttempts
+	}
+	return defaultJobAttempts
+}
+
+// backoff doubles the delay with every failed attempt, up to maxJobBackoff
+func (q *jobQueue) backoff(attempts int) time.Duration {
+	backoff := q.config.JobBackoff
+	if backoff <= 0 {
+		backoff = defaultJobBackoff
+	}
+	for i := 1; i < attempts && backoff < maxJobBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxJobBackoff)
+}
+
+// executeSafely runs a job, turning a panic into an error so a bad file
+// can't take the watcher down
+func (q *jobQueue) executeSafely(job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("❌ Job %s (%s %s) panicked: %v\n%s", job.ID, job.Kind, job.OriginalName, r, debug.Stack())
+			err = fmt.Errorf("%w: %v", errJobPanicked, r)
+		}
+	}()
+	return q.execute(job)
+}
+
+// execute does the work of a job, filling in the result fields of uploads
+func (q *jobQueue) execute(job *Job) error {
+	config := q.config
+	if _, err := os.Stat(job.Path); err != nil {
+		return fmt.Errorf("source file is gone: %w", err)
+	}
+
+	switch job.Kind {
+	case jobScreenshot:
+		return processScreenshot(job.Path, config)
+	case jobVideo:
+		return processVideo(job.Path, config)
+	case jobUpload:
+		if job.Upload == nil {
+			return fmt.Errorf("%w: upload without conversion settings", errJobInvalid)
+		}
+		source := *job.Upload
+		source.Path = job.Path
+		metadata, duplicate, err := runVideoUpload(config, source)
+		if err != nil {
+			return err
+		}
+		job.URL = accessURL(config, metadata)
+		job.Filename = metadata.Filename
+		job.Duplicate = duplicate
+		job.ExpiresAt = metadata.ExpiresAt
+		if metadata.Video != nil {
+			job.VideoURL = videoAccessURL(config, metadata)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unknown kind %q", errJobInvalid, job.Kind)
+}
+
+// jobStatusURL is where clients poll a job
+func jobStatusURL(config Config, id string) string {
+	return fmt.Sprintf("%s/upload/jobs/%s", config.BaseURL, id)
+}
+
+// handleVideoUpload queues an uploaded recording for conversion and answers
+// 202 with the job to poll. Recordings are uploaded one per request.
+func handleVideoUpload(w http.ResponseWriter, config Config, form *uploadForm, defaults ScreenshotMetadata) {
+	if len(form.Files) != 1 {
+		http.Error(w, "Recordings must be uploaded one per request", http.StatusBadRequest)
+		return
+	}
+
+	// The preset comes from the "preset" field, tweaked by the same
+	// parameters as SSBNK_GIF_<PARAM>
+	preset := config.GIFPreset
+	if name := form.Values.Get("preset"); name != "" {
+		named, err := gifPresetNamed(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		preset = named
+	}
+	preset, err := withGIFParams(preset, form.Values.Get)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	file := form.Keep(0)
+	job := config.jobQueue().Enqueue(jobUpload, file.Path, &videoSource{
+		OriginalName: file.Filename,
+		Preset:       preset,
+		Defaults:     defaults,
+	})
+	log.Printf("UPLOAD: Queued %s (%s) as job %s", file.Filename, formatBytes(file.Size), job.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"job_id":     job.ID,
+		"status":     job.Status,
+		"status_url": jobStatusURL(config, job.ID),
+	})
+}
+
+// runVideoUpload converts an uploaded recording and saves the GIF's
+// metadata. The spooled upload is removed once it succeeds.
+func runVideoUpload(config Config, source videoSource) (ScreenshotMetadata, bool, error) {
+	metadata, duplicate, err := convertVideo(config, source)
+	if err != nil {
+		return ScreenshotMetadata{}, false, err
+	}
+
+	if !duplicate {
+		if err := config.metadataRepo().Save(metadata); err != nil {
+			log.Printf("UPLOAD: Failed to save metadata: %v", err)
+		}
+		writeLastScreenshotPath(metadata.Filename)
+	}
+
+	// Copy URL to clipboard so pasting on the host works immediately
+	if err := copyToClipboard(shareURL(config, metadata)); err != nil {
+		log.Printf("UPLOAD: Warning: Failed to copy to clipboard: %v", err)
+	}
+
+	os.Remove(source.Path)
+	log.Printf("UPLOAD: %s -> %s", source.OriginalName, metadata.URL)
+	return metadata, duplicate, nil
+}
+
+// handleUploadJob serves GET /upload/jobs/{id} for the uploader
+func handleUploadJob(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeUpload(w, r) {
+		return
+	}
+
+	job, ok := config.jobQueue().Get(strings.TrimPrefix(r.URL.Path, "/upload/jobs/"))
+	if !ok || job.Kind != jobUpload {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleAPIJobs serves GET /api/jobs, GET /api/jobs/{id} and
+// POST /api/jobs/{id}/retry
+func handleAPIJobs(w http.ResponseWriter, r *http.Request, config Config) {
+	if !authorizeAPIRequest(w, r) {
+		return
+	}
+	queue := config.jobQueue()
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs"), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "", jobQueued, jobRunning, jobDone, jobFailed:
+		default:
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+		jobs := queue.List(status)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"jobs":  jobs,
+			"total": len(jobs),
+		})
+		return
+	}
+
+	id, retry := strings.CutSuffix(path, "/retry")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	var job Job
+	var found bool
+	var err error
+	switch {
+	case retry && r.Method == http.MethodPost:
+		job, found, err = queue.Retry(id)
+	case !retry && r.Method == http.MethodGet:
+		job, found = queue.Get(id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !found {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errJobNotFailed) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if retry {
+		log.Printf("📋 Jobs: retrying %s (%s %s)", job.ID, job.Kind, job.OriginalName)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForJob polls /upload/jobs/{id} until the job has finished
+func waitForJob(t *testing.T, config Config, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		req := httptest.NewRequest("GET", "/upload/jobs/"+id, nil)
+		req.Header.Set("X-Upload-Key", "upload-key")
+		w := httptest.NewRecorder()
+		handleUploadJob(w, req, config)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d polling the job, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var job Job
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatalf("Failed to decode job: %v", err)
+		}
+		if job.Status == jobDone || job.Status == jobFailed {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Job still %s", job.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestVideoUploadRunsJob(t *testing.T) {
+	t.Setenv("SSBNK_UPLOAD_KEY", "upload-key")
+	installFakeTool(t, "ffprobe", "echo matroska,webm\n")
+	installFakeTool(t, "ffmpeg", `for arg; do out="$arg"; done
+printf 'GIF89a converted' > "$out"
+`)
+	config, _ := createTestConfig(t)
+
+	req := multipartUpload(t, map[string]string{"demo.webm": "recording"})
+	req.URL.RawQuery = "preset=short&fps=5&repo=ssbnk"
+	w := httptest.NewRecorder()
+	handleUpload(w, req, config)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	var response struct {
+		JobID     string `json:"job_id"`
+		Status    string `json:"status"`
+		StatusURL string `json:"status_url"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response.Status != jobQueued || !strings.HasSuffix(response.StatusURL, "/upload/jobs/"+response.JobID) {
+		t.Errorf("Unexpected response %+v", response)
+	}
+
+	job := waitForJob(t, config, response.JobID)
+	if job.Status != jobDone || job.Filename == "" || job.URL == "" {
+		t.Fatalf("Unexpected job %+v", job)
+	}
+
+	all := loadAllMetadata(config)
+	if len(all) != 1 || all[0].Filename != job.Filename {
+		t.Fatalf("Expected the GIF in the metadata, got %+v", all)
+	}
+	if all[0].OriginalName != "demo.webm" || all[0].RepoName != "ssbnk" {
+		t.Errorf("Upload fields not applied: %+v", all[0])
+	}
+	if preset := all[0].GIFPreset; preset == nil || preset.Name != "short" || preset.FPS != 5 {
+		t.Errorf("Unexpected preset %+v", preset)
+	}
+	if entries, _ := os.ReadDir(uploadDir(config)); len(entries) != 0 {
+		t.Errorf("Spooled upload left behind: %v", entries)
+	}
+
+	// The same recording again is a duplicate of the hosted GIF
+	w = httptest.NewRecorder()
+	handleUpload(w, multipartUpload(t, map[string]string{"again.webm": "recording"}), config)
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if job := waitForJob(t, config, response.JobID); !job.Duplicate || job.Filename != all[0].Filename {
+		t.Errorf("Expected a duplicate of %s, got %+v", all[0].Filename, job)
+	}
+}
+
+func TestVideoUploadRejections(t *testing.T) {
+	t.Setenv("SSBNK_UPLOAD_KEY", "upload-key")
+	config, _ := createTestConfig(t)
+	config.MaxVideoUpload = 8
+
+	tests := []struct {
+		name   string
+		files  map[string]string
+		query  string
+		status int
+	}{
+		{"OverCap", map[string]string{"demo.webm": "too long a recording"}, "", http.StatusRequestEntityTooLarge},
+		{"WithImage", map[string]string{"demo.webm": "video", "shot.png": "png"}, "", http.StatusBadRequest},
+		{"UnknownPreset", map[string]string{"demo.webm": "video"}, "preset=huge", http.StatusBadRequest},
+		{"BadParam", map[string]string{"demo.webm": "video"}, "fps=0", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := multipartUpload(t, tt.files)
+			req.URL.RawQuery = tt.query
+			w := httptest.NewRecorder()
+			handleUpload(w, req, config)
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if entries, _ := os.ReadDir(uploadDir(config)); len(entries) != 0 {
+				t.Errorf("Spooled upload left behind: %v", entries)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	handleUploadJob(w, httptest.NewRequest("GET", "/upload/jobs/missing", nil), config)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the upload key, got %d", w.Code)
+	}
+}
+
+// flakyStorage fails the first few Create calls
+type flakyStorage struct {
+	HostedStorage
+	failures atomic.Int32
+}
+
+func (s *flakyStorage) Create(name string, content io.ReadSeeker) (int64, error) {
+	if s.failures.Add(-1) >= 0 {
+		return 0, errors.New("storage unavailable")
+	}
+	return s.HostedStorage.Create(name, content)
+}
+
+// panickingStorage panics on Create
+type panickingStorage struct {
+	HostedStorage
+}
+
+func (s panickingStorage) Create(name string, content io.ReadSeeker) (int64, error) {
+	panic("storage exploded")
+}
+
+// waitForQueuedJob waits until a job of the queue has finished
+func waitForQueuedJob(t *testing.T, queue *jobQueue, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		job, ok := queue.Get(id)
+		if !ok {
+			t.Fatalf("Job %s not found", id)
+		}
+		if job.finished() {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Job still %s", job.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestJobQueueRetriesWithBackoff(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.JobBackoff = 10 * time.Millisecond
+	storage := &flakyStorage{HostedStorage: config.hostedStorage()}
+	storage.failures.Store(1)
+	config.Storage = storage
+
+	sourcePath := filepath.Join(config.ScreenshotDir, "shot.png")
+	os.WriteFile(sourcePath, []byte("screenshot"), 0644)
+
+	queue := config.jobQueue()
+	job := queue.Enqueue(jobScreenshot, sourcePath, nil)
+	if again := queue.Enqueue(jobScreenshot, sourcePath, nil); again.ID != job.ID {
+		t.Errorf("Expected a second event for the same file to reuse job %s, got %s", job.ID, again.ID)
+	}
+
+	job = waitForQueuedJob(t, queue, job.ID)
+	if job.Status != jobDone || job.Attempts != 2 {
+		t.Fatalf("Expected done after 2 attempts, got %+v", job)
+	}
+	if len(loadAllMetadata(config)) != 1 || fileExists(sourcePath) {
+		t.Error("Screenshot not processed")
+	}
+
+	if got := queue.backoff(3); got != 40*time.Millisecond {
+		t.Errorf("Expected the backoff to double per attempt, got %s", got)
+	}
+	if got := queue.backoff(30); got != maxJobBackoff {
+		t.Errorf("Expected the backoff capped at %s, got %s", maxJobBackoff, got)
+	}
+}
+
+func TestJobQueueResumesAndRetries(t *testing.T) {
+	t.Setenv("SSBNK_API_KEY", "test-key")
+	config, _ := createTestConfig(t)
+
+	// A job that was running when the watcher stopped
+	sourcePath := filepath.Join(config.ScreenshotDir, "interrupted.png")
+	os.WriteFile(sourcePath, []byte("interrupted"), 0644)
+	interrupted := Job{ID: "interrupted", Kind: jobScreenshot, Status: jobRunning, Path: sourcePath, Attempts: 1, CreatedAt: time.Now()}
+	data, _ := json.Marshal(interrupted)
+	os.MkdirAll(filepath.Join(config.DataDir, "jobs"), 0755)
+	os.WriteFile(filepath.Join(config.DataDir, "jobs", "interrupted.json"), data, 0644)
+
+	queue := config.jobQueue()
+	if job := waitForQueuedJob(t, queue, "interrupted"); job.Status != jobDone {
+		t.Fatalf("Interrupted job not resumed: %+v", job)
+	}
+
+	// A missing source fails right away
+	missingPath := filepath.Join(config.ScreenshotDir, "missing.png")
+	failed := waitForQueuedJob(t, queue, queue.Enqueue(jobScreenshot, missingPath, nil).ID)
+	if failed.Status != jobFailed || failed.Attempts != 1 || failed.Error == "" {
+		t.Fatalf("Expected an immediate failure, got %+v", failed)
+	}
+
+	w := httptest.NewRecorder()
+	handleAPIJobs(w, apiRequest("GET", "/api/jobs?status=failed", ""), config)
+	var list struct {
+		Jobs  []Job `json:"jobs"`
+		Total int   `json:"total"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if w.Code != http.StatusOK || list.Total != 1 || list.Jobs[0].ID != failed.ID {
+		t.Errorf("Expected the failed job listed, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIJobs(w, apiRequest("POST", "/api/jobs/interrupted/retry", ""), config)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 retrying a finished job, got %d", w.Code)
+	}
+
+	// Once the file is there, a retry succeeds
+	os.WriteFile(missingPath, []byte("found"), 0644)
+	w = httptest.NewRecorder()
+	handleAPIJobs(w, apiRequest("POST", "/api/jobs/"+failed.ID+"/retry", ""), config)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 retrying, got %d: %s", w.Code, w.Body.String())
+	}
+	if job := waitForQueuedJob(t, queue, failed.ID); job.Status != jobDone || job.Error != "" {
+		t.Errorf("Expected the retried job done, got %+v", job)
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIJobs(w, httptest.NewRequest("GET", "/api/jobs", nil), config)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the API key, got %d", w.Code)
+	}
+}
+
+func TestJobQueueRecoversPanics(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.Storage = panickingStorage{HostedStorage: config.hostedStorage()}
+
+	sourcePath := filepath.Join(config.ScreenshotDir, "crash.png")
+	os.WriteFile(sourcePath, []byte("crash"), 0644)
+
+	queue := config.jobQueue()
+	job := waitForQueuedJob(t, queue, queue.Enqueue(jobScreenshot, sourcePath, nil).ID)
+	if job.Status != jobFailed || job.Attempts != 1 || !strings.Contains(job.Error, "storage exploded") {
+		t.Fatalf("Expected the panic recorded as a failure, got %+v", job)
+	}
+
+	// The failure is persisted, so a restart doesn't run it again
+	data, _ := os.ReadFile(filepath.Join(config.DataDir, "jobs", job.ID+".json"))
+	var saved Job
+	json.Unmarshal(data, &saved)
+	if saved.Status != jobFailed {
+		t.Errorf("Expected the failed job saved, got %q", saved.Status)
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,6 +16,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -21,16 +24,28 @@ import (
 )
 
 type ScreenshotMetadata struct {
-	ID           string    `json:"id"`
-	OriginalName string    `json:"original_name"`
-	Filename     string    `json:"filename"`
-	URL          string    `json:"url"`
-	Timestamp    time.Time `json:"timestamp"`
-	Description  string    `json:"description,omitempty"`
-	BatchID      string    `json:"batch_id,omitempty"`
-	Preserve     bool      `json:"preserve"`
-	RepoName     string    `json:"repo_name,omitempty"`
-	Size         int64     `json:"size"`
+	ID           string      `json:"id"`
+	OriginalName string      `json:"original_name"`
+	Filename     string      `json:"filename"`
+	URL          string      `json:"url"`
+	Timestamp    time.Time   `json:"timestamp"`
+	Description  string      `json:"description,omitempty"`
+	BatchID      string      `json:"batch_id,omitempty"`
+	Preserve     bool        `json:"preserve"`
+	RepoName     string      `json:"repo_name,omitempty"`
+	Size         int64       `json:"size"`
+	Tags         []string    `json:"tags,omitempty"`
+	OCRText      string      `json:"ocr_text,omitempty"`
+	SHA256       string      `json:"sha256,omitempty"`
+	Private      bool        `json:"private,omitempty"`
+	ExpiresAt    *time.Time  `json:"expires_at,omitempty"`
+	RestoredAt   *time.Time  `json:"restored_at,omitempty"`
+	OriginalSize int64       `json:"original_size,omitempty"` // size before optimization, when it changed the file
+	Thumbnails   []Thumbnail `json:"thumbnails,omitempty"`
+	Sanitized    []string    `json:"sanitized,omitempty"` // metadata stripped from an upload: gps, device, xmp, iptc, exif
+	Redaction    *Redaction  `json:"redaction,omitempty"`
+	GIFPreset    *GIFPreset  `json:"gif_preset,omitempty"` // settings a screencast was converted with
+	Video        *Video      `json:"video,omitempty"`      // full-quality transcode hosted next to the GIF
 }
 
 type Config struct {
@@ -38,14 +53,127 @@ type Config struct {
 	ScreencastDir string
 	DataDir       string
 	BaseURL       string
+	MetadataRepo  MetadataRepository
+	Storage       HostedStorage
+	OCREnabled    bool
+	BatchWindow   time.Duration
+	// FilenameTemplate builds hosted filenames from {timestamp}, {date},
+	// {slug}, {hash}, {original} and {repo}; see naming.go
+	FilenameTemplate string
+	RepoMap          map[string]string // screenshot subdirectory -> repository
+	URLSecret        []byte            // HMAC key for signed URLs; signing is off when empty
+	SignedURLTTL     time.Duration     // lifetime of links for private screenshots
+	// Retention archives screenshots older than RetentionDays every
+	// RetentionInterval and deletes archives older than ArchiveRetentionDays;
+	// zero disables each step
+	RetentionDays        int
+	ArchiveRetentionDays int
+	RetentionInterval    time.Duration
+	StorageQuota         int64 // max bytes in DataDir/hosted; the oldest screenshots are evicted past it, 0 disables
+	ThumbnailWidths      []int // widths of the thumbnails generated on ingest, ascending; empty disables
+	// OptimizeWatch and OptimizeUpload pick the optimization mode (off,
+	// png, webp, avif) for the watch directories and /upload; see optimize.go
+	OptimizeWatch       string
+	OptimizeUpload      string
+	StripUploadMetadata bool // remove GPS and device details from uploads
+	// RedactPatterns are the secrets blacked out of new screenshots (empty
+	// disables). Without working OCR, images aren't published at all. The
+	// unredacted original is kept in DataDir/originals when
+	// RedactKeepOriginal is set.
+	RedactPatterns     []secretPattern
+	RedactKeepOriginal bool
+	// ValidateImages checks new files by content (format, size limits,
+	// embedded markup) before hosting them; see validate.go
+	ValidateImages bool
+	MaxImagePixels int // largest width*height accepted when validating
+	// GIFPreset holds the screencast conversion settings; see video.go.
+	// VideoFormat (off, mp4, webm) also keeps a full-quality video.
+	// MaxVideoUpload caps each recording sent to /upload.
+	GIFPreset      GIFPreset
+	VideoFormat    string
+	MaxVideoUpload int64
+	// MaxUploadSize and MaxUploadFiles cap a whole /upload request; see
+	// upload.go for the defaults
+	MaxUploadSize  int64
+	MaxUploadFiles int
+	// JobWorkers bounds how many screenshots and recordings are processed
+	// at once; failed jobs are tried JobAttempts times, waiting JobBackoff
+	// (doubling) in between. See jobs.go.
+	JobWorkers  int
+	JobAttempts int
+	JobBackoff  time.Duration
+	// ReconcileInterval is how often the watch directories are scanned for
+	// files fsnotify missed (0 scans only at startup); see reconcile.go
+	ReconcileInterval time.Duration
+}
+
+// metadataRepo returns the configured metadata repository, defaulting to the
+// one-JSON-file-per-screenshot layout under DataDir/metadata.
+func (c Config) metadataRepo() MetadataRepository {
+	if c.MetadataRepo != nil {
+		return c.MetadataRepo
+	}
+	return newJSONMetadataRepository(filepath.Join(c.DataDir, "metadata"))
 }
 
 func main() {
+	// Short URLs publish under random slugs instead of guessable timestamps
+	filenameTemplate := defaultFilenameTemplate
+	if getEnv("SSBNK_SHORT_URLS", "false") == "true" {
+		filenameTemplate = "{slug}"
+	}
+
 	config := Config{
-		ScreenshotDir: getEnv("SSBNK_SCREENSHOT_DIR", "/media/screenshots"),
-		ScreencastDir: getEnv("SSBNK_SCREENCAST_DIR", "/media/screencasts"),
-		DataDir:       getEnv("SSBNK_DATA_DIR", "/data"),
-		BaseURL:       getEnv("SSBNK_URL", "https://ss.yourdomain.com"),
+		ScreenshotDir:    getEnv("SSBNK_SCREENSHOT_DIR", "/media/screenshots"),
+		ScreencastDir:    getEnv("SSBNK_SCREENCAST_DIR", "/media/screencasts"),
+		DataDir:          getEnv("SSBNK_DATA_DIR", "/data"),
+		BaseURL:          getEnv("SSBNK_URL", "https://ss.yourdomain.com"),
+		OCREnabled:       resolveOCR(),
+		BatchWindow:      getEnvDuration("SSBNK_BATCH_WINDOW", 0),
+		FilenameTemplate: getEnv("SSBNK_FILENAME_TEMPLATE", filenameTemplate),
+		RepoMap:          parseRepoMap(os.Getenv("SSBNK_REPO_MAP")),
+		SignedURLTTL:     getEnvDuration("SSBNK_SIGNED_URL_TTL", defaultSignedURLTTL),
+		RetentionDays:    getEnvInt("SSBNK_RETENTION_DAYS", 30),
+		ThumbnailWidths:  parseThumbnailWidths(getEnv("SSBNK_THUMBNAIL_WIDTHS", defaultThumbnailWidths)),
+		OptimizeWatch:    parseOptimizeMode("SSBNK_OPTIMIZE_WATCH"),
+		OptimizeUpload:   parseOptimizeMode("SSBNK_OPTIMIZE_UPLOAD"),
+	}
+	config.StripUploadMetadata = getEnv("SSBNK_STRIP_METADATA", "true") == "true"
+	config.RedactPatterns = loadSecretPatterns()
+	config.RedactKeepOriginal = getEnv("SSBNK_REDACT_ORIGINAL", "private") != "discard"
+	config.ValidateImages = getEnv("SSBNK_VALIDATE_IMAGES", "true") == "true"
+	config.MaxImagePixels = getEnvInt("SSBNK_MAX_IMAGE_PIXELS", defaultMaxImagePixels)
+	config.GIFPreset = loadGIFPreset()
+	config.MaxVideoUpload = defaultMaxVideoUpload
+	if val := os.Getenv("SSBNK_MAX_VIDEO_UPLOAD"); val != "" {
+		size, err := parseByteSize(val)
+		if err != nil || size <= 0 {
+			log.Printf("Warning: Invalid SSBNK_MAX_VIDEO_UPLOAD=%q, using %s", val, formatBytes(defaultMaxVideoUpload))
+		} else {
+			config.MaxVideoUpload = size
+		}
+	}
+	if val := os.Getenv("SSBNK_MAX_UPLOAD_SIZE"); val != "" {
+		size, err := parseByteSize(val)
+		if err != nil {
+			log.Fatal("Invalid SSBNK_MAX_UPLOAD_SIZE:", err)
+		}
+		config.MaxUploadSize = size
+	}
+	config.MaxUploadFiles = getEnvInt("SSBNK_MAX_UPLOAD_FILES", defaultMaxUploadFiles)
+	config.VideoFormat = parseVideoFormat("SSBNK_VIDEO_FORMAT")
+	config.JobWorkers = getEnvInt("SSBNK_JOB_WORKERS", defaultJobWorkers)
+	config.JobAttempts = getEnvInt("SSBNK_JOB_ATTEMPTS", defaultJobAttempts)
+	config.JobBackoff = getEnvDuration("SSBNK_JOB_BACKOFF", defaultJobBackoff)
+	config.ReconcileInterval = getEnvDuration("SSBNK_RECONCILE_INTERVAL", 10*time.Minute)
+	config.ArchiveRetentionDays = getEnvInt("SSBNK_ARCHIVE_RETENTION_DAYS", config.RetentionDays)
+	config.RetentionInterval = getEnvDuration("SSBNK_RETENTION_INTERVAL", 24*time.Hour)
+	if val := os.Getenv("SSBNK_STORAGE_QUOTA"); val != "" {
+		quota, err := parseByteSize(val)
+		if err != nil {
+			log.Fatal("Invalid SSBNK_STORAGE_QUOTA:", err)
+		}
+		config.StorageQuota = quota
 	}
 
 	log.Printf("Starting ssbnk watcher...")
@@ -53,6 +181,53 @@ func main() {
 	log.Printf("Video watch directory: %s", config.ScreencastDir)
 	log.Printf("Data directory: %s", config.DataDir)
 	log.Printf("Base URL: %s", config.BaseURL)
+	log.Printf("OCR enabled: %v", config.OCREnabled)
+	log.Printf("Filename template: %s", config.FilenameTemplate)
+	if err := validateFilenameTemplate(config.FilenameTemplate); err != nil {
+		log.Fatal("Invalid SSBNK_FILENAME_TEMPLATE:", err)
+	}
+	if config.BatchWindow > 0 {
+		log.Printf("Batch window: %s", config.BatchWindow)
+	}
+	log.Printf("Retention: %d days (archives kept %d days)", config.RetentionDays, config.ArchiveRetentionDays)
+	if config.StorageQuota > 0 {
+		log.Printf("Storage quota: %s", formatBytes(config.StorageQuota))
+	}
+	if len(config.ThumbnailWidths) > 0 {
+		log.Printf("Thumbnail widths: %v", config.ThumbnailWidths)
+	}
+	log.Printf("GIF preset: %s (%+v)", config.GIFPreset.Name, config.GIFPreset)
+	if config.VideoFormat != videoOff {
+		log.Printf("Screencasts are also kept as %s", config.VideoFormat)
+	}
+	log.Printf("Video uploads: max %s", formatBytes(config.MaxVideoUpload))
+	log.Printf("Upload requests: max %s, %d files", formatBytes(maxUploadSize(config)), maxUploadFiles(config))
+	log.Printf("Job queue: %d workers, %d attempts, backoff %s", config.JobWorkers, config.JobAttempts, config.JobBackoff)
+	if config.ReconcileInterval > 0 {
+		log.Printf("Reconcile: scanning watch directories every %s", config.ReconcileInterval)
+	} else {
+		log.Printf("Reconcile: scanning watch directories at startup only")
+	}
+	if config.ValidateImages {
+		log.Printf("Image validation: max %d pixels", config.MaxImagePixels)
+	} else {
+		log.Printf("Warning: Image validation disabled, files are hosted by extension")
+	}
+	log.Printf("Optimization: watch=%s upload=%s", config.OptimizeWatch, config.OptimizeUpload)
+	for _, mode := range []string{config.OptimizeWatch, config.OptimizeUpload} {
+		if encoder := optimizeEncoder(mode); encoder != "" {
+			if _, err := exec.LookPath(encoder); err != nil {
+				log.Printf("Warning: %s optimization needs %s, which is not installed; PNGs will only be recompressed", mode, encoder)
+			}
+		}
+	}
+	if len(config.RedactPatterns) > 0 {
+		if config.OCREnabled {
+			log.Printf("Redaction: %d secret patterns (unredacted originals kept: %v)", len(config.RedactPatterns), config.RedactKeepOriginal)
+		} else {
+			log.Printf("Warning: SSBNK_REDACT=true needs OCR (tesseract); images will be held back until it is installed")
+		}
+	}
 
 	// Log display server information
 	if isWayland() {
@@ -69,6 +244,30 @@ func main() {
 	if err := os.MkdirAll(filepath.Join(config.DataDir, "metadata"), 0755); err != nil {
 		log.Fatal("Failed to create metadata directory:", err)
 	}
+	if err := os.MkdirAll(archiveRoot(config), 0755); err != nil {
+		log.Fatal("Failed to create archive directory:", err)
+	}
+
+	urlSecret, err := loadURLSecret(config.DataDir)
+	if err != nil {
+		log.Fatal("Failed to load URL signing secret:", err)
+	}
+	config.URLSecret = urlSecret
+
+	repo, err := openMetadataRepository(config)
+	if err != nil {
+		log.Fatal("Failed to open metadata repository:", err)
+	}
+	defer repo.Close()
+	config.MetadataRepo = repo
+	log.Printf("Metadata backend: %s", getEnv("SSBNK_METADATA_BACKEND", "json"))
+
+	storage, err := openHostedStorage(config)
+	if err != nil {
+		log.Fatal("Failed to open hosted storage:", err)
+	}
+	config.Storage = storage
+	log.Printf("Storage backend: %s", getEnv("SSBNK_STORAGE_BACKEND", "local"))
 
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -76,6 +275,30 @@ func main() {
 	}
 	defer watcher.Close()
 
+	// Serve metadata and hosted listings from memory, kept in sync by the watcher
+	var cache *metadataCache
+	if getEnv("SSBNK_METADATA_CACHE", "true") == "true" {
+		// Only a local hosted directory can be watched for changes
+		hostedDir := ""
+		if local, ok := storage.(*localStorage); ok {
+			hostedDir = local.dir
+		}
+		cache = newMetadataCache(repo, hostedDir)
+		if err := cache.Rescan(); err != nil {
+			log.Fatal("Failed to build metadata cache:", err)
+		}
+		if err := cache.addWatches(watcher); err != nil {
+			log.Fatal("Failed to watch data directories:", err)
+		}
+		config.MetadataRepo = cache
+	}
+
+	// Hash screenshots stored before deduplication existed
+	go backfillHashes(config)
+
+	// Resume jobs queued before a restart
+	queue := config.jobQueue()
+
 	// Start watching
 	go func() {
 		for {
@@ -84,22 +307,31 @@ func main() {
 				if !ok {
 					return
 				}
-				// For screenshots, process on create/rename in a goroutine so the watcher loop never blocks.
+				// Metadata/hosted changes only update the cache
+				if cache != nil && cache.handleEvent(event) {
+					continue
+				}
+
+				// New per-repository folders in the screenshot directory get watched too
+				if event.Op&fsnotify.Create == fsnotify.Create && filepath.Dir(event.Name) == config.ScreenshotDir {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						if err := watcher.Add(event.Name); err != nil {
+							log.Printf("Warning: Failed to watch %s: %v", event.Name, err)
+						}
+						continue
+					}
+				}
+
+				// Screenshots are queued on create/rename and processed by the workers
 				if (event.Op&fsnotify.Create == fsnotify.Create || event.Op&fsnotify.Rename == fsnotify.Rename) && isImageFile(event.Name) {
 					log.Printf("New screenshot detected: %s", event.Name)
-					go func(path string) {
-						// Small delay to ensure file is fully written
-						time.Sleep(100 * time.Millisecond)
-						if err := processScreenshot(path, config); err != nil {
-							log.Printf("Error processing screenshot: %v", err)
-						}
-					}(event.Name)
+					queue.Enqueue(jobScreenshot, event.Name, nil)
 				}
 
 				// For videos, we need to track them and wait for write completion
 				if (event.Op&fsnotify.Create == fsnotify.Create || event.Op&fsnotify.Rename == fsnotify.Rename) && isVideoFile(event.Name) {
 					log.Printf("Video recording started: %s", event.Name)
-					// Track this video file for completion
+					// Track this video file for completion, then queue it
 					go trackVideoFile(event.Name, config)
 				}
 			case err, ok := <-watcher.Errors:
@@ -107,6 +339,12 @@ func main() {
 					return
 				}
 				log.Printf("Watcher error: %v", err)
+				// Dropped events leave the cache unreliable; rebuild it
+				if cache != nil && errors.Is(err, fsnotify.ErrEventOverflow) {
+					if err := cache.Rescan(); err != nil {
+						log.Printf("Error rescanning metadata cache: %v", err)
+					}
+				}
 			}
 		}
 	}()
@@ -116,6 +354,8 @@ func main() {
 		log.Fatal("Failed to add watch directory:", err)
 	}
 
+	watchSubdirectories(watcher.Add, config.ScreenshotDir)
+
 	err = watcher.Add(config.ScreencastDir)
 	if err != nil {
 		log.Fatal("Failed to add video watch directory:", err)
@@ -124,9 +364,18 @@ func main() {
 	log.Printf("Watching for screenshots in %s", config.ScreenshotDir)
 	log.Printf("Watching for videos in %s", config.ScreencastDir)
 
+	// Pick up files that landed while the watcher was down
+	go startReconcile(config)
+
 	// Start HTTP server for API endpoints
 	go startAPIServer(config)
 
+	// Archive old screenshots on a schedule
+	go startRetention(config)
+
+	// Delete screenshots whose expiry has passed
+	go startExpiryReaper(config, getEnvDuration("SSBNK_EXPIRY_INTERVAL", time.Minute))
+
 	// Start memory logger
 	go logMemoryUsage()
 
@@ -141,6 +390,9 @@ func startAPIServer(config Config) {
 	mux.HandleFunc("/api/screenshots", func(w http.ResponseWriter, r *http.Request) {
 		handleAPIScreenshots(w, r, config)
 	})
+	mux.HandleFunc("/api/screenshots/", func(w http.ResponseWriter, r *http.Request) {
+		handleAPIScreenshot(w, r, config)
+	})
 	mux.HandleFunc("/latest", func(w http.ResponseWriter, r *http.Request) {
 		handleLatest(w, r, config)
 	})
@@ -162,13 +414,38 @@ func startAPIServer(config Config) {
 	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
 		handleUpload(w, r, config)
 	})
+	mux.HandleFunc("/upload/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		handleUploadJob(w, r, config)
+	})
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		handleHealthCheck(w, r, config)
 	})
+	mux.HandleFunc("/api/batches/", func(w http.ResponseWriter, r *http.Request) {
+		handleAPIBatch(w, r, config)
+	})
+	mux.HandleFunc("/b/", func(w http.ResponseWriter, r *http.Request) {
+		handleBatchPage(w, r, config)
+	})
+	mux.HandleFunc("/api/rescan", func(w http.ResponseWriter, r *http.Request) {
+		handleRescan(w, r, config)
+	})
+	mux.HandleFunc("/api/retention", func(w http.ResponseWriter, r *http.Request) {
+		handleRetention(w, r, config)
+	})
+	mux.HandleFunc("/api/archive", func(w http.ResponseWriter, r *http.Request) {
+		handleAPIArchive(w, r, config)
+	})
+	mux.HandleFunc("/api/archive/", func(w http.ResponseWriter, r *http.Request) {
+		handleAPIArchive(w, r, config)
+	})
+	mux.HandleFunc("/api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		handleAPIJobs(w, r, config)
+	})
+	mux.HandleFunc("/api/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		handleAPIJobs(w, r, config)
+	})
 
 	// Static file servers
-	hostedDir := filepath.Join(config.DataDir, "hosted")
-	hostedFS := http.FileServer(http.Dir(hostedDir))
 	uiDir := getEnv("SSBNK_UI_DIR", "/ui")
 	uiFS := http.FileServer(http.Dir(uiDir))
 
@@ -182,9 +459,10 @@ func startAPIServer(config Config) {
 			return
 		}
 
-		// Image/gif files: serve from hosted directory
-		if isImageFile(path) {
-			hostedFS.ServeHTTP(w, r)
+		// Image/gif and video files: serve from hosted storage, enforcing
+		// private visibility and signed links
+		if isImageFile(path) || isVideoFile(path) {
+			serveHostedFile(w, r, config)
 			return
 		}
 
@@ -209,11 +487,11 @@ func withHeaders(next http.Handler) http.Handler {
 		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
 		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Upload-Key, X-API-Key")
 
 		// Cache static assets
-		if isImageFile(r.URL.Path) {
+		if isImageFile(r.URL.Path) || isVideoFile(r.URL.Path) {
 			w.Header().Set("Cache-Control", "public, max-age=86400, immutable")
 		}
 
@@ -226,7 +504,8 @@ func withHeaders(next http.Handler) http.Handler {
 	})
 }
 
-// handleAPIScreenshots returns screenshot metadata as JSON for the UI
+// handleAPIScreenshots returns screenshot metadata as JSON for the UI,
+// filtered and ordered by the parameters understood by parseScreenshotQuery
 func handleAPIScreenshots(w http.ResponseWriter, r *http.Request, config Config) {
 	limit := 50
 	offset := 0
@@ -242,48 +521,39 @@ func handleAPIScreenshots(w http.ResponseWriter, r *http.Request, config Config)
 		}
 	}
 
-	// Load metadata and sort by timestamp desc
-	allMetadata := loadAllMetadata(config)
-	sort.Slice(allMetadata, func(i, j int) bool {
-		return allMetadata[i].Timestamp.After(allMetadata[j].Timestamp)
-	})
+	query, err := parseScreenshotQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Fill gaps: include hosted files missing metadata
-	metadataFilenames := make(map[string]bool)
-	for _, m := range allMetadata {
-		metadataFilenames[m.Filename] = true
+	// Private screenshots are only listed for requests carrying the API
+	// key; expired ones are gone for everybody
+	showPrivate := apiKeyValid(r)
+	now := time.Now()
+	filter := func(m ScreenshotMetadata) bool {
+		return (showPrivate || !m.Private) && !isExpired(m, now) && query.matches(m)
 	}
-	for _, filename := range scanHostedFilesForLatest(config) {
-		if !metadataFilenames[filename] {
-			hostedPath := filepath.Join(config.DataDir, "hosted", filename)
-			info, err := os.Stat(hostedPath)
-			if err != nil {
-				continue
-			}
-			allMetadata = append(allMetadata, ScreenshotMetadata{
-				Filename:  filename,
-				URL:       fmt.Sprintf("%s/%s", config.BaseURL, filename),
-				Timestamp: info.ModTime(),
-				Size:      info.Size(),
-			})
+
+	// Fill gaps: include hosted files missing metadata
+	var untracked []ScreenshotMetadata
+	for _, metadata := range untrackedHostedFiles(config) {
+		if filter(metadata) {
+			untracked = append(untracked, metadata)
 		}
 	}
 
-	// Re-sort after adding gap fills
-	sort.Slice(allMetadata, func(i, j int) bool {
-		return allMetadata[i].Timestamp.After(allMetadata[j].Timestamp)
-	})
+	allMetadata, total, err := pageScreenshots(config.metadataRepo(), query, filter, untracked, offset, limit)
+	if err != nil {
+		log.Printf("⚠️  Error reading metadata: %v", err)
+		http.Error(w, "Failed to list screenshots", http.StatusInternalServerError)
+		return
+	}
 
-	// Apply pagination
-	total := len(allMetadata)
-	if offset >= total {
-		allMetadata = []ScreenshotMetadata{}
-	} else {
-		end := offset + limit
-		if end > total {
-			end = total
-		}
-		allMetadata = allMetadata[offset:end]
+	// format=markdown renders image snippets, e.g. for a repo's PR description
+	if r.URL.Query().Get("format") == "markdown" {
+		writeMarkdown(w, allMetadata)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -295,6 +565,77 @@ func handleAPIScreenshots(w http.ResponseWriter, r *http.Request, config Config)
 	})
 }
 
+// handleRescan rebuilds the in-memory metadata cache from disk on demand
+func handleRescan(w http.ResponseWriter, r *http.Request, config Config) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAPIRequest(w, r) {
+		return
+	}
+
+	cache, ok := config.MetadataRepo.(*metadataCache)
+	if !ok {
+		http.Error(w, "Metadata cache not enabled", http.StatusNotFound)
+		return
+	}
+	if err := cache.Rescan(); err != nil {
+		log.Printf("Error rescanning metadata cache: %v", err)
+		http.Error(w, "Failed to rescan", http.StatusInternalServerError)
+		return
+	}
+
+	total, _ := cache.List()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"metadata_count": len(total),
+		"hosted_count":   countActualFiles(config),
+	})
+}
+
+// authorizeUpload checks the X-Upload-Key header against SSBNK_UPLOAD_KEY
+// and writes an error response if it fails
+func authorizeUpload(w http.ResponseWriter, r *http.Request) bool {
+	expectedKey := os.Getenv("SSBNK_UPLOAD_KEY")
+	if expectedKey == "" {
+		log.Printf("UPLOAD: SSBNK_UPLOAD_KEY not set, rejecting upload")
+		http.Error(w, "Upload not configured", http.StatusServiceUnavailable)
+		return false
+	}
+
+	apiKey := r.Header.Get("X-Upload-Key")
+	if apiKey != expectedKey {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// authorizeAPIRequest checks the X-API-Key header against SSBNK_API_KEY
+// (falling back to SSBNK_UPLOAD_KEY) and writes an error response if it fails
+func authorizeAPIRequest(w http.ResponseWriter, r *http.Request) bool {
+	if apiKey() == "" {
+		http.Error(w, "API key not configured", http.StatusServiceUnavailable)
+		return false
+	}
+	if !apiKeyValid(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func apiKey() string {
+	return getEnv("SSBNK_API_KEY", os.Getenv("SSBNK_UPLOAD_KEY"))
+}
+
+// apiKeyValid reports whether the request carries the configured API key
+func apiKeyValid(r *http.Request) bool {
+	expectedKey := apiKey()
+	return expectedKey != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-API-Key")), []byte(expectedKey)) == 1
+}
+
 func logMemoryUsage() {
 	for {
 		var m runtime.MemStats
@@ -308,44 +649,6 @@ func logMemoryUsage() {
 func handleLatest(w http.ResponseWriter, r *http.Request, config Config) {
 	log.Printf("Handling /latest request: %s", r.URL.Path)
 
-	// Read all metadata files
-	metadataDir := filepath.Join(config.DataDir, "metadata")
-	log.Printf("Reading metadata directory: %s", metadataDir)
-	files, err := os.ReadDir(metadataDir)
-	if err != nil {
-		log.Printf("Error reading metadata directory: %v", err)
-		http.Error(w, "Failed to read metadata directory", http.StatusInternalServerError)
-		return
-	}
-
-	var allMetadata []ScreenshotMetadata
-	log.Printf("Found %d files in metadata directory", len(files))
-	for _, file := range files {
-		if strings.HasSuffix(file.Name(), ".json") {
-			log.Printf("Processing metadata file: %s", file.Name())
-			filePath := filepath.Join(metadataDir, file.Name())
-			data, err := os.ReadFile(filePath)
-			if err != nil {
-				log.Printf("Warning: Failed to read metadata file %s: %v", file.Name(), err)
-				continue
-			}
-
-			var metadata ScreenshotMetadata
-			if err := json.Unmarshal(data, &metadata); err != nil {
-				log.Printf("Warning: Failed to unmarshal metadata file %s: %v", file.Name(), err)
-				continue
-			}
-			log.Printf("Successfully parsed metadata for: %s (timestamp: %s)", metadata.Filename, metadata.Timestamp)
-			allMetadata = append(allMetadata, metadata)
-		}
-	}
-	log.Printf("Total metadata entries loaded: %d", len(allMetadata))
-
-	// Sort by timestamp descending
-	sort.Slice(allMetadata, func(i, j int) bool {
-		return allMetadata[i].Timestamp.After(allMetadata[j].Timestamp)
-	})
-
 	// Get offset from URL path
 	offset := 0
 	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
@@ -359,15 +662,20 @@ func handleLatest(w http.ResponseWriter, r *http.Request, config Config) {
 		log.Printf("No offset specified, using default: %d", offset)
 	}
 
-	log.Printf("Checking offset %d against %d total metadata entries", offset, len(allMetadata))
-	if offset >= len(allMetadata) {
-		log.Printf("Offset %d is out of range (have %d entries)", offset, len(allMetadata))
+	// Private screenshots are skipped so they can't be reached by offset
+	targetMetadata, found, err := publicMetadataAt(config, offset)
+	if err != nil {
+		log.Printf("Error reading metadata: %v", err)
+		http.Error(w, "Failed to read metadata", http.StatusInternalServerError)
+		return
+	}
+
+	if !found {
+		log.Printf("Offset %d is out of range", offset)
 		http.Error(w, "Not found: offset is out of range", http.StatusNotFound)
 		return
 	}
 
-	// Get the target metadata
-	targetMetadata := allMetadata[offset]
 	log.Printf("Redirecting to: %s", targetMetadata.URL)
 
 	// Redirect to the image URL
@@ -388,13 +696,12 @@ func handleLatestHybrid(w http.ResponseWriter, r *http.Request, config Config) {
 		log.Printf("✅ HYBRID Success: Found via metadata - %s", metadata.URL)
 
 		// Validate that the file actually exists (consistency check)
-		hostedPath := filepath.Join(config.DataDir, "hosted", metadata.Filename)
-		if fileExists(hostedPath) {
-			log.Printf("✅ HYBRID Validation: File exists on disk")
+		if hostedExists(config, metadata.Filename) {
+			log.Printf("✅ HYBRID Validation: File exists in storage")
 			http.Redirect(w, r, metadata.URL, http.StatusFound)
 			return
 		} else {
-			log.Printf("⚠️  HYBRID Warning: Metadata found but file missing on disk: %s", hostedPath)
+			log.Printf("⚠️  HYBRID Warning: Metadata found but file missing from storage: %s", metadata.Filename)
 			// Fall through to filesystem scan
 		}
 	}
@@ -445,6 +752,8 @@ func handleHealthCheck(w http.ResponseWriter, r *http.Request, config Config) {
 		MetadataCount     int      `json:"metadata_count"`
 		ActualFileCount   int      `json:"actual_file_count"`
 		ConsistencyIssues []string `json:"consistency_issues,omitempty"`
+		StorageUsed       int64    `json:"storage_used"`
+		StorageQuota      int64    `json:"storage_quota,omitempty"`
 		Timestamp         string   `json:"timestamp"`
 	}
 
@@ -458,6 +767,8 @@ func handleHealthCheck(w http.ResponseWriter, r *http.Request, config Config) {
 	health.ConsistencyIssues = issues
 	health.MetadataCount = len(loadAllMetadata(config))
 	health.ActualFileCount = countActualFiles(config)
+	health.StorageUsed, _ = hostedUsage(config)
+	health.StorageQuota = config.StorageQuota
 
 	if len(issues) > 0 {
 		health.Status = "warning"
@@ -476,125 +787,349 @@ func handleUpload(w http.ResponseWriter, r *http.Request, config Config) {
 		return
 	}
 
-	// Validate API key
-	expectedKey := os.Getenv("SSBNK_UPLOAD_KEY")
-	if expectedKey == "" {
-		log.Printf("UPLOAD: SSBNK_UPLOAD_KEY not set, rejecting upload")
-		http.Error(w, "Upload not configured", http.StatusServiceUnavailable)
+	if !authorizeUpload(w, r) {
 		return
 	}
 
-	apiKey := r.Header.Get("X-Upload-Key")
-	if apiKey != expectedKey {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// Stream the form to disk (images up to 50MB, recordings up to
+	// SSBNK_MAX_VIDEO_UPLOAD, the request up to SSBNK_MAX_UPLOAD_SIZE)
+	form, err := readUpload(w, r, config)
+	if errors.Is(err, errUploadTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
 		return
 	}
-
-	// Parse multipart form (max 50MB)
-	if err := r.ParseMultipartForm(50 << 20); err != nil {
+	if err != nil {
+		log.Printf("UPLOAD: %v", err)
 		http.Error(w, "Failed to parse upload", http.StatusBadRequest)
 		return
 	}
+	defer form.RemoveAll()
 
-	file, header, err := r.FormFile("file")
-	if err != nil {
+	headers := form.Files
+	if len(headers) == 0 {
 		http.Error(w, "No file provided", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
 
-	// Determine extension from original filename
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	if ext == "" {
-		ext = ".png"
+	repoName := strings.TrimSpace(form.Values.Get("repo"))
+	if repoName != "" && !validRepoName(repoName) {
+		http.Error(w, "Invalid repo name", http.StatusBadRequest)
+		return
 	}
 
-	// Only allow image files
-	if ext != ".png" && ext != ".jpg" && ext != ".jpeg" && ext != ".gif" && ext != ".webp" {
-		http.Error(w, "Only image files allowed", http.StatusBadRequest)
-		return
+	private := false
+	if val := form.Values.Get("private"); val != "" {
+		parsed, err := strconv.ParseBool(val)
+		if err != nil {
+			http.Error(w, "Invalid private flag", http.StatusBadRequest)
+			return
+		}
+		private = parsed
+	}
+
+	var ttl time.Duration
+	if val := form.Values.Get("expires_in"); val != "" {
+		parsed, err := parseExpiresIn(val)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ttl = parsed
 	}
 
-	// Generate filename with timestamp
+	// Several files in one request form a batch
 	now := time.Now()
-	newFilename := fmt.Sprintf("%s%s", now.Format("20060102-1504"), ext)
-	destPath := filepath.Join(config.DataDir, "hosted", newFilename)
+	defaults := ScreenshotMetadata{
+		Timestamp: now,
+		RepoName:  repoName,
+		Private:   private,
+		ExpiresAt: expiryAfter(now, ttl),
+	}
+	if len(headers) > 1 {
+		defaults.BatchID = newBatchID()
+	}
+	batchID := defaults.BatchID
 
-	// Ensure unique filename
-	counter := 1
-	for fileExists(destPath) {
-		newFilename = fmt.Sprintf("%s-%d%s", now.Format("20060102-1504"), counter, ext)
-		destPath = filepath.Join(config.DataDir, "hosted", newFilename)
-		counter++
+	// Recordings are converted in the background; the client polls the job
+	for _, header := range headers {
+		if isVideoFile(header.Filename) {
+			handleVideoUpload(w, config, form, defaults)
+			return
+		}
+	}
+
+	// Only allow image files (checked up front so a batch is all or nothing)
+	exts := make([]string, len(headers))
+	for i, header := range headers {
+		ext, err := uploadType(config, header)
+		if errors.Is(err, errInvalidImage) {
+			log.Printf("UPLOAD: Rejected %s: %v", header.Filename, err)
+			http.Error(w, fmt.Sprintf("%s: %v", header.Filename, err), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("UPLOAD: %v", err)
+			http.Error(w, "Failed to read upload", http.StatusInternalServerError)
+			return
+		}
+		exts[i] = ext
 	}
 
-	// Write the uploaded file
-	destFile, err := os.Create(destPath)
+	// Make room for the whole batch before storing any of it. Content that
+	// is already hosted takes no space, and isn't evicted to make room.
+	hashes := make([]string, len(headers))
+	var incoming int64
+	var reused []string
+	seen := make(map[string]bool)
+	for i, header := range headers {
+		hash, err := hashUpload(header)
+		if err != nil {
+			log.Printf("UPLOAD: %v", err)
+			http.Error(w, "Failed to read upload", http.StatusInternalServerError)
+			return
+		}
+		hashes[i] = hash
+		if existing, found := findDuplicate(config, hash, defaults); found {
+			reused = append(reused, existing.Filename)
+		} else if !seen[hash] {
+			incoming += header.Size
+		}
+		seen[hash] = true
+	}
+	evicted, release, err := makeRoom(config, incoming, reused...)
+	if errors.Is(err, errQuotaExceeded) {
+		log.Printf("UPLOAD: %v", err)
+		http.Error(w, "Storage quota exceeded", http.StatusInsufficientStorage)
+		return
+	}
 	if err != nil {
-		log.Printf("UPLOAD: Failed to create file: %v", err)
-		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		log.Printf("UPLOAD: %v", err)
+		http.Error(w, "Failed to free storage", http.StatusInternalServerError)
 		return
 	}
-	defer destFile.Close()
+	defer release()
 
-	written, err := io.Copy(destFile, file)
-	if err != nil {
-		log.Printf("UPLOAD: Failed to write file: %v", err)
-		http.Error(w, "Failed to write file", http.StatusInternalServerError)
+	var stored []ScreenshotMetadata
+	var duplicates []bool
+	for i, header := range headers {
+		metadata, duplicate, err := storeUpload(config, header, exts[i], hashes[i], defaults)
+		if errors.Is(err, errInvalidImage) {
+			log.Printf("UPLOAD: %v", err)
+			http.Error(w, "Invalid image", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, errRedactionFailed) {
+			log.Printf("UPLOAD: %v", err)
+			http.Error(w, "Could not check the image for secrets", http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			log.Printf("UPLOAD: %v", err)
+			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+			return
+		}
+		stored = append(stored, metadata)
+		duplicates = append(duplicates, duplicate)
+	}
+
+	// Copy URL to clipboard so pasting on the host works immediately
+	last := stored[len(stored)-1]
+	url := shareURL(config, last)
+	if err := copyToClipboard(url); err != nil {
+		log.Printf("UPLOAD: Warning: Failed to copy to clipboard: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if batchID == "" {
+		response := map[string]interface{}{
+			"url":       url,
+			"filename":  last.Filename,
+			"duplicate": duplicates[0],
+		}
+		if last.ExpiresAt != nil {
+			response["expires_at"] = last.ExpiresAt
+		}
+		if len(evicted) > 0 {
+			response["evicted"] = evicted
+		}
+		json.NewEncoder(w).Encode(response)
 		return
 	}
 
+	files := make([]map[string]interface{}, 0, len(stored))
+	for i, metadata := range stored {
+		files = append(files, map[string]interface{}{
+			"url":       accessURL(config, metadata),
+			"filename":  metadata.Filename,
+			"duplicate": duplicates[i],
+		})
+	}
+	response := map[string]interface{}{
+		"url":      url,
+		"batch_id": batchID,
+		"files":    files,
+	}
+	if defaults.ExpiresAt != nil {
+		response["expires_at"] = defaults.ExpiresAt
+	}
+	if len(evicted) > 0 {
+		response["evicted"] = evicted
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// uploadExtension determines the extension from the original filename
+func uploadExtension(filename string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == "" {
+		ext = ".png"
+	}
+	return ext
+}
+
+// uploadType returns the extension an uploaded file is hosted under: the
+// one matching its content when validation is on, otherwise the one from
+// its filename. Anything that isn't an image is an errInvalidImage.
+func uploadType(config Config, header uploadedFile) (string, error) {
+	if !config.ValidateImages {
+		ext := uploadExtension(header.Filename)
+		if !isImageFile(ext) {
+			return "", fmt.Errorf("%w: only image files allowed", errInvalidImage)
+		}
+		return ext, nil
+	}
+	file, err := header.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer file.Close()
+	return validateImage(config, file)
+}
+
+// hashUpload returns the hex SHA-256 of an uploaded file
+func hashUpload(header uploadedFile) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer file.Close()
+	hash, err := hashReader(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash upload: %w", err)
+	}
+	return hash, nil
+}
+
+// storeUpload writes one uploaded file with the given hash to the hosted
+// storage under ext and records its metadata, taking timestamp, batch and
+// repository from defaults. If the same content is already hosted, the
+// existing metadata is returned instead and duplicate is true.
+func storeUpload(config Config, header uploadedFile, ext, hash string, defaults ScreenshotMetadata) (metadata ScreenshotMetadata, duplicate bool, err error) {
+	if existing, found := findDuplicate(config, hash, defaults); found {
+		log.Printf("UPLOAD: %s is a duplicate of %s", header.Filename, existing.Filename)
+		return existing, true, nil
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return ScreenshotMetadata{}, false, fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer file.Close()
+
+	now := defaults.Timestamp
+	vars := filenameVars{Time: now, Original: header.Filename, Repo: defaults.RepoName, Hash: hash}
+
+	// Strip location and device details before the file is published
+	var content io.ReadSeeker = file
+	var sanitized []string
+	if config.StripUploadMetadata {
+		if content, sanitized, err = sanitizeUpload(file); err != nil {
+			return ScreenshotMetadata{}, false, fmt.Errorf("failed to sanitize %s: %w", header.Filename, err)
+		}
+	}
+
+	// Store under a name generated from the template
+	content, redaction, original, err := redactContent(config, content)
+	if err != nil {
+		return ScreenshotMetadata{}, false, fmt.Errorf("%s not stored: %w", header.Filename, err)
+	}
+	content, ext, optimized := optimizeContent(config.OptimizeUpload, content, ext)
+	newFilename, written, err := storeHostedFile(config, vars, ext, content)
+	if err != nil {
+		return ScreenshotMetadata{}, false, err
+	}
+
 	// Generate URL
 	url := fmt.Sprintf("%s/%s", config.BaseURL, newFilename)
 
 	// Create metadata
-	metadata := ScreenshotMetadata{
+	metadata = ScreenshotMetadata{
 		ID:           uuid.New().String(),
 		OriginalName: header.Filename,
 		Filename:     newFilename,
 		URL:          url,
 		Timestamp:    now,
+		BatchID:      defaults.BatchID,
+		RepoName:     defaults.RepoName,
 		Size:         written,
+		SHA256:       vars.Hash,
+		Private:      defaults.Private,
+		ExpiresAt:    defaults.ExpiresAt,
 		Preserve:     false,
 	}
+	if len(sanitized) > 0 {
+		metadata.Sanitized = sanitized
+		log.Printf("UPLOAD: Stripped %s metadata from %s", strings.Join(sanitized, ", "), header.Filename)
+	}
+	if optimized {
+		recordOptimization(&metadata, header.Size)
+	}
+	recordRedaction(config, &metadata, redaction, original)
 
-	metadataPath := filepath.Join(config.DataDir, "metadata", fmt.Sprintf("%s.json", metadata.ID))
-	if err := saveMetadata(metadata, metadataPath); err != nil {
+	if err := config.metadataRepo().Save(metadata); err != nil {
 		log.Printf("UPLOAD: Failed to save metadata: %v", err)
 	}
 
-	// Track as last screenshot for paste-image support
-	writeLastScreenshotPath(destPath)
+	// Extract text and build thumbnails in the background
+	scheduleOCR(config, metadata)
+	scheduleThumbnails(config, metadata)
 
-	// Copy URL to clipboard so pasting on the host works immediately
-	if err := copyToClipboard(url); err != nil {
-		log.Printf("UPLOAD: Warning: Failed to copy to clipboard: %v", err)
-	}
+	// Track as last screenshot for paste-image support
+	writeLastScreenshotPath(newFilename)
 
 	log.Printf("UPLOAD: %s -> %s (%s)", header.Filename, url, formatBytes(written))
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"url":      url,
-		"filename": newFilename,
-	})
+	return metadata, false, nil
 }
 
-// writeLastScreenshotPath saves the path to the most recently processed image
+// writeLastScreenshotPath saves the name of the most recently processed image
 // so the paste-image script can copy it to clipboard as image data
-func writeLastScreenshotPath(hostedPath string) {
+func writeLastScreenshotPath(filename string) {
 	stateDir := "/tmp/ssbnk"
 	os.MkdirAll(stateDir, 0755)
 	// Write just the filename so the host-side paste-image script can resolve it
-	filename := filepath.Base(hostedPath)
 	if err := os.WriteFile(filepath.Join(stateDir, "last-screenshot"), []byte(filename), 0644); err != nil {
 		log.Printf("Warning: Failed to write last screenshot path: %v", err)
 	}
 }
 
 func processScreenshot(sourcePath string, config Config) error {
+	// A ".expires-<ttl>" suffix in the filename sets an expiry
+	originalName, ttl := splitExpirySuffix(filepath.Base(sourcePath))
+
+	// Go by the content rather than the name when validating; rejected
+	// files are left where they are
+	ext := ".png"
+	isGIF := strings.HasSuffix(strings.ToLower(sourcePath), ".gif")
+	if config.ValidateImages {
+		detected, err := validateImageFile(config, sourcePath)
+		if err != nil {
+			return fmt.Errorf("rejected %s: %w", sourcePath, err)
+		}
+		ext, isGIF = detected, detected == ".gif"
+	}
+
 	// Special handling for GIF files that might be from video conversion
-	if strings.HasSuffix(strings.ToLower(sourcePath), ".gif") {
+	if isGIF {
 		// Check if this GIF was created recently (likely from video conversion)
 		fileInfo, err := os.Stat(sourcePath)
 		if err != nil {
@@ -603,54 +1138,67 @@ func processScreenshot(sourcePath string, config Config) error {
 
 		// If created within last 5 seconds, it's likely from video conversion
 		if time.Since(fileInfo.ModTime()) < 5*time.Second {
-			// Move directly to hosted directory without renaming
-			destPath := filepath.Join(config.DataDir, "hosted", filepath.Base(sourcePath))
-
-			// Ensure unique filename (unlikely needed for GIFs but just in case)
-			counter := 1
-			originalDestPath := destPath
-			for fileExists(destPath) {
-				destPath = fmt.Sprintf("%s-%d.gif", strings.TrimSuffix(originalDestPath, ".gif"), counter)
-				counter++
+			hash, err := fileSHA256(sourcePath)
+			if err != nil {
+				return fmt.Errorf("failed to hash GIF: %w", err)
+			}
+			if existing, found := findDuplicate(config, hash, ScreenshotMetadata{ExpiresAt: expiryAfter(time.Now(), ttl)}); found {
+				reuseDuplicate(config, sourcePath, existing)
+				return nil
+			}
+			_, release, err := makeRoom(config, fileInfo.Size())
+			if err != nil {
+				return err
 			}
+			defer release()
 
-			// Move the file
-			if err := os.Rename(sourcePath, destPath); err != nil {
-				// If rename fails (cross-device), fall back to copy
-				if err := copyFile(sourcePath, destPath); err != nil {
-					return fmt.Errorf("failed to move GIF: %w", err)
-				}
-				// Remove original after successful copy
-				if err := os.Remove(sourcePath); err != nil {
-					log.Printf("Warning: Failed to remove original GIF: %v", err)
-				}
+			// Store under a name generated from the template, like any screenshot
+			gifFile, err := os.Open(sourcePath)
+			if err != nil {
+				return fmt.Errorf("failed to open GIF: %w", err)
+			}
+			now := time.Now()
+			repoName := resolveRepo(config, sourcePath)
+			vars := filenameVars{Time: now, Original: originalName, Repo: repoName, Hash: hash}
+			gifFilename, _, err := storeHostedFile(config, vars, ".gif", gifFile)
+			gifFile.Close()
+			if err != nil {
+				return fmt.Errorf("failed to store GIF: %w", err)
+			}
+			if err := os.Remove(sourcePath); err != nil {
+				log.Printf("Warning: Failed to remove original GIF: %v", err)
 			}
 
-			// Generate URL with original filename
-			url := fmt.Sprintf("%s/%s", config.BaseURL, filepath.Base(destPath))
+			// Generate URL
+			url := fmt.Sprintf("%s/%s", config.BaseURL, gifFilename)
 
 			// Create metadata
 			metadata := ScreenshotMetadata{
 				ID:           uuid.New().String(),
-				OriginalName: filepath.Base(sourcePath),
-				Filename:     filepath.Base(destPath),
+				OriginalName: originalName,
+				Filename:     gifFilename,
 				URL:          url,
-				Timestamp:    time.Now(),
+				Timestamp:    now,
+				RepoName:     repoName,
 				Size:         fileInfo.Size(),
+				SHA256:       hash,
+				ExpiresAt:    expiryAfter(now, ttl),
 				Preserve:     false,
 			}
 
+			// Group with screenshots taken just before this one
+			assignBatch(config, &metadata)
+
 			// Save metadata
-			metadataPath := filepath.Join(config.DataDir, "metadata", fmt.Sprintf("%s.json", metadata.ID))
-			if err := saveMetadata(metadata, metadataPath); err != nil {
+			if err := config.metadataRepo().Save(metadata); err != nil {
 				log.Printf("Warning: Failed to save metadata: %v", err)
 			}
 
 			// Track for paste-image support
-			writeLastScreenshotPath(destPath)
+			writeLastScreenshotPath(gifFilename)
 
-			// Copy URL to clipboard
-			if err := copyToClipboard(url); err != nil {
+			// Copy URL (or the batch album URL) to clipboard
+			if err := copyToClipboard(shareURL(config, metadata)); err != nil {
 				log.Printf("Warning: Failed to copy to clipboard: %v", err)
 			}
 
@@ -669,18 +1217,8 @@ func processScreenshot(sourcePath string, config Config) error {
 	}
 
 	// Regular screenshot processing for non-GIF or older GIF files
-	// Generate new filename with timestamp
 	now := time.Now()
-	newFilename := fmt.Sprintf("%s.png", now.Format("20060102-1504"))
-
-	// Ensure unique filename
-	destPath := filepath.Join(config.DataDir, "hosted", newFilename)
-	counter := 1
-	for fileExists(destPath) {
-		newFilename = fmt.Sprintf("%s-%d.png", now.Format("20060102-1504"), counter)
-		destPath = filepath.Join(config.DataDir, "hosted", newFilename)
-		counter++
-	}
+	repoName := resolveRepo(config, sourcePath)
 
 	// Get file info
 	fileInfo, err := os.Stat(sourcePath)
@@ -688,21 +1226,39 @@ func processScreenshot(sourcePath string, config Config) error {
 		return fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	// Copy file to hosted directory (can't use rename across volumes)
+	vars := filenameVars{Time: now, Original: originalName, Repo: repoName}
+	if vars.Hash, err = fileSHA256(sourcePath); err != nil {
+		return fmt.Errorf("failed to hash source file: %w", err)
+	}
+
+	// Identical content is already hosted: reuse it instead of storing a copy
+	if existing, found := findDuplicate(config, vars.Hash, ScreenshotMetadata{ExpiresAt: expiryAfter(now, ttl)}); found {
+		reuseDuplicate(config, sourcePath, existing)
+		return nil
+	}
+	_, release, err := makeRoom(config, fileInfo.Size())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// Copy the file into the hosted storage (can't rename across volumes)
 	sourceFile, err := os.Open(sourcePath)
 	if err != nil {
 		return fmt.Errorf("failed to open source file: %w", err)
 	}
 	defer sourceFile.Close()
 
-	destFile, err := os.Create(destPath)
+	// Store under a new filename generated from the template; with
+	// redaction failing the file stays where it is
+	content, redaction, original, err := redactContent(config, sourceFile)
 	if err != nil {
-		return fmt.Errorf("failed to create destination file: %w", err)
+		return err
 	}
-	defer destFile.Close()
-
-	if _, err := destFile.ReadFrom(sourceFile); err != nil {
-		return fmt.Errorf("failed to copy file: %w", err)
+	content, ext, optimized := optimizeContent(config.OptimizeWatch, content, ext)
+	newFilename, written, err := storeHostedFile(config, vars, ext, content)
+	if err != nil {
+		return err
 	}
 
 	// Remove the original file
@@ -716,25 +1272,38 @@ func processScreenshot(sourcePath string, config Config) error {
 	// Create metadata
 	metadata := ScreenshotMetadata{
 		ID:           uuid.New().String(),
-		OriginalName: filepath.Base(sourcePath),
+		OriginalName: originalName,
 		Filename:     newFilename,
 		URL:          url,
 		Timestamp:    now,
-		Size:         fileInfo.Size(),
+		RepoName:     repoName,
+		Size:         written,
+		SHA256:       vars.Hash,
+		ExpiresAt:    expiryAfter(now, ttl),
 		Preserve:     false,
 	}
+	if optimized {
+		recordOptimization(&metadata, fileInfo.Size())
+	}
+	recordRedaction(config, &metadata, redaction, original)
+
+	// Group with screenshots taken just before this one
+	assignBatch(config, &metadata)
 
 	// Save metadata
-	metadataPath := filepath.Join(config.DataDir, "metadata", fmt.Sprintf("%s.json", metadata.ID))
-	if err := saveMetadata(metadata, metadataPath); err != nil {
+	if err := config.metadataRepo().Save(metadata); err != nil {
 		log.Printf("Warning: Failed to save metadata: %v", err)
 	}
 
+	// Extract text and build thumbnails in the background
+	scheduleOCR(config, metadata)
+	scheduleThumbnails(config, metadata)
+
 	// Track for paste-image support
-	writeLastScreenshotPath(destPath)
+	writeLastScreenshotPath(newFilename)
 
-	// Copy URL to clipboard
-	if err := copyToClipboard(url); err != nil {
+	// Copy URL (or the batch album URL) to clipboard
+	if err := copyToClipboard(shareURL(config, metadata)); err != nil {
 		log.Printf("Warning: Failed to copy to clipboard: %v", err)
 	}
 
@@ -743,14 +1312,99 @@ func processScreenshot(sourcePath string, config Config) error {
 }
 
 func processVideo(sourcePath string, config Config) error {
-	// Generate GIF filename with timestamp
 	now := time.Now()
-	gifFilename := fmt.Sprintf("%s.gif", now.Format("20060102-1504"))
-	tempGifPath := filepath.Join("/tmp", gifFilename)
-	hostedGifPath := filepath.Join(config.DataDir, "hosted", gifFilename)
+	// ".expires-<ttl>" and ".preset-<name>" suffixes set an expiry and the
+	// conversion preset
+	name, presetName := splitPresetSuffix(filepath.Base(sourcePath))
+	originalName, ttl := splitExpirySuffix(name)
+
+	source := videoSource{
+		Path:         sourcePath,
+		OriginalName: originalName,
+		Preset:       recordingPreset(config, presetName),
+		Defaults: ScreenshotMetadata{
+			Timestamp: now,
+			RepoName:  resolveRepo(config, sourcePath),
+			ExpiresAt: expiryAfter(now, ttl),
+		},
+	}
+	metadata, duplicate, err := convertVideo(config, source)
+	if err != nil {
+		return err
+	}
+
+	// The same recording was converted before: reuse the hosted GIF
+	if duplicate {
+		reuseDuplicate(config, sourcePath, metadata)
+		return nil
+	}
+
+	// Group with screenshots taken just before this one
+	assignBatch(config, &metadata)
+
+	// Save metadata
+	if err := config.metadataRepo().Save(metadata); err != nil {
+		log.Printf("Warning: Failed to save metadata: %v", err)
+	}
+
+	// Track for paste-image support
+	writeLastScreenshotPath(metadata.Filename)
+
+	// Copy URL (or the batch album URL) to clipboard
+	if err := copyToClipboard(shareURL(config, metadata)); err != nil {
+		log.Printf("Warning: Failed to copy to clipboard: %v", err)
+	}
+
+	// Play notification sound
+	playNotificationSound()
+
+	// Open GIF in browser
+	log.Printf("Opening GIF in browser: %s", metadata.URL)
+	if err := openInBrowser(metadata.URL); err != nil {
+		log.Printf("Warning: Failed to open in browser: %v", err)
+	}
+
+	// Remove the original video file
+	if err := os.Remove(sourcePath); err != nil {
+		log.Printf("Warning: Failed to remove original video: %v", err)
+	}
+
+	log.Printf("Video converted to GIF: %s -> %s", filepath.Base(sourcePath), metadata.URL)
+	return nil
+}
+
+// videoSource is a recording to convert, with the metadata to give the GIF
+type videoSource struct {
+	Path         string             `json:"path,omitempty"`
+	OriginalName string             `json:"original_name"`
+	Preset       GIFPreset          `json:"preset"`
+	Defaults     ScreenshotMetadata `json:"defaults"` // timestamp, repository, privacy and expiry
+}
+
+// convertVideo converts a recording to a GIF in the hosted storage (plus a
+// video when configured) and returns its metadata, which the caller saves.
+// If the same GIF is already hosted, its metadata is returned instead and
+// duplicate is true. The recording itself is left in place.
+func convertVideo(config Config, source videoSource) (metadata ScreenshotMetadata, duplicate bool, err error) {
+	sourcePath, preset, now := source.Path, source.Preset, source.Defaults.Timestamp
+
+	// Only known containers reach ffmpeg, read as that format from a file
+	format, err := probeVideoFormat(sourcePath)
+	if err != nil {
+		return ScreenshotMetadata{}, false, err
+	}
+
+	// Convert into a private temp file so concurrent conversions can't collide
+	tempGif, err := os.CreateTemp("", "ssbnk-*.gif")
+	if err != nil {
+		return ScreenshotMetadata{}, false, fmt.Errorf("failed to create temp GIF: %w", err)
+	}
+	tempGif.Close()
+	tempGifPath := tempGif.Name()
+	defer os.Remove(tempGifPath)
 
 	// Convert video to GIF using ffmpeg
-	log.Printf("Converting video to GIF: %s", filepath.Base(sourcePath))
+	log.Printf("Converting video to GIF: %s (preset %s)", filepath.Base(sourcePath), preset.Name)
 
 	// Try conversion with retries
 	var lastErr error
@@ -760,128 +1414,73 @@ func processVideo(sourcePath string, config Config) error {
 			time.Sleep(2 * time.Second)
 		}
 
-		// FFmpeg command to create a high-quality looping GIF
-		// -y: overwrite output files
-		// -t 10: limit to first 10 seconds
-		// -vf: video filters for scaling and generating palette for better colors
-		// -loop 0: infinite loop
-		cmd := exec.Command("ffmpeg",
-			"-y", // Overwrite output files
-			"-i", sourcePath,
-			"-t", "10", // Limit to 10 seconds
-			"-vf", "fps=10,scale=640:-1:flags=lanczos,split[s0][s1];[s0]palettegen[p];[s1][p]paletteuse",
-			"-loop", "0",
-			tempGifPath,
-		)
-
-		output, err := cmd.CombinedOutput()
-		if err == nil {
-			// Success! Check if output file exists
-			if _, err := os.Stat(tempGifPath); err == nil {
-				log.Printf("Video conversion successful on attempt %d", attempt)
-				break
-			}
-			lastErr = fmt.Errorf("output file not created")
-		} else {
-			lastErr = fmt.Errorf("ffmpeg error: %w\nOutput: %s", err, string(output))
-
-			// If it's a file format issue, try with format detection
-			if attempt == 2 {
-				log.Printf("Trying with explicit format detection...")
-				cmd = exec.Command("ffmpeg",
-					"-y",
-					"-f", "matroska", // Try explicit format
-					"-i", sourcePath,
-					"-t", "10",
-					"-vf", "fps=10,scale=640:-1:flags=lanczos,split[s0][s1];[s0]palettegen[p];[s1][p]paletteuse",
-					"-loop", "0",
-					tempGifPath,
-				)
-
-				output, err = cmd.CombinedOutput()
-				if err == nil {
-					if _, err := os.Stat(tempGifPath); err == nil {
-						log.Printf("Video conversion successful with format detection")
-						break
-					}
-				}
-			}
+		lastErr = runFFmpeg(ffmpegGIFArgs(preset, sourcePath, tempGifPath, format), tempGifPath)
+
+		if lastErr == nil {
+			log.Printf("Video conversion successful on attempt %d", attempt)
+			break
 		}
 	}
 
 	if lastErr != nil {
-		return fmt.Errorf("video conversion failed after 3 attempts: %w", lastErr)
+		return ScreenshotMetadata{}, false, fmt.Errorf("video conversion failed after 3 attempts: %w", lastErr)
 	}
 
-	// Move GIF directly to hosted directory (skip watch directory)
-	// First try rename (atomic operation)
-	err := os.Rename(tempGifPath, hostedGifPath)
-	if err != nil {
-		// If rename fails (cross-device), fall back to copy
-		if err := copyFile(tempGifPath, hostedGifPath); err != nil {
-			return fmt.Errorf("failed to move GIF to hosted directory: %w", err)
-		}
-		// Remove temp file after successful copy
-		if err := os.Remove(tempGifPath); err != nil {
-			log.Printf("Warning: Failed to remove temp GIF: %v", err)
-		}
+	// Generate GIF filename from the template, reserving it atomically
+	vars := filenameVars{Time: now, Original: source.OriginalName, Repo: source.Defaults.RepoName}
+	if vars.Hash, err = fileSHA256(tempGifPath); err != nil {
+		return ScreenshotMetadata{}, false, fmt.Errorf("failed to hash GIF: %w", err)
 	}
 
-	// Get file info for metadata
-	fileInfo, err := os.Stat(hostedGifPath)
+	if existing, found := findDuplicate(config, vars.Hash, source.Defaults); found {
+		return existing, true, nil
+	}
+	gifInfo, err := os.Stat(tempGifPath)
+	if err != nil {
+		return ScreenshotMetadata{}, false, fmt.Errorf("failed to get GIF file info: %w", err)
+	}
+	_, release, err := makeRoom(config, gifInfo.Size())
 	if err != nil {
-		return fmt.Errorf("failed to get GIF file info: %w", err)
+		return ScreenshotMetadata{}, false, err
 	}
+	defer release()
 
-	// Generate URL
-	url := fmt.Sprintf("%s/%s", config.BaseURL, gifFilename)
+	// Store the GIF directly (skip watch directory)
+	gifFile, err := os.Open(tempGifPath)
+	if err != nil {
+		return ScreenshotMetadata{}, false, fmt.Errorf("failed to open GIF: %w", err)
+	}
+	gifFilename, gifSize, err := storeHostedFile(config, vars, ".gif", gifFile)
+	gifFile.Close()
+	if err != nil {
+		return ScreenshotMetadata{}, false, fmt.Errorf("failed to store GIF: %w", err)
+	}
 
 	// Create metadata
-	metadata := ScreenshotMetadata{
+	metadata = ScreenshotMetadata{
 		ID:           uuid.New().String(),
-		OriginalName: filepath.Base(sourcePath),
+		OriginalName: source.OriginalName,
 		Filename:     gifFilename,
-		URL:          url,
+		URL:          fmt.Sprintf("%s/%s", config.BaseURL, gifFilename),
 		Timestamp:    now,
-		Size:         fileInfo.Size(),
+		BatchID:      source.Defaults.BatchID,
+		RepoName:     source.Defaults.RepoName,
+		Size:         gifSize,
+		SHA256:       vars.Hash,
+		Private:      source.Defaults.Private,
+		ExpiresAt:    source.Defaults.ExpiresAt,
 		Preserve:     false,
+		GIFPreset:    &preset,
 	}
 
-	// Save metadata
-	metadataPath := filepath.Join(config.DataDir, "metadata", fmt.Sprintf("%s.json", metadata.ID))
-	if err := saveMetadata(metadata, metadataPath); err != nil {
-		log.Printf("Warning: Failed to save metadata: %v", err)
-	}
-
-	// Track for paste-image support
-	writeLastScreenshotPath(hostedGifPath)
-
-	// Copy URL to clipboard
-	if err := copyToClipboard(url); err != nil {
-		log.Printf("Warning: Failed to copy to clipboard: %v", err)
-	}
-
-	// Play notification sound
-	playNotificationSound()
-
-	// Open GIF in browser
-	log.Printf("Opening GIF in browser: %s", url)
-	if err := openInBrowser(url); err != nil {
-		log.Printf("Warning: Failed to open in browser: %v", err)
-	}
-
-	// Remove the original video file
-	if err := os.Remove(sourcePath); err != nil {
-		log.Printf("Warning: Failed to remove original video: %v", err)
-	}
-
-	log.Printf("Video converted to GIF: %s -> %s", filepath.Base(sourcePath), url)
-	return nil
+	// Keep a full-quality video next to the GIF
+	metadata.Video = transcodeVideo(config, sourcePath, format, gifFilename)
+	return metadata, false, nil
 }
 
 func isImageFile(filename string) bool {
 	ext := strings.ToLower(filepath.Ext(filename))
-	return ext == ".png" || ext == ".jpg" || ext == ".jpeg" || ext == ".gif" || ext == ".webp"
+	return ext == ".png" || ext == ".jpg" || ext == ".jpeg" || ext == ".gif" || ext == ".webp" || ext == ".avif"
 }
 
 func isVideoFile(filename string) bool {
@@ -889,6 +1488,12 @@ func isVideoFile(filename string) bool {
 	return ext == ".mp4" || ext == ".avi" || ext == ".mov" || ext == ".mkv" || ext == ".webm" || ext == ".flv" || ext == ".wmv"
 }
 
+// isHostedMedia reports whether a hosted file is served as a screenshot or
+// as a screencast's video
+func isHostedMedia(filename string) bool {
+	return isImageFile(filename) || isVideoFile(filename)
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return !os.IsNotExist(err)
@@ -1022,9 +1627,80 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvInt parses an integer from the environment
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: Invalid %s=%q, using %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// getEnvDuration parses a duration such as "30s" from the environment
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Warning: Invalid %s=%q, using %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+// trackedFiles are the files being watched for completion, so a create and
+// a rename event (or a reconcile scan) don't track the same file twice
+var trackedFiles = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// trackVideoFile waits for a recording to be finished and queues its
+// conversion
 func trackVideoFile(filePath string, config Config) {
-	log.Printf("Tracking video file for completion: %s", filepath.Base(filePath))
+	trackFile(config, jobVideo, filePath)
+}
+
+// trackFile waits until a file is completely written and queues a job of
+// the given kind for it. Files already being tracked are skipped.
+func trackFile(config Config, kind, filePath string) {
+	trackedFiles.Lock()
+	if trackedFiles.paths[filePath] {
+		trackedFiles.Unlock()
+		return
+	}
+	trackedFiles.paths[filePath] = true
+	trackedFiles.Unlock()
+	defer func() {
+		trackedFiles.Lock()
+		delete(trackedFiles.paths, filePath)
+		trackedFiles.Unlock()
+	}()
 
+	log.Printf("Tracking %s file for completion: %s", kind, filepath.Base(filePath))
+	if waitUntilWritten(filePath) {
+		config.jobQueue().Enqueue(kind, filePath, nil)
+	}
+}
+
+// isTracked reports whether a file is being watched for completion
+func isTracked(filePath string) bool {
+	trackedFiles.Lock()
+	defer trackedFiles.Unlock()
+	return trackedFiles.paths[filePath]
+}
+
+// waitUntilWritten waits for a file's size and modification time to settle
+// (recordings are written for minutes). It reports false if the file goes
+// away or is still changing after maxWaitTime.
+func waitUntilWritten(filePath string) bool {
 	// Initial delay to let recording start properly
 	time.Sleep(2 * time.Second)
 
@@ -1039,18 +1715,18 @@ func trackVideoFile(filePath string, config Config) {
 	for {
 		// Check timeout
 		if time.Since(startTime) > maxWaitTime {
-			log.Printf("Video tracking timeout for: %s", filepath.Base(filePath))
-			return
+			log.Printf("File tracking timeout for: %s", filepath.Base(filePath))
+			return false
 		}
 
 		// Get file info
 		fileInfo, err := os.Stat(filePath)
 		if err != nil {
 			if os.IsNotExist(err) {
-				log.Printf("Video file was deleted: %s", filepath.Base(filePath))
-				return
+				log.Printf("File was deleted: %s", filepath.Base(filePath))
+				return false
 			}
-			log.Printf("Error checking video file: %v", err)
+			log.Printf("Error checking file: %v", err)
 			time.Sleep(checkInterval)
 			continue
 		}
@@ -1071,27 +1747,21 @@ func trackVideoFile(filePath string, config Config) {
 						stableCount++
 					} else {
 						// Assume it's done after extended stable period
-						log.Printf("Video recording complete (extended stable): %s (size: %s)",
+						log.Printf("File complete (extended stable): %s (size: %s)",
 							filepath.Base(filePath), formatBytes(currentSize))
-						if err := processVideo(filePath, config); err != nil {
-							log.Printf("Error processing video: %v", err)
-						}
-						return
+						return true
 					}
 				} else {
 					file.Close()
-					log.Printf("Video recording complete: %s (size: %s)",
+					log.Printf("File complete: %s (size: %s)",
 						filepath.Base(filePath), formatBytes(currentSize))
-					if err := processVideo(filePath, config); err != nil {
-						log.Printf("Error processing video: %v", err)
-					}
-					return
+					return true
 				}
 			}
 		} else {
 			// Size or mod time changed, reset counter
 			if currentSize != lastSize {
-				log.Printf("Video still recording: %s (size: %s)",
+				log.Printf("Still being written: %s (size: %s)",
 					filepath.Base(filePath), formatBytes(currentSize))
 			}
 			stableCount = 0
@@ -1116,6 +1786,26 @@ func formatBytes(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
+// parseByteSize parses sizes like "1048576", "512KB" or "1.5GB" (binary units)
+func parseByteSize(val string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(val))
+	multiplier := int64(1)
+	for i, unit := range []string{"KB", "MB", "GB", "TB"} {
+		if strings.HasSuffix(s, unit) {
+			multiplier = int64(1) << (10 * (i + 1))
+			s = strings.TrimSuffix(s, unit)
+			break
+		}
+	}
+	s = strings.TrimSpace(strings.TrimSuffix(s, "B"))
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", val)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
 func openInBrowser(url string) error {
 	// Since we're in a container, we need to ensure proper display access
 	// The container already has access to X11/Wayland through the volume mounts
@@ -1203,24 +1893,18 @@ func parseOffsetFromURL(urlPath string) int {
 
 // NEW: Try to lookup file via metadata (fast path)
 func tryMetadataLookup(config Config, offset int) (ScreenshotMetadata, bool) {
-	allMetadata := loadAllMetadata(config)
-
-	if len(allMetadata) == 0 {
-		log.Printf("🔍 Metadata lookup: No metadata files found")
+	metadata, found, err := publicMetadataAt(config, offset)
+	if err != nil {
+		log.Printf("⚠️  Metadata lookup failed: %v", err)
 		return ScreenshotMetadata{}, false
 	}
 
-	// Sort by timestamp descending (same as original logic)
-	sort.Slice(allMetadata, func(i, j int) bool {
-		return allMetadata[i].Timestamp.After(allMetadata[j].Timestamp)
-	})
-
-	if offset >= len(allMetadata) {
-		log.Printf("🔍 Metadata lookup: Offset %d >= metadata count %d", offset, len(allMetadata))
+	if !found {
+		log.Printf("🔍 Metadata lookup: No public metadata at offset %d", offset)
 		return ScreenshotMetadata{}, false
 	}
 
-	return allMetadata[offset], true
+	return metadata, true
 }
 
 // NEW: Try to lookup file via direct filesystem scan (bulletproof path)
@@ -1232,54 +1916,61 @@ func tryFilesystemLookup(config Config, offset int) (string, bool) {
 		return "", false
 	}
 
-	if offset >= len(files) {
-		log.Printf("🔍 Filesystem lookup: Offset %d >= file count %d", offset, len(files))
-		return "", false
+	// Private and expired screenshots are skipped like in the metadata
+	// lookup; files without metadata are still served
+	now := time.Now()
+	seen := 0
+	for _, filename := range files {
+		metadata, found, err := config.metadataRepo().GetByFilename(filename)
+		if err != nil {
+			log.Printf("⚠️  Filesystem lookup: Skipping %s, metadata lookup failed: %v", filename, err)
+			continue
+		}
+		if found && (metadata.Private || isExpired(metadata, now)) {
+			continue
+		}
+		if seen == offset {
+			return fmt.Sprintf("%s/%s", config.BaseURL, filename), true
+		}
+		seen++
 	}
 
-	filename := files[offset]
-	url := fmt.Sprintf("%s/%s", config.BaseURL, filename)
-	return url, true
+	log.Printf("🔍 Filesystem lookup: Offset %d >= public file count %d", offset, seen)
+	return "", false
 }
 
-// NEW: Scan hosted directory for files, return sorted by modification time (latest first)
+// NEW: Scan hosted storage for files, return sorted by modification time (latest first)
 func scanHostedFilesForLatest(config Config) []string {
-	hostedDir := filepath.Join(config.DataDir, "hosted")
-
-	entries, err := os.ReadDir(hostedDir)
-	if err != nil {
-		log.Printf("⚠️  Error reading hosted directory: %v", err)
-		return []string{}
+	var result []string
+	for _, file := range listHostedFiles(config) {
+		if isImageFile(file.Name) {
+			result = append(result, file.Name)
+		}
 	}
+	return result
+}
 
-	type FileInfo struct {
-		Name    string
-		ModTime time.Time
+// listHostedFiles returns the hosted images and videos, most recently
+// modified first, from the metadata cache when it tracks them and from the
+// storage otherwise
+func listHostedFiles(config Config) []hostedFile {
+	if lister, ok := config.MetadataRepo.(hostedFileLister); ok {
+		if files, tracked := lister.HostedFiles(); tracked {
+			return files
+		}
 	}
 
-	var files []FileInfo
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
+	stored, err := config.hostedStorage().List()
+	if err != nil {
+		log.Printf("⚠️  Error listing hosted files: %v", err)
+		return []hostedFile{}
+	}
 
-		// Only include image/gif files
-		if !isImageFile(entry.Name()) {
-			continue
+	files := make([]hostedFile, 0, len(stored))
+	for _, file := range stored {
+		if isHostedMedia(file.Name) {
+			files = append(files, file)
 		}
-
-		fullPath := filepath.Join(hostedDir, entry.Name())
-		fileInfo, err := os.Stat(fullPath)
-		if err != nil {
-			log.Printf("⚠️  Error getting file info for %s: %v", entry.Name(), err)
-			continue
-		}
-
-		files = append(files, FileInfo{
-			Name:    entry.Name(),
-			ModTime: fileInfo.ModTime(),
-		})
 	}
 
 	// Sort by modification time descending (latest first)
@@ -1287,51 +1978,59 @@ func scanHostedFilesForLatest(config Config) []string {
 		return files[i].ModTime.After(files[j].ModTime)
 	})
 
-	// Extract just the filenames
-	var result []string
-	for _, file := range files {
-		result = append(result, file.Name)
+	log.Printf("🔍 Storage scan found %d files", len(files))
+	return files
+}
+
+// untrackedHostedFiles returns listing entries for the hosted files that have
+// no metadata and aren't the video of a screenshot that has
+func untrackedHostedFiles(config Config) []ScreenshotMetadata {
+	repo := config.metadataRepo()
+	tracked := func(filename string) bool {
+		_, found, err := repo.GetByFilename(filename)
+		if err == nil && !found && isVideoFile(filename) {
+			_, found, err = videoOwner(config, filename)
+		}
+		return found || err != nil
+	}
+	if _, scans := repo.(*jsonMetadataRepository); scans {
+		// Without an index one listing beats a directory scan per file
+		filenames := make(map[string]bool)
+		for _, metadata := range loadAllMetadata(config) {
+			filenames[metadata.Filename] = true
+			if metadata.Video != nil {
+				filenames[metadata.Video.Filename] = true
+			}
+		}
+		tracked = func(filename string) bool { return filenames[filename] }
 	}
 
-	log.Printf("🔍 Filesystem scan found %d files", len(result))
-	return result
+	var untracked []ScreenshotMetadata
+	for _, file := range listHostedFiles(config) {
+		if !tracked(file.Name) {
+			untracked = append(untracked, ScreenshotMetadata{
+				Filename:  file.Name,
+				URL:       fmt.Sprintf("%s/%s", config.BaseURL, file.Name),
+				Timestamp: file.ModTime,
+				Size:      file.Size,
+			})
+		}
+	}
+	return untracked
 }
 
-// NEW: Count actual files in hosted directory
+// NEW: Count actual files in hosted storage
 func countActualFiles(config Config) int {
 	return len(scanHostedFilesForLatest(config))
 }
 
-// NEW: Load all metadata files (extracted from original handleLatest)
+// NEW: Load all metadata entries, newest first (extracted from original handleLatest)
 func loadAllMetadata(config Config) []ScreenshotMetadata {
-	metadataDir := filepath.Join(config.DataDir, "metadata")
-	files, err := os.ReadDir(metadataDir)
+	allMetadata, err := config.metadataRepo().List()
 	if err != nil {
-		log.Printf("⚠️  Error reading metadata directory: %v", err)
+		log.Printf("⚠️  Error reading metadata: %v", err)
 		return []ScreenshotMetadata{}
 	}
-
-	var allMetadata []ScreenshotMetadata
-
-	for _, file := range files {
-		if strings.HasSuffix(file.Name(), ".json") {
-			filePath := filepath.Join(metadataDir, file.Name())
-			data, err := os.ReadFile(filePath)
-			if err != nil {
-				log.Printf("⚠️  Failed to read metadata file %s: %v", file.Name(), err)
-				continue
-			}
-
-			var metadata ScreenshotMetadata
-			if err := json.Unmarshal(data, &metadata); err != nil {
-				log.Printf("⚠️  Failed to unmarshal metadata file %s: %v", file.Name(), err)
-				continue
-			}
-
-			allMetadata = append(allMetadata, metadata)
-		}
-	}
-
 	return allMetadata
 }
 
@@ -1341,18 +2040,20 @@ func checkMetadataConsistency(config Config) []string {
 
 	// Get all metadata
 	allMetadata := loadAllMetadata(config)
-	hostedDir := filepath.Join(config.DataDir, "hosted")
+	actualFiles := scanHostedFilesForLatest(config)
+	hosted := make(map[string]bool)
+	for _, filename := range actualFiles {
+		hosted[filename] = true
+	}
 
 	// Check if metadata files have corresponding hosted files
 	for _, metadata := range allMetadata {
-		hostedPath := filepath.Join(hostedDir, metadata.Filename)
-		if !fileExists(hostedPath) {
+		if !hosted[metadata.Filename] {
 			issues = append(issues, fmt.Sprintf("Metadata references missing file: %s", metadata.Filename))
 		}
 	}
 
 	// Check if hosted files have corresponding metadata
-	actualFiles := scanHostedFilesForLatest(config)
 	metadataFilenames := make(map[string]bool)
 
 	for _, metadata := range allMetadata {
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// hostedFile is the cached stat information of a file in DataDir/hosted.
+type hostedFile struct {
+	Name    string
+	ModTime time.Time
+	Size    int64
+}
+
+// hostedFileLister is implemented by repositories that also index the
+// hosted directory, letting listHostedFiles skip the disk. tracked is false
+// when the hosted files aren't indexed (remote storage).
+type hostedFileLister interface {
+	HostedFiles() (files []hostedFile, tracked bool)
+}
+
+// metadataCache is a MetadataRepository that serves reads from memory and
+// writes through to a backing repository. fsnotify events for the metadata
+// and hosted directories keep it in sync with changes made by other
+// processes (cleanup cron, generate-missing-metadata).
+type metadataCache struct {
+	backing MetadataRepository
+	// metadataDir is only set when the backing store is the JSON layout,
+	// since only then do metadata changes show up as file events.
+	metadataDir string
+	// hostedDir is only set for local storage, which can be watched
+	hostedDir string
+
+	mu         sync.RWMutex
+	entries    map[string]ScreenshotMetadata // by ID
+	filenames  map[string]string             // filename -> ID
+	hashes     map[string]map[string]bool    // SHA-256 -> IDs
+	paths      map[string]string             // metadata file path -> ID
+	sorted     []ScreenshotMetadata          // nil when stale
+	hosted     map[string]hostedFile
+	hostedList []hostedFile // nil when stale
+}
+
+func newMetadataCache(backing MetadataRepository, hostedDir string) *metadataCache {
+	cache := &metadataCache{
+		backing:   backing,
+		hostedDir: hostedDir,
+	}
+	if jsonRepo, ok := backing.(*jsonMetadataRepository); ok {
+		cache.metadataDir = jsonRepo.dir
+	}
+	return cache
+}
+
+// addWatches registers the cached directories with the shared watcher.
+func (c *metadataCache) addWatches(watcher *fsnotify.Watcher) error {
+	if c.metadataDir != "" {
+		if err := watcher.Add(c.metadataDir); err != nil {
+			return err
+		}
+	}
+	if c.hostedDir != "" {
+		return watcher.Add(c.hostedDir)
+	}
+	return nil
+}
+
+// Rescan rebuilds the whole index from disk and the backing repository.
+func (c *metadataCache) Rescan() error {
+	entries := make(map[string]ScreenshotMetadata)
+	filenames := make(map[string]string)
+	hashes := make(map[string]map[string]bool)
+	paths := make(map[string]string)
+
+	if c.metadataDir != "" {
+		files, err := os.ReadDir(c.metadataDir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+				continue
+			}
+			path := filepath.Join(c.metadataDir, file.Name())
+			metadata, err := readMetadataFile(path)
+			if err != nil {
+				log.Printf("⚠️  %v", err)
+				continue
+			}
+			entries[metadata.ID] = metadata
+			filenames[metadata.Filename] = metadata.ID
+			indexHash(hashes, metadata)
+			paths[path] = metadata.ID
+		}
+	} else {
+		all, err := c.backing.List()
+		if err != nil {
+			return err
+		}
+		for _, metadata := range all {
+			entries[metadata.ID] = metadata
+			filenames[metadata.Filename] = metadata.ID
+			indexHash(hashes, metadata)
+		}
+	}
+
+	hosted := make(map[string]hostedFile)
+	if c.hostedDir != "" {
+		dirEntries, err := os.ReadDir(c.hostedDir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range dirEntries {
+			if entry.IsDir() || !isHostedMedia(entry.Name()) {
+				continue
+			}
+			if info, err := entry.Info(); err == nil {
+				hosted[entry.Name()] = hostedFile{Name: entry.Name(), ModTime: info.ModTime(), Size: info.Size()}
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.entries, c.filenames, c.hashes, c.paths, c.sorted = entries, filenames, hashes, paths, nil
+	c.hosted, c.hostedList = hosted, nil
+	c.mu.Unlock()
+
+	log.Printf("🔄 Metadata cache: indexed %d metadata entries and %d hosted files", len(entries), len(hosted))
+	return nil
+}
+
+// handleEvent applies a watcher event to the index. It reports whether the
+// event belonged to one of the cached directories.
+func (c *metadataCache) handleEvent(event fsnotify.Event) bool {
+	dir := filepath.Dir(event.Name)
+	switch {
+	case c.metadataDir != "" && dir == c.metadataDir:
+		if strings.HasSuffix(event.Name, ".json") {
+			c.syncMetadataFile(event.Name)
+		}
+		return true
+	case c.hostedDir != "" && dir == c.hostedDir:
+		if isHostedMedia(event.Name) {
+			c.syncHostedFile(event.Name)
+		}
+		return true
+	}
+	return false
+}
+
+func (c *metadataCache) syncMetadataFile(path string) {
+	metadata, err := readMetadataFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		c.mu.Lock()
+		if id, ok := c.paths[path]; ok {
+			delete(c.paths, path)
+			c.removeLocked(id)
+		}
+		c.mu.Unlock()
+		return
+	}
+	if err != nil {
+		// Usually a write still in progress; the next event will retry
+		return
+	}
+
+	c.mu.Lock()
+	if oldID, ok := c.paths[path]; ok && oldID != metadata.ID {
+		c.removeLocked(oldID)
+	}
+	c.paths[path] = metadata.ID
+	c.putLocked(metadata)
+	c.mu.Unlock()
+}
+
+func (c *metadataCache) syncHostedFile(path string) {
+	name := filepath.Base(path)
+	info, err := os.Stat(path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		delete(c.hosted, name)
+	} else {
+		c.hosted[name] = hostedFile{Name: name, ModTime: info.ModTime(), Size: info.Size()}
+	}
+	c.hostedList = nil
+}
+
+// indexHash adds an entry to the IDs sharing its content hash
+func indexHash(hashes map[string]map[string]bool, metadata ScreenshotMetadata) {
+	if metadata.SHA256 == "" {
+		return
+	}
+	if hashes[metadata.SHA256] == nil {
+		hashes[metadata.SHA256] = make(map[string]bool)
+	}
+	hashes[metadata.SHA256][metadata.ID] = true
+}
+
+func (c *metadataCache) putLocked(metadata ScreenshotMetadata) {
+	c.unindexLocked(metadata.ID)
+	c.entries[metadata.ID] = metadata
+	c.filenames[metadata.Filename] = metadata.ID
+	indexHash(c.hashes, metadata)
+	c.sorted = nil
+}
+
+func (c *metadataCache) removeLocked(id string) {
+	c.unindexLocked(id)
+	delete(c.entries, id)
+	c.sorted = nil
+}
+
+// unindexLocked drops the filename and hash entries of the cached version of id.
+func (c *metadataCache) unindexLocked(id string) {
+	old, ok := c.entries[id]
+	if !ok {
+		return
+	}
+	if c.filenames[old.Filename] == id {
+		delete(c.filenames, old.Filename)
+	}
+	if ids := c.hashes[old.SHA256]; ids != nil {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(c.hashes, old.SHA256)
+		}
+	}
+}
+
+// sortedEntries returns the timestamp-ordered index, rebuilding it if stale.
+// Callers must not modify the returned slice.
+func (c *metadataCache) sortedEntries() []ScreenshotMetadata {
+	c.mu.RLock()
+	sorted := c.sorted
+	c.mu.RUnlock()
+	if sorted != nil {
+		return sorted
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sorted == nil {
+		c.sorted = make([]ScreenshotMetadata, 0, len(c.entries))
+		for _, metadata := range c.entries {
+			c.sorted = append(c.sorted, metadata)
+		}
+		sortMetadataByTimestamp(c.sorted)
+	}
+	return c.sorted
+}
+
+func (c *metadataCache) List() ([]ScreenshotMetadata, error) {
+	return append([]ScreenshotMetadata(nil), c.sortedEntries()...), nil
+}
+
+func (c *metadataCache) Page(offset, limit int, filter func(ScreenshotMetadata) bool) ([]ScreenshotMetadata, int, error) {
+	matches := filterMetadata(c.sortedEntries(), filter)
+	page := paginateMetadata(matches, offset, limit)
+	return append([]ScreenshotMetadata{}, page...), len(matches), nil
+}
+
+func (c *metadataCache) Get(id string) (ScreenshotMetadata, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	metadata, ok := c.entries[id]
+	return metadata, ok, nil
+}
+
+func (c *metadataCache) GetByFilename(filename string) (ScreenshotMetadata, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	id, ok := c.filenames[filename]
+	if !ok {
+		return ScreenshotMetadata{}, false, nil
+	}
+	return c.entries[id], true, nil
+}
+
+func (c *metadataCache) ListByHash(sha256 string) ([]ScreenshotMetadata, error) {
+	c.mu.RLock()
+	var matches []ScreenshotMetadata
+	for id := range c.hashes[sha256] {
+		matches = append(matches, c.entries[id])
+	}
+	c.mu.RUnlock()
+	sortMetadataByTimestamp(matches)
+	return matches, nil
+}
+
+func (c *metadataCache) Save(metadata ScreenshotMetadata) error {
+	if err := c.backing.Save(metadata); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.putLocked(metadata)
+	if c.metadataDir != "" {
+		c.paths[filepath.Join(c.metadataDir, metadata.ID+".json")] = metadata.ID
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *metadataCache) Delete(id string) error {
+	if err := c.backing.Delete(id); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.removeLocked(id)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *metadataCache) Close() error {
+	return c.backing.Close()
+}
+
+// HostedFiles returns the hosted images and videos, most recently modified
+// first.
+func (c *metadataCache) HostedFiles() ([]hostedFile, bool) {
+	if c.hostedDir == "" {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hostedList == nil {
+		c.hostedList = make([]hostedFile, 0, len(c.hosted))
+		for _, file := range c.hosted {
+			c.hostedList = append(c.hostedList, file)
+		}
+		sort.Slice(c.hostedList, func(i, j int) bool {
+			return c.hostedList[i].ModTime.After(c.hostedList[j].ModTime)
+		})
+	}
+	return append([]hostedFile(nil), c.hostedList...), true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// waitFor polls cond until it holds or the deadline passes
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestMetadataCacheFollowsExternalEdits(t *testing.T) {
+	config, _ := createTestConfig(t)
+	createTestData(t, config)
+
+	cache := newMetadataCache(config.metadataRepo(), filepath.Join(config.DataDir, "hosted"))
+	if err := cache.Rescan(); err != nil {
+		t.Fatalf("Rescan failed: %v", err)
+	}
+	config.MetadataRepo = cache
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	defer watcher.Close()
+	if err := cache.addWatches(watcher); err != nil {
+		t.Fatalf("Failed to add watches: %v", err)
+	}
+	go func() {
+		for event := range watcher.Events {
+			cache.handleEvent(event)
+		}
+	}()
+
+	if got := len(loadAllMetadata(config)); got != 3 {
+		t.Fatalf("Expected 3 cached entries, got %d", got)
+	}
+
+	// A newer entry written by another process becomes the latest
+	newest := ScreenshotMetadata{
+		ID:        "external",
+		Filename:  "external.png",
+		URL:       config.BaseURL + "/external.png",
+		Timestamp: time.Now().Add(time.Hour),
+	}
+	if err := saveMetadata(newest, filepath.Join(config.DataDir, "metadata", "external.json")); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	if !waitFor(t, time.Second, func() bool {
+		m, ok := tryMetadataLookup(config, 0)
+		return ok && m.ID == "external"
+	}) {
+		t.Error("External metadata write was not picked up within a second")
+	}
+
+	// Removing a metadata file drops the entry
+	if err := os.Remove(filepath.Join(config.DataDir, "metadata", "test-id-0.json")); err != nil {
+		t.Fatalf("Failed to remove metadata: %v", err)
+	}
+	if !waitFor(t, time.Second, func() bool {
+		_, found, _ := cache.Get("test-id-0")
+		return !found
+	}) {
+		t.Error("Removed metadata still cached")
+	}
+
+	// Hosted files are indexed as well
+	if err := os.Remove(filepath.Join(config.DataDir, "hosted", "20240101-1400.gif")); err != nil {
+		t.Fatalf("Failed to remove hosted file: %v", err)
+	}
+	if !waitFor(t, time.Second, func() bool {
+		return len(scanHostedFilesForLatest(config)) == 2
+	}) {
+		t.Errorf("Expected 2 hosted files, got %v", scanHostedFilesForLatest(config))
+	}
+}
+
+func TestMetadataCacheListIsACopy(t *testing.T) {
+	config, _ := createTestConfig(t)
+	createTestData(t, config)
+
+	cache := newMetadataCache(config.metadataRepo(), filepath.Join(config.DataDir, "hosted"))
+	if err := cache.Rescan(); err != nil {
+		t.Fatalf("Rescan failed: %v", err)
+	}
+
+	list, _ := cache.List()
+	list[0].ID = "mutated"
+
+	again, _ := cache.List()
+	if again[0].ID == "mutated" {
+		t.Error("Mutating a listing changed the cache")
+	}
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// MetadataRepository stores screenshot metadata. Implementations must keep
+// entries addressable by ID, filename and content hash and return listings
+// ordered by timestamp, newest first.
+type MetadataRepository interface {
+	// List returns every entry, newest first.
+	List() ([]ScreenshotMetadata, error)
+	// Page returns up to limit entries starting at offset (newest first)
+	// together with the total number of entries. A non-nil filter restricts
+	// both to the entries it accepts.
+	Page(offset, limit int, filter func(ScreenshotMetadata) bool) ([]ScreenshotMetadata, int, error)
+	Get(id string) (ScreenshotMetadata, bool, error)
+	GetByFilename(filename string) (ScreenshotMetadata, bool, error)
+	// ListByHash returns the entries with the given SHA-256 of their
+	// content, newest first. Private and expiring copies share a hash with
+	// the public original.
+	ListByHash(sha256 string) ([]ScreenshotMetadata, error)
+	// Save inserts or replaces the entry with metadata.ID.
+	Save(metadata ScreenshotMetadata) error
+	Delete(id string) error
+	Close() error
+}
+
+// openMetadataRepository builds the backend selected by SSBNK_METADATA_BACKEND.
+// The bolt backend imports the existing JSON files the first time it is opened.
+func openMetadataRepository(config Config) (MetadataRepository, error) {
+	jsonRepo := newJSONMetadataRepository(filepath.Join(config.DataDir, "metadata"))
+
+	switch backend := getEnv("SSBNK_METADATA_BACKEND", "json"); backend {
+	case "json":
+		return jsonRepo, nil
+	case "bolt":
+		dbPath := getEnv("SSBNK_METADATA_DB", filepath.Join(config.DataDir, "metadata", "ssbnk.db"))
+		boltRepo, err := newBoltMetadataRepository(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		migrated, err := boltRepo.migrateFrom(jsonRepo)
+		if err != nil {
+			boltRepo.Close()
+			return nil, fmt.Errorf("failed to migrate JSON metadata: %w", err)
+		}
+		if migrated > 0 {
+			log.Printf("Migrated %d JSON metadata entries into %s", migrated, dbPath)
+		}
+		return boltRepo, nil
+	default:
+		return nil, fmt.Errorf("unknown metadata backend %q", backend)
+	}
+}
+
+// metadataUpdateMu serializes background read-modify-write updates (OCR,
+// thumbnails) so one doesn't overwrite the field another just set
+var metadataUpdateMu sync.Mutex
+
+// updateMetadata re-reads the entry with id, applies update and saves it,
+// so edits made while a background job ran aren't overwritten. It reports
+// false if the entry no longer exists.
+func updateMetadata(config Config, id string, update func(*ScreenshotMetadata)) (bool, error) {
+	metadataUpdateMu.Lock()
+	defer metadataUpdateMu.Unlock()
+
+	current, found, err := config.metadataRepo().Get(id)
+	if err != nil || !found {
+		return false, err
+	}
+	update(&current)
+	return true, config.metadataRepo().Save(current)
+}
+
+// sortMetadataByTimestamp orders entries newest first.
+func sortMetadataByTimestamp(entries []ScreenshotMetadata) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.After(entries[j].Timestamp)
+	})
+}
+
+// paginateMetadata slices an already sorted listing. A negative offset
+// starts at the beginning.
+func paginateMetadata(entries []ScreenshotMetadata, offset, limit int) []ScreenshotMetadata {
+	offset = max(offset, 0)
+	if offset >= len(entries) {
+		return []ScreenshotMetadata{}
+	}
+	end := offset + limit
+	if limit <= 0 || end > len(entries) {
+		end = len(entries)
+	}
+	return entries[offset:end]
+}
+
+// filterMetadata returns the entries accepted by filter; a nil filter
+// accepts everything
+func filterMetadata(entries []ScreenshotMetadata, filter func(ScreenshotMetadata) bool) []ScreenshotMetadata {
+	if filter == nil {
+		return entries
+	}
+	result := []ScreenshotMetadata{}
+	for _, metadata := range entries {
+		if filter(metadata) {
+			result = append(result, metadata)
+		}
+	}
+	return result
+}
+
+// jsonMetadataRepository is the original layout: one <id>.json file per
+// screenshot in DataDir/metadata. Lookups other than by ID scan the directory.
+type jsonMetadataRepository struct {
+	dir string
+}
+
+func newJSONMetadataRepository(dir string) *jsonMetadataRepository {
+	return &jsonMetadataRepository{dir: dir}
+}
+
+func (r *jsonMetadataRepository) path(id string) string {
+	return filepath.Join(r.dir, fmt.Sprintf("%s.json", id))
+}
+
+func (r *jsonMetadataRepository) List() ([]ScreenshotMetadata, error) {
+	files, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata directory: %w", err)
+	}
+
+	var allMetadata []ScreenshotMetadata
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		metadata, err := readMetadataFile(filepath.Join(r.dir, file.Name()))
+		if err != nil {
+			log.Printf("⚠️  %v", err)
+			continue
+		}
+		allMetadata = append(allMetadata, metadata)
+	}
+
+	sortMetadataByTimestamp(allMetadata)
+	return allMetadata, nil
+}
+
+func (r *jsonMetadataRepository) Page(offset, limit int, filter func(ScreenshotMetadata) bool) ([]ScreenshotMetadata, int, error) {
+	allMetadata, err := r.List()
+	if err != nil {
+		return nil, 0, err
+	}
+	allMetadata = filterMetadata(allMetadata, filter)
+	return paginateMetadata(allMetadata, offset, limit), len(allMetadata), nil
+}
+
+func (r *jsonMetadataRepository) Get(id string) (ScreenshotMetadata, bool, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return ScreenshotMetadata{}, false, nil
+	}
+
+	// Files written by ssbnk are named after the ID
+	metadata, err := readMetadataFile(r.path(id))
+	if err == nil && metadata.ID == id {
+		return metadata, true, nil
+	}
+
+	return r.find(func(m ScreenshotMetadata) bool { return m.ID == id })
+}
+
+func (r *jsonMetadataRepository) GetByFilename(filename string) (ScreenshotMetadata, bool, error) {
+	return r.find(func(m ScreenshotMetadata) bool { return m.Filename == filename })
+}
+
+func (r *jsonMetadataRepository) ListByHash(sha256 string) ([]ScreenshotMetadata, error) {
+	if sha256 == "" {
+		return nil, nil
+	}
+	allMetadata, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	var matches []ScreenshotMetadata
+	for _, metadata := range allMetadata {
+		if metadata.SHA256 == sha256 {
+			matches = append(matches, metadata)
+		}
+	}
+	return matches, nil
+}
+
+func (r *jsonMetadataRepository) find(match func(ScreenshotMetadata) bool) (ScreenshotMetadata, bool, error) {
+	allMetadata, err := r.List()
+	if err != nil {
+		return ScreenshotMetadata{}, false, err
+	}
+	for _, metadata := range allMetadata {
+		if match(metadata) {
+			return metadata, true, nil
+		}
+	}
+	return ScreenshotMetadata{}, false, nil
+}
+
+func (r *jsonMetadataRepository) Save(metadata ScreenshotMetadata) error {
+	if metadata.ID == "" {
+		return fmt.Errorf("metadata has no ID")
+	}
+	return saveMetadata(metadata, r.path(metadata.ID))
+}
+
+func (r *jsonMetadataRepository) Delete(id string) error {
+	err := os.Remove(r.path(id))
+	if err == nil {
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	// Fall back to files that aren't named after their ID
+	files, err := os.ReadDir(r.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata directory: %w", err)
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(r.dir, file.Name())
+		if metadata, err := readMetadataFile(path); err == nil && metadata.ID == id {
+			return os.Remove(path)
+		}
+	}
+	return nil
+}
+
+func (r *jsonMetadataRepository) Close() error {
+	return nil
+}
+
+func readMetadataFile(path string) (ScreenshotMetadata, error) {
+	var metadata ScreenshotMetadata
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return metadata, fmt.Errorf("failed to read metadata file %s: %w", filepath.Base(path), err)
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return metadata, fmt.Errorf("failed to unmarshal metadata file %s: %w", filepath.Base(path), err)
+	}
+	return metadata, nil
+}
+
+var (
+	boltScreenshotsBucket = []byte("screenshots")
+	boltFilenameBucket    = []byte("by_filename")
+	boltTimestampBucket   = []byte("by_timestamp")
+	boltHashBucket        = []byte("by_sha256")
+	boltMetaBucket        = []byte("meta")
+	boltMigratedKey       = []byte("json_migrated_at")
+	// boltHashIndexKey marks the hash index as keyed by hash and ID; before
+	// that it held a single ID per hash
+	boltHashIndexKey = []byte("hash_index_by_id")
+	// boltCountKey holds the number of stored entries, so unfiltered pages
+	// don't walk the bucket to report their total
+	boltCountKey = []byte("screenshot_count")
+)
+
+// boltMetadataRepository keeps metadata in a single bbolt file with
+// secondary index buckets for filename, content hash and timestamp.
+type boltMetadataRepository struct {
+	db *bolt.DB
+}
+
+func newBoltMetadataRepository(path string) (*boltMetadataRepository, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltScreenshotsBucket, boltFilenameBucket, boltTimestampBucket, boltHashBucket, boltMetaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(boltMetaBucket).Get(boltHashIndexKey) == nil {
+			if err := rebuildHashIndex(tx); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(boltMetaBucket).Get(boltCountKey) == nil {
+			return putCount(tx, tx.Bucket(boltScreenshotsBucket).Stats().KeyN)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize metadata database: %w", err)
+	}
+
+	return &boltMetadataRepository{db: db}, nil
+}
+
+// rebuildHashIndex recreates the hash index from the stored entries
+func rebuildHashIndex(tx *bolt.Tx) error {
+	if err := tx.DeleteBucket(boltHashBucket); err != nil {
+		return err
+	}
+	hashes, err := tx.CreateBucket(boltHashBucket)
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket(boltScreenshotsBucket).ForEach(func(id, data []byte) error {
+		var metadata ScreenshotMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return fmt.Errorf("failed to decode metadata %s: %w", id, err)
+		}
+		if metadata.SHA256 == "" {
+			return nil
+		}
+		return hashes.Put(hashKey(metadata.SHA256, metadata.ID), []byte(metadata.ID))
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltMetaBucket).Put(boltHashIndexKey, []byte(time.Now().Format(time.RFC3339)))
+}
+
+// boltCount reads the stored entry count
+func boltCount(tx *bolt.Tx) int {
+	data := tx.Bucket(boltMetaBucket).Get(boltCountKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(data))
+}
+
+func putCount(tx *bolt.Tx, count int) error {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(max(count, 0)))
+	return tx.Bucket(boltMetaBucket).Put(boltCountKey, data)
+}
+
+// hashKey indexes an entry under its content hash; the ID suffix lets
+// several entries share a hash
+func hashKey(sha256, id string) []byte {
+	return []byte(sha256 + "\x00" + id)
+}
+
+// timestampKey sorts lexicographically in timestamp order; the ID suffix
+// keeps keys unique when two screenshots share a timestamp.
+func timestampKey(metadata ScreenshotMetadata) []byte {
+	var nanos int64
+	if !metadata.Timestamp.IsZero() {
+		nanos = metadata.Timestamp.UnixNano()
+	}
+	key := make([]byte, 8, 8+len(metadata.ID))
+	binary.BigEndian.PutUint64(key, uint64(nanos)^(1<<63))
+	return append(key, metadata.ID...)
+}
+
+func (r *boltMetadataRepository) List() ([]ScreenshotMetadata, error) {
+	entries, _, err := r.Page(0, 0, nil)
+	return entries, err
+}
+
+// Page walks the timestamp index. Without a filter only the returned
+// entries are decoded; with one, every entry is, to count the matches.
+func (r *boltMetadataRepository) Page(offset, limit int, filter func(ScreenshotMetadata) bool) ([]ScreenshotMetadata, int, error) {
+	entries := []ScreenshotMetadata{}
+	total := 0
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		screenshots := tx.Bucket(boltScreenshotsBucket)
+		if filter == nil {
+			total = boltCount(tx)
+		}
+
+		c := tx.Bucket(boltTimestampBucket).Cursor()
+		matched := 0
+		for k, id := c.Last(); k != nil; k, id = c.Prev() {
+			inPage := matched >= offset && (limit <= 0 || len(entries) < limit)
+			if filter == nil && !inPage {
+				// The total comes from the counter, so entries outside
+				// the page needn't be decoded
+				if matched >= offset {
+					break
+				}
+				matched++
+				continue
+			}
+			var metadata ScreenshotMetadata
+			if err := json.Unmarshal(screenshots.Get(id), &metadata); err != nil {
+				return fmt.Errorf("failed to decode metadata %s: %w", id, err)
+			}
+			if filter != nil && !filter(metadata) {
+				continue
+			}
+			if inPage {
+				entries = append(entries, metadata)
+			}
+			matched++
+		}
+		if filter != nil {
+			total = matched
+		}
+		return nil
+	})
+
+	return entries, total, err
+}
+
+func (r *boltMetadataRepository) Get(id string) (ScreenshotMetadata, bool, error) {
+	var metadata ScreenshotMetadata
+	found := false
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltScreenshotsBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &metadata)
+	})
+
+	return metadata, found, err
+}
+
+func (r *boltMetadataRepository) GetByFilename(filename string) (ScreenshotMetadata, bool, error) {
+	return r.getByIndex(boltFilenameBucket, filename)
+}
+
+func (r *boltMetadataRepository) ListByHash(sha256 string) ([]ScreenshotMetadata, error) {
+	if sha256 == "" {
+		return nil, nil
+	}
+
+	var matches []ScreenshotMetadata
+	err := r.db.View(func(tx *bolt.Tx) error {
+		screenshots := tx.Bucket(boltScreenshotsBucket)
+		prefix := hashKey(sha256, "")
+		c := tx.Bucket(boltHashBucket).Cursor()
+		for k, id := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, id = c.Next() {
+			data := screenshots.Get(id)
+			if data == nil {
+				continue
+			}
+			var metadata ScreenshotMetadata
+			if err := json.Unmarshal(data, &metadata); err != nil {
+				return fmt.Errorf("failed to decode metadata %s: %w", id, err)
+			}
+			matches = append(matches, metadata)
+		}
+		return nil
+	})
+	sortMetadataByTimestamp(matches)
+	return matches, err
+}
+
+// getByIndex resolves key through a secondary index bucket.
+func (r *boltMetadataRepository) getByIndex(bucket []byte, key string) (ScreenshotMetadata, bool, error) {
+	var id []byte
+	err := r.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucket).Get([]byte(key)); v != nil {
+			id = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil || id == nil {
+		return ScreenshotMetadata{}, false, err
+	}
+	return r.Get(string(id))
+}
+
+func (r *boltMetadataRepository) Save(metadata ScreenshotMetadata) error {
+	if metadata.ID == "" {
+		return fmt.Errorf("metadata has no ID")
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		screenshots := tx.Bucket(boltScreenshotsBucket)
+		if screenshots.Get([]byte(metadata.ID)) == nil {
+			if err := putCount(tx, boltCount(tx)+1); err != nil {
+				return err
+			}
+		}
+		if err := r.unindex(tx, metadata.ID); err != nil {
+			return err
+		}
+		if err := screenshots.Put([]byte(metadata.ID), data); err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltTimestampBucket).Put(timestampKey(metadata), []byte(metadata.ID)); err != nil {
+			return err
+		}
+		if metadata.SHA256 != "" {
+			if err := tx.Bucket(boltHashBucket).Put(hashKey(metadata.SHA256, metadata.ID), []byte(metadata.ID)); err != nil {
+				return err
+			}
+		}
+		if metadata.Filename != "" {
+			return tx.Bucket(boltFilenameBucket).Put([]byte(metadata.Filename), []byte(metadata.ID))
+		}
+		return nil
+	})
+}
+
+func (r *boltMetadataRepository) Delete(id string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		screenshots := tx.Bucket(boltScreenshotsBucket)
+		if screenshots.Get([]byte(id)) == nil {
+			return nil
+		}
+		if err := r.unindex(tx, id); err != nil {
+			return err
+		}
+		if err := putCount(tx, boltCount(tx)-1); err != nil {
+			return err
+		}
+		return screenshots.Delete([]byte(id))
+	})
+}
+
+// unindex removes the secondary index entries of the stored version of id.
+func (r *boltMetadataRepository) unindex(tx *bolt.Tx, id string) error {
+	data := tx.Bucket(boltScreenshotsBucket).Get([]byte(id))
+	if data == nil {
+		return nil
+	}
+
+	var existing ScreenshotMetadata
+	if err := json.Unmarshal(data, &existing); err != nil {
+		return fmt.Errorf("failed to decode metadata %s: %w", id, err)
+	}
+	if err := tx.Bucket(boltTimestampBucket).Delete(timestampKey(existing)); err != nil {
+		return err
+	}
+
+	if existing.SHA256 != "" {
+		if err := tx.Bucket(boltHashBucket).Delete(hashKey(existing.SHA256, id)); err != nil {
+			return err
+		}
+	}
+
+	filenames := tx.Bucket(boltFilenameBucket)
+	if string(filenames.Get([]byte(existing.Filename))) == id {
+		return filenames.Delete([]byte(existing.Filename))
+	}
+	return nil
+}
+
+// migrateFrom copies every entry from src exactly once. Later calls are
+// no-ops, so JSON files written after the migration are not re-imported.
+func (r *boltMetadataRepository) migrateFrom(src MetadataRepository) (int, error) {
+	migrated := false
+	r.db.View(func(tx *bolt.Tx) error {
+		migrated = tx.Bucket(boltMetaBucket).Get(boltMigratedKey) != nil
+		return nil
+	})
+	if migrated {
+		return 0, nil
+	}
+
+	entries, err := src.List()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, metadata := range entries {
+		if metadata.ID == "" {
+			continue
+		}
+		if err := r.Save(metadata); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	err = r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMetaBucket).Put(boltMigratedKey, []byte(time.Now().Format(time.RFC3339)))
+	})
+	return count, err
+}
+
+func (r *boltMetadataRepository) Close() error {
+	return r.db.Close()
+}
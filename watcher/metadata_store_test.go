@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func metadataRepositories(t *testing.T) map[string]MetadataRepository {
+	t.Helper()
+	config, _ := createTestConfig(t)
+
+	boltRepo, err := newBoltMetadataRepository(filepath.Join(config.DataDir, "metadata", "ssbnk.db"))
+	if err != nil {
+		t.Fatalf("Failed to open bolt repository: %v", err)
+	}
+	t.Cleanup(func() { boltRepo.Close() })
+
+	jsonConfig, _ := createTestConfig(t)
+	return map[string]MetadataRepository{
+		"json": jsonConfig.metadataRepo(),
+		"bolt": boltRepo,
+	}
+}
+
+func TestMetadataRepositories(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for name, repo := range metadataRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				metadata := ScreenshotMetadata{
+					ID:        fmt.Sprintf("id-%d", i),
+					Filename:  fmt.Sprintf("file-%d.png", i),
+					Timestamp: base.Add(time.Duration(i) * time.Hour),
+				}
+				if err := repo.Save(metadata); err != nil {
+					t.Fatalf("Save failed: %v", err)
+				}
+			}
+
+			page, total, err := repo.Page(1, 1, nil)
+			if err != nil {
+				t.Fatalf("Page failed: %v", err)
+			}
+			if total != 3 || len(page) != 1 || page[0].ID != "id-1" {
+				t.Errorf("Page(1, 1) = %v (total %d), expected id-1 of 3", page, total)
+			}
+
+			notNewest := func(m ScreenshotMetadata) bool { return m.ID != "id-2" }
+			page, total, err = repo.Page(1, 5, notNewest)
+			if err != nil {
+				t.Fatalf("Page failed: %v", err)
+			}
+			if total != 2 || len(page) != 1 || page[0].ID != "id-0" {
+				t.Errorf("Filtered Page(1, 5) = %v (total %d), expected id-0 of 2", page, total)
+			}
+
+			// Re-saving with a new timestamp must move the entry in the index
+			updated := ScreenshotMetadata{ID: "id-0", Filename: "renamed.png", Timestamp: base.Add(5 * time.Hour)}
+			if err := repo.Save(updated); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+			all, err := repo.List()
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			if len(all) != 3 || all[0].ID != "id-0" {
+				t.Errorf("Expected id-0 to be newest after update, got %v", all)
+			}
+
+			if _, found, _ := repo.GetByFilename("file-0.png"); found {
+				t.Error("Stale filename index entry still resolves")
+			}
+			if m, found, _ := repo.GetByFilename("renamed.png"); !found || m.ID != "id-0" {
+				t.Errorf("GetByFilename(renamed.png) = %v, %v", m, found)
+			}
+
+			if err := repo.Delete("id-2"); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			if _, found, _ := repo.Get("id-2"); found {
+				t.Error("Deleted entry still found")
+			}
+			if _, total, _ := repo.Page(0, 10, nil); total != 2 {
+				t.Errorf("Expected 2 entries after delete, got %d", total)
+			}
+			if err := repo.Delete("id-2"); err != nil {
+				t.Fatalf("Deleting a missing entry failed: %v", err)
+			}
+			if _, total, _ := repo.Page(0, 10, nil); total != 2 {
+				t.Errorf("Expected 2 entries after a repeated delete, got %d", total)
+			}
+		})
+	}
+}
+
+func TestPaginateMetadata(t *testing.T) {
+	entries := []ScreenshotMetadata{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+
+	tests := []struct {
+		name     string
+		offset   int
+		limit    int
+		expected []string
+	}{
+		{"All", 0, 0, []string{"a", "b", "c"}},
+		{"Window", 1, 1, []string{"b"}},
+		{"LimitPastEnd", 2, 5, []string{"c"}},
+		{"OffsetPastEnd", 3, 1, []string{}},
+		{"NegativeOffset", -2, 2, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids := []string{}
+			for _, metadata := range paginateMetadata(entries, tt.offset, tt.limit) {
+				ids = append(ids, metadata.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.expected) {
+				t.Errorf("paginateMetadata(%d, %d) = %v, expected %v", tt.offset, tt.limit, ids, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMetadataRepositoriesListByHash(t *testing.T) {
+	repos := metadataRepositories(t)
+	cacheConfig, _ := createTestConfig(t)
+	cache := newMetadataCache(cacheConfig.metadataRepo(), filepath.Join(cacheConfig.DataDir, "hosted"))
+	if err := cache.Rescan(); err != nil {
+		t.Fatalf("Rescan failed: %v", err)
+	}
+	repos["cache"] = cache
+
+	ids := func(entries []ScreenshotMetadata) []string {
+		result := []string{}
+		for _, metadata := range entries {
+			result = append(result, metadata.ID)
+		}
+		return result
+	}
+
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			original := ScreenshotMetadata{ID: "a", Filename: "a.png", SHA256: "aaaa", Timestamp: now}
+			privateCopy := ScreenshotMetadata{ID: "b", Filename: "b.png", SHA256: "aaaa", Timestamp: now.Add(time.Minute), Private: true}
+			for _, metadata := range []ScreenshotMetadata{original, privateCopy} {
+				if err := repo.Save(metadata); err != nil {
+					t.Fatalf("Save failed: %v", err)
+				}
+			}
+			if matches, _ := repo.ListByHash("aaaa"); !reflect.DeepEqual(ids(matches), []string{"b", "a"}) {
+				t.Errorf("ListByHash(aaaa) = %v, expected [b a]", ids(matches))
+			}
+			if matches, _ := repo.ListByHash(""); len(matches) != 0 {
+				t.Error("Empty hash must not match")
+			}
+
+			// Deleting the copy keeps the original findable
+			repo.Delete("b")
+			if matches, _ := repo.ListByHash("aaaa"); !reflect.DeepEqual(ids(matches), []string{"a"}) {
+				t.Errorf("ListByHash(aaaa) after deleting the copy = %v", ids(matches))
+			}
+
+			original.SHA256 = "bbbb"
+			repo.Save(original)
+			if matches, _ := repo.ListByHash("aaaa"); len(matches) != 0 {
+				t.Error("Stale hash index entry still resolves")
+			}
+
+			repo.Delete("a")
+			if matches, _ := repo.ListByHash("bbbb"); len(matches) != 0 {
+				t.Error("Deleted entry still found by hash")
+			}
+		})
+	}
+}
+
+func TestBoltMigrationRunsOnce(t *testing.T) {
+	config, _ := createTestConfig(t)
+	createTestData(t, config)
+
+	boltRepo, err := newBoltMetadataRepository(filepath.Join(t.TempDir(), "ssbnk.db"))
+	if err != nil {
+		t.Fatalf("Failed to open bolt repository: %v", err)
+	}
+	defer boltRepo.Close()
+
+	jsonRepo := config.metadataRepo()
+	migrated, err := boltRepo.migrateFrom(jsonRepo)
+	if err != nil {
+		t.Fatalf("Migration failed: %v", err)
+	}
+	if migrated != 3 {
+		t.Errorf("Expected 3 migrated entries, got %d", migrated)
+	}
+
+	want, _ := jsonRepo.List()
+	got, _ := boltRepo.List()
+	if len(got) != len(want) || got[0].ID != want[0].ID {
+		t.Errorf("Migrated listing %v does not match source %v", got, want)
+	}
+
+	if again, _ := boltRepo.migrateFrom(jsonRepo); again != 0 {
+		t.Errorf("Second migration imported %d entries, expected 0", again)
+	}
+}
+
+func TestBoltRebuildsSingleValuedHashIndex(t *testing.T) {
+	config, _ := createTestConfig(t)
+	path := filepath.Join(config.DataDir, "metadata", "ssbnk.db")
+	repo, err := newBoltMetadataRepository(path)
+	if err != nil {
+		t.Fatalf("Failed to open bolt repository: %v", err)
+	}
+	repo.Save(ScreenshotMetadata{ID: "a", Filename: "a.png", SHA256: "aaaa", Timestamp: time.Now()})
+
+	// Rewind the index to the layout of one ID per hash
+	repo.db.Update(func(tx *bolt.Tx) error {
+		tx.Bucket(boltMetaBucket).Delete(boltHashIndexKey)
+		tx.DeleteBucket(boltHashBucket)
+		hashes, _ := tx.CreateBucket(boltHashBucket)
+		return hashes.Put([]byte("aaaa"), []byte("a"))
+	})
+	repo.Close()
+
+	repo, err = newBoltMetadataRepository(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen bolt repository: %v", err)
+	}
+	defer repo.Close()
+	if matches, err := repo.ListByHash("aaaa"); err != nil || len(matches) != 1 || matches[0].ID != "a" {
+		t.Errorf("ListByHash(aaaa) = %v, %v after the rebuild", matches, err)
+	}
+}
+
+func TestBoltCountsExistingEntries(t *testing.T) {
+	config, _ := createTestConfig(t)
+	path := filepath.Join(config.DataDir, "metadata", "ssbnk.db")
+	repo, err := newBoltMetadataRepository(path)
+	if err != nil {
+		t.Fatalf("Failed to open bolt repository: %v", err)
+	}
+	repo.Save(ScreenshotMetadata{ID: "a", Filename: "a.png", Timestamp: time.Now()})
+	repo.Save(ScreenshotMetadata{ID: "b", Filename: "b.png", Timestamp: time.Now()})
+
+	// Databases written before the counter existed have no count key
+	repo.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMetaBucket).Delete(boltCountKey)
+	})
+	repo.Close()
+
+	repo, err = newBoltMetadataRepository(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen bolt repository: %v", err)
+	}
+	defer repo.Close()
+	if _, total, err := repo.Page(0, 1, nil); err != nil || total != 2 {
+		t.Errorf("Expected a total of 2 after reopening, got %d, %v", total, err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultFilenameTemplate reproduces the historical 20060102-1504 names
+const defaultFilenameTemplate = "{timestamp}"
+
+// slugLength is the length of {slug}: 36^8 names is plenty to avoid guessing
+const slugLength = 8
+
+const slugAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// filenameVars holds the values a filename template can reference
+type filenameVars struct {
+	Time     time.Time
+	Original string // original filename, extension is dropped
+	Repo     string
+	Hash     string // hex SHA-256 of the content
+}
+
+var templateTokenPattern = regexp.MustCompile(`\{([a-z]+)\}`)
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// filenameTokens renders each supported template token
+var filenameTokens = map[string]func(vars filenameVars) string{
+	"timestamp": func(vars filenameVars) string { return vars.Time.Format("20060102-1504") },
+	"date":      func(vars filenameVars) string { return vars.Time.Format("20060102") },
+	"slug":      func(vars filenameVars) string { return randomSlug(slugLength) },
+	"hash": func(vars filenameVars) string {
+		if len(vars.Hash) > 12 {
+			return vars.Hash[:12]
+		}
+		return vars.Hash
+	},
+	"original": func(vars filenameVars) string {
+		return sanitizeNamePart(strings.TrimSuffix(vars.Original, filepath.Ext(vars.Original)))
+	},
+	"repo": func(vars filenameVars) string { return sanitizeNamePart(strings.ReplaceAll(vars.Repo, "/", "-")) },
+}
+
+// validateFilenameTemplate rejects templates with unknown tokens or path separators
+func validateFilenameTemplate(tmpl string) error {
+	if strings.ContainsAny(tmpl, `/\`) {
+		return fmt.Errorf("filename template %q must not contain path separators", tmpl)
+	}
+	for _, match := range templateTokenPattern.FindAllStringSubmatch(tmpl, -1) {
+		if _, ok := filenameTokens[match[1]]; !ok {
+			return fmt.Errorf("unknown filename template token {%s}", match[1])
+		}
+	}
+	return nil
+}
+
+// renderFilename expands tmpl without an extension. Empty results fall
+// back to the timestamp so a name is always produced.
+func renderFilename(tmpl string, vars filenameVars) string {
+	if tmpl == "" {
+		tmpl = defaultFilenameTemplate
+	}
+	name := templateTokenPattern.ReplaceAllStringFunc(tmpl, func(token string) string {
+		if render, ok := filenameTokens[strings.Trim(token, "{}")]; ok {
+			return render(vars)
+		}
+		return ""
+	})
+
+	name = strings.Trim(sanitizeNamePart(name), "-.")
+	if name == "" {
+		name = vars.Time.Format("20060102-1504")
+	}
+	return name
+}
+
+func sanitizeNamePart(s string) string {
+	s = strings.Trim(unsafeNameChars.ReplaceAllString(s, "-"), "-")
+	if len(s) > 64 {
+		s = s[:64]
+	}
+	return s
+}
+
+func randomSlug(length int) string {
+	slug := make([]byte, length)
+	max := big.NewInt(int64(len(slugAlphabet)))
+	for i := range slug {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(fmt.Sprintf("crypto/rand failed: %v", err))
+		}
+		slug[i] = slugAlphabet[n.Int64()]
+	}
+	return string(slug)
+}
+
+// moveFile renames src to dst, copying across devices. An existing dst is
+// replaced.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	if err := os.Remove(src); err != nil {
+		log.Printf("Warning: Failed to remove %s: %v", src, err)
+	}
+	return nil
+}
+
+// hashReader returns the hex SHA-256 of everything read from r
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileSHA256 returns the hex SHA-256 of a file's contents
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return hashReader(file)
+}
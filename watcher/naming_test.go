@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRenderFilename(t *testing.T) {
+	vars := filenameVars{
+		Time:     time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC),
+		Original: "Screenshot from 2024-01-02 15-04-05.png",
+		Repo:     "delorenj/ssbnk",
+		Hash:     "0123456789abcdef0123456789abcdef",
+	}
+
+	tests := map[string]string{
+		"":                    "20240102-1504",
+		"{timestamp}":         "20240102-1504",
+		"{date}-{hash}":       "20240102-0123456789ab",
+		"{repo}-{original}":   "delorenj-ssbnk-Screenshot-from-2024-01-02-15-04-05",
+		"{original}":          "Screenshot-from-2024-01-02-15-04-05",
+		"shot {hash}":         "shot-0123456789ab",
+		"{repo}":              "delorenj-ssbnk",
+		"{repo}{original}!!!": "delorenj-ssbnkScreenshot-from-2024-01-02-15-04-05",
+	}
+	for tmpl, expected := range tests {
+		if got := renderFilename(tmpl, vars); got != expected {
+			t.Errorf("renderFilename(%q) = %q, expected %q", tmpl, got, expected)
+		}
+	}
+
+	if got := renderFilename("{slug}", vars); !regexp.MustCompile(`^[a-z0-9]{8}$`).MatchString(got) {
+		t.Errorf("Unexpected slug %q", got)
+	}
+
+	// Templates that render empty fall back to the timestamp
+	if got := renderFilename("{repo}", filenameVars{Time: vars.Time}); got != "20240102-1504" {
+		t.Errorf("Expected timestamp fallback, got %q", got)
+	}
+}
+
+func TestValidateFilenameTemplate(t *testing.T) {
+	for _, tmpl := range []string{"{timestamp}", "{slug}", "ss-{date}-{hash}"} {
+		if err := validateFilenameTemplate(tmpl); err != nil {
+			t.Errorf("validateFilenameTemplate(%q) failed: %v", tmpl, err)
+		}
+	}
+	for _, tmpl := range []string{"{uuid}", "../{slug}", `a\{slug}`} {
+		if err := validateFilenameTemplate(tmpl); err == nil {
+			t.Errorf("Expected %q to be rejected", tmpl)
+		}
+	}
+}
+
+func TestCreateUniqueIsExclusive(t *testing.T) {
+	storage := newLocalStorage(t.TempDir())
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	names := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name, _, err := createUnique(storage, "20240102-1504", ".png", strings.NewReader("content"))
+			if err != nil {
+				t.Errorf("createUnique failed: %v", err)
+				return
+			}
+			mu.Lock()
+			names[name] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(names) != 20 {
+		t.Errorf("Expected 20 distinct names, got %d", len(names))
+	}
+	if !names["20240102-1504.png"] || !names["20240102-1504-19.png"] {
+		t.Errorf("Unexpected names: %v", names)
+	}
+}
+
+func TestProcessScreenshotUsesTemplate(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.FilenameTemplate = "{original}-{hash}"
+
+	sourcePath := filepath.Join(config.ScreenshotDir, "my shot.png")
+	if err := os.WriteFile(sourcePath, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to create screenshot: %v", err)
+	}
+
+	if err := processScreenshot(sourcePath, config); err != nil {
+		t.Fatalf("processScreenshot failed: %v", err)
+	}
+
+	all := loadAllMetadata(config)
+	if len(all) != 1 {
+		t.Fatalf("Expected 1 metadata entry, got %d", len(all))
+	}
+	// sha256("content") starts with ed7002b439e9
+	if all[0].Filename != "my-shot-ed7002b439e9.png" {
+		t.Errorf("Unexpected filename %q", all[0].Filename)
+	}
+	if !strings.HasSuffix(all[0].URL, "/"+all[0].Filename) {
+		t.Errorf("URL %q does not match filename", all[0].URL)
+	}
+}
+
+func TestProcessScreenshotNamesRecentGIFsByTemplate(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.FilenameTemplate = "{slug}"
+
+	// A GIF written moments ago, as by a screencast converter
+	sourcePath := filepath.Join(config.ScreenshotDir, "recording.gif")
+	if err := os.WriteFile(sourcePath, []byte("GIF89a frames"), 0644); err != nil {
+		t.Fatalf("Failed to create GIF: %v", err)
+	}
+
+	if err := processScreenshot(sourcePath, config); err != nil {
+		t.Fatalf("processScreenshot failed: %v", err)
+	}
+
+	all := loadAllMetadata(config)
+	if len(all) != 1 {
+		t.Fatalf("Expected 1 metadata entry, got %d", len(all))
+	}
+	if !regexp.MustCompile(`^[a-z0-9]{8}\.gif$`).MatchString(all[0].Filename) || all[0].OriginalName != "recording.gif" {
+		t.Errorf("Expected a slug name, got %q for %q", all[0].Filename, all[0].OriginalName)
+	}
+}
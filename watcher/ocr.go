@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ocrSlots bounds the number of concurrent tesseract processes
+var ocrSlots = make(chan struct{}, 2)
+
+// resolveOCR decides whether OCR runs, based on SSBNK_OCR (auto, true or
+// false). In auto mode OCR is enabled only when tesseract is on the PATH.
+func resolveOCR() bool {
+	mode := getEnv("SSBNK_OCR", "auto")
+	if mode == "false" {
+		return false
+	}
+
+	_, err := exec.LookPath("tesseract")
+	if err != nil {
+		if mode == "true" {
+			log.Printf("Warning: SSBNK_OCR=true but tesseract is not installed, OCR disabled")
+		}
+		return false
+	}
+	return true
+}
+
+// extractText runs tesseract on an image and returns the recognized text
+func extractText(imagePath string) (string, error) {
+	output, err := runTesseract(imagePath)
+	if err != nil {
+		return "", err
+	}
+	return normalizeOCRText(output), nil
+}
+
+// runTesseract runs tesseract on an image with the configured language and
+// returns its output; extra arguments select an output format such as tsv
+func runTesseract(imagePath string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	args = append([]string{imagePath, "stdout", "-l", getEnv("SSBNK_OCR_LANG", "eng")}, args...)
+	cmd := exec.CommandContext(ctx, "tesseract", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("tesseract timed out")
+		}
+		return "", fmt.Errorf("tesseract error: %w\nOutput: %s", err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// normalizeOCRText collapses the blank lines and trailing whitespace tesseract emits
+func normalizeOCRText(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r\f")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// scheduleOCR extracts text from a freshly ingested image in the background
+// and stores it on the screenshot's metadata. GIFs are skipped.
+func scheduleOCR(config Config, metadata ScreenshotMetadata) {
+	if !config.OCREnabled || mediaType(metadata.Filename) == "gif" {
+		return
+	}
+
+	go func() {
+		ocrSlots <- struct{}{}
+		defer func() { <-ocrSlots }()
+
+		imagePath, cleanup, err := hostedLocalCopy(config, metadata.Filename)
+		if err != nil {
+			log.Printf("Warning: OCR failed to read %s: %v", metadata.Filename, err)
+			return
+		}
+		text, err := extractText(imagePath)
+		cleanup()
+		if err != nil {
+			log.Printf("Warning: OCR failed for %s: %v", metadata.Filename, err)
+			return
+		}
+		if text == "" {
+			return
+		}
+
+		found, err := updateMetadata(config, metadata.ID, func(current *ScreenshotMetadata) {
+			current.OCRText = text
+		})
+		if err != nil {
+			log.Printf("Warning: Failed to save OCR text for %s: %v", metadata.Filename, err)
+			return
+		}
+		if !found {
+			return
+		}
+		log.Printf("OCR: extracted %d characters from %s", len(text), metadata.Filename)
+	}()
+}
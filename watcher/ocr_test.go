@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// installFakeTool puts an executable shell script named name first on the PATH
+func installFakeTool(t *testing.T, name, script string) {
+	t.Helper()
+	binDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(binDir, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("Failed to create fake %s: %v", name, err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestNormalizeOCRText(t *testing.T) {
+	input := "panic: runtime error  \n\n\tgoroutine 1 [running]:\r\n\f"
+	expected := "panic: runtime error\n\tgoroutine 1 [running]:"
+	if got := normalizeOCRText(input); got != expected {
+		t.Errorf("normalizeOCRText() = %q, expected %q", got, expected)
+	}
+}
+
+func TestResolveOCR(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	t.Setenv("SSBNK_OCR", "true")
+	if resolveOCR() {
+		t.Error("OCR enabled without tesseract")
+	}
+
+	installFakeTool(t, "tesseract", "exit 0\n")
+	t.Setenv("SSBNK_OCR", "auto")
+	if !resolveOCR() {
+		t.Error("OCR not enabled in auto mode with tesseract available")
+	}
+	t.Setenv("SSBNK_OCR", "false")
+	if resolveOCR() {
+		t.Error("OCR enabled despite SSBNK_OCR=false")
+	}
+}
+
+func TestScheduleOCRStoresText(t *testing.T) {
+	installFakeTool(t, "tesseract", "printf 'panic: nil map\\n\\n'\n")
+
+	config, _ := createTestConfig(t)
+	config.OCREnabled = true
+
+	hostedPath := filepath.Join(config.DataDir, "hosted", "shot.png")
+	os.WriteFile(hostedPath, []byte("png"), 0644)
+	metadata := ScreenshotMetadata{ID: "ocr-id", Filename: "shot.png", Timestamp: time.Now()}
+	config.metadataRepo().Save(metadata)
+
+	scheduleOCR(config, metadata)
+
+	if !waitFor(t, 5*time.Second, func() bool {
+		m, _, _ := config.metadataRepo().Get("ocr-id")
+		return m.OCRText == "panic: nil map"
+	}) {
+		m, _, _ := config.metadataRepo().Get("ocr-id")
+		t.Errorf("OCR text not stored, got %q", m.OCRText)
+	}
+}
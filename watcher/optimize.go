@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Optimization modes, chosen per source with SSBNK_OPTIMIZE_WATCH and
+// SSBNK_OPTIMIZE_UPLOAD. Every mode but off losslessly recompresses PNGs
+// and strips their text and EXIF chunks; webp and avif additionally try
+// converting with cwebp or avifenc. Whichever encoding is smallest wins.
+const (
+	optimizeOff  = "off"
+	optimizePNG  = "png"
+	optimizeWebP = "webp"
+	optimizeAVIF = "avif"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks carry text, EXIF and timestamps but don't affect pixels
+var pngMetadataChunks = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+// pngColorChunks affect how pixels are displayed and survive recompression
+var pngColorChunks = map[string]bool{"iCCP": true, "sRGB": true, "gAMA": true, "cHRM": true, "cICP": true, "pHYs": true}
+
+// parseOptimizeMode reads an optimization mode from the environment
+func parseOptimizeMode(key string) string {
+	switch mode := strings.ToLower(getEnv(key, optimizeOff)); mode {
+	case optimizeOff, optimizePNG, optimizeWebP, optimizeAVIF:
+		return mode
+	default:
+		log.Printf("Warning: Invalid %s=%q, optimization disabled", key, mode)
+		return optimizeOff
+	}
+}
+
+// optimizeEncoder is the external tool a mode converts with, if any
+func optimizeEncoder(mode string) string {
+	switch mode {
+	case optimizeWebP:
+		return "cwebp"
+	case optimizeAVIF:
+		return "avifenc"
+	}
+	return ""
+}
+
+// optimizeContent runs the optimization step on an image headed for the
+// hosted storage. It returns the content to store, its extension and
+// whether it differs from the original; failures fall back to the original.
+func optimizeContent(mode string, content io.ReadSeeker, ext string) (io.ReadSeeker, string, bool) {
+	data, optimizedExt, ok, err := optimizeImage(mode, content)
+	if err != nil {
+		log.Printf("Warning: Optimization failed, hosting the original: %v", err)
+	}
+	if !ok {
+		return content, ext, false
+	}
+	return bytes.NewReader(data), optimizedExt, true
+}
+
+// recordOptimization notes the pre-optimization size of a stored file
+func recordOptimization(metadata *ScreenshotMetadata, originalSize int64) {
+	metadata.OriginalSize = originalSize
+	log.Printf("🗜️  Optimized %s: %s -> %s", metadata.Filename, formatBytes(originalSize), formatBytes(metadata.Size))
+}
+
+// optimizeImage re-encodes a PNG for mode and returns the smallest result
+// and its extension. ok is false when the content should be hosted as is:
+// optimization is off, the content isn't a PNG or nothing came out smaller.
+func optimizeImage(mode string, content io.ReadSeeker) (data []byte, ext string, ok bool, err error) {
+	if mode == optimizeOff || mode == "" {
+		return nil, "", false, nil
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, "", false, err
+	}
+	original, err := io.ReadAll(content)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if !bytes.HasPrefix(original, pngSignature) {
+		return nil, "", false, nil
+	}
+
+	best, err := stripPNGMetadata(original)
+	if err != nil {
+		return nil, "", false, err
+	}
+	ext = ".png"
+	recompressed, err := recompressPNG(original)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if len(recompressed) < len(best) {
+		best = recompressed
+	}
+
+	if encoder := optimizeEncoder(mode); encoder != "" {
+		converted, err := convertImage(mode, original)
+		switch {
+		case err != nil:
+			log.Printf("Warning: %s conversion failed, keeping PNG: %v", encoder, err)
+		case len(converted) < len(best):
+			best, ext = converted, "."+mode
+		}
+	}
+
+	if len(best) >= len(original) {
+		return nil, "", false, nil
+	}
+	return best, ext, true, nil
+}
+
+// pngChunk is one raw chunk of a PNG stream, including length and CRC
+type pngChunk struct {
+	Type string
+	Raw  []byte
+}
+
+// splitPNG breaks a PNG into its chunks
+func splitPNG(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("not a PNG")
+	}
+	var chunks []pngChunk
+	for rest := data[len(pngSignature):]; len(rest) > 0; {
+		if len(rest) < 12 {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		length := binary.BigEndian.Uint32(rest[:4])
+		if uint64(length) > uint64(len(rest)-12) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		size := int(length) + 12
+		chunks = append(chunks, pngChunk{Type: string(rest[4:8]), Raw: rest[:size]})
+		rest = rest[size:]
+	}
+	return chunks, nil
+}
+
+func joinPNG(chunks []pngChunk) []byte {
+	data := append([]byte(nil), pngSignature...)
+	for _, chunk := range chunks {
+		data = append(data, chunk.Raw...)
+	}
+	return data
+}
+
+// stripPNGMetadata drops the text, EXIF and timestamp chunks of a PNG,
+// leaving the pixel data untouched
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	chunks, err := splitPNG(data)
+	if err != nil {
+		return nil, err
+	}
+	kept := chunks[:0:0]
+	for _, chunk := range chunks {
+		if !pngMetadataChunks[chunk.Type] {
+			kept = append(kept, chunk)
+		}
+	}
+	return joinPNG(kept), nil
+}
+
+// recompressPNG re-encodes a PNG at the best compression level. The
+// encoder writes no color chunks, so the original's are carried over
+// right after IHDR, where the format wants them.
+func recompressPNG(data []byte) ([]byte, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PNG: %w", err)
+	}
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+
+	original, err := splitPNG(data)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := splitPNG(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	chunks := []pngChunk{encoded[0]}
+	for _, chunk := range original {
+		if pngColorChunks[chunk.Type] {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return joinPNG(append(chunks, encoded[1:]...)), nil
+}
+
+// convertImage losslessly converts a PNG with the external encoder of mode,
+// keeping the color profile but no EXIF or XMP
+func convertImage(mode string, data []byte) ([]byte, error) {
+	encoder := optimizeEncoder(mode)
+	if _, err := exec.LookPath(encoder); err != nil {
+		return nil, fmt.Errorf("%s is not installed", encoder)
+	}
+
+	dir, err := os.MkdirTemp("", "ssbnk-optimize-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input.png")
+	output := filepath.Join(dir, "output."+mode)
+	if err := os.WriteFile(input, data, 0600); err != nil {
+		return nil, err
+	}
+
+	var args []string
+	switch mode {
+	case optimizeWebP:
+		args = []string{"-quiet", "-lossless", "-metadata", "icc", input, "-o", output}
+	case optimizeAVIF:
+		args = []string{"--lossless", "--ignore-exif", "--ignore-xmp", input, output}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, encoder, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s timed out", encoder)
+		}
+		return nil, fmt.Errorf("%s error: %w\nOutput: %s", encoder, err, stderr.String())
+	}
+	return os.ReadFile(output)
+}
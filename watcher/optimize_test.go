@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withPNGChunk inserts a chunk right after IHDR
+func withPNGChunk(t *testing.T, data []byte, typ string, payload []byte) []byte {
+	t.Helper()
+	chunks, err := splitPNG(data)
+	if err != nil {
+		t.Fatalf("splitPNG failed: %v", err)
+	}
+	raw := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(raw[:4], uint32(len(payload)))
+	copy(raw[4:8], typ)
+	raw = append(raw, payload...)
+	raw = binary.BigEndian.AppendUint32(raw, crc32.ChecksumIEEE(raw[4:]))
+	return joinPNG(append([]pngChunk{chunks[0], {Type: typ, Raw: raw}}, chunks[1:]...))
+}
+
+// testScreenshotPNG encodes a screenshot-like image with a text comment
+// and a gamma chunk, at the fastest compression level
+func testScreenshotPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x / 8 * 32), G: uint8(y / 8 * 32), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.NoCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	data := withPNGChunk(t, buf.Bytes(), "tEXt", []byte("Comment\x00captured on alice-laptop"))
+	return withPNGChunk(t, data, "gAMA", []byte{0, 0, 0xb1, 0x8f})
+}
+
+func chunkTypes(t *testing.T, data []byte) []string {
+	t.Helper()
+	chunks, err := splitPNG(data)
+	if err != nil {
+		t.Fatalf("splitPNG failed: %v", err)
+	}
+	var types []string
+	for _, chunk := range chunks {
+		types = append(types, chunk.Type)
+	}
+	return types
+}
+
+func TestParseOptimizeMode(t *testing.T) {
+	for value, expected := range map[string]string{"": "off", "PNG": "png", "webp": "webp", "avif": "avif", "gzip": "off"} {
+		t.Setenv("SSBNK_OPTIMIZE_WATCH", value)
+		if got := parseOptimizeMode("SSBNK_OPTIMIZE_WATCH"); got != expected {
+			t.Errorf("parseOptimizeMode(%q) = %q, expected %q", value, got, expected)
+		}
+	}
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	stripped, err := stripPNGMetadata(testScreenshotPNG(t))
+	if err != nil {
+		t.Fatalf("stripPNGMetadata failed: %v", err)
+	}
+	if got := strings.Join(chunkTypes(t, stripped), ","); got != "IHDR,gAMA,IDAT,IEND" {
+		t.Errorf("Unexpected chunks %s", got)
+	}
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("Stripped PNG doesn't decode: %v", err)
+	}
+}
+
+func TestOptimizeImagePNG(t *testing.T) {
+	original := testScreenshotPNG(t)
+
+	data, ext, ok, err := optimizeImage(optimizePNG, bytes.NewReader(original))
+	if err != nil || !ok {
+		t.Fatalf("optimizeImage = %v, %v", ok, err)
+	}
+	if ext != ".png" || len(data) >= len(original) {
+		t.Errorf("Expected a smaller PNG, got %s of %d bytes (was %d)", ext, len(data), len(original))
+	}
+	if bytes.Contains(data, []byte("alice-laptop")) {
+		t.Error("Text chunk survived optimization")
+	}
+	if types := chunkTypes(t, data); types[1] != "gAMA" {
+		t.Errorf("Color chunk not kept after IHDR: %v", types)
+	}
+
+	// Lossless: every pixel matches
+	before, _ := png.Decode(bytes.NewReader(original))
+	after, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Optimized PNG doesn't decode: %v", err)
+	}
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if before.At(x, y) != after.At(x, y) {
+				t.Fatalf("Pixel (%d,%d) changed", x, y)
+			}
+		}
+	}
+
+	for _, mode := range []string{optimizeOff, ""} {
+		if _, _, ok, _ := optimizeImage(mode, bytes.NewReader(original)); ok {
+			t.Errorf("Optimized with mode %q", mode)
+		}
+	}
+	if _, _, ok, _ := optimizeImage(optimizePNG, strings.NewReader("\xff\xd8\xff jpeg")); ok {
+		t.Error("Optimized a non-PNG")
+	}
+}
+
+func TestOptimizeImageConverts(t *testing.T) {
+	original := testScreenshotPNG(t)
+
+	t.Run("WithEncoder", func(t *testing.T) {
+		installFakeTool(t, "cwebp", `for arg; do out="$arg"; done; printf 'RIFF0000WEBP' > "$out"`+"\n")
+		data, ext, ok, err := optimizeImage(optimizeWebP, bytes.NewReader(original))
+		if err != nil || !ok || ext != ".webp" || string(data) != "RIFF0000WEBP" {
+			t.Errorf("optimizeImage = %q, %s, %v, %v", data, ext, ok, err)
+		}
+	})
+
+	t.Run("FallsBackToPNG", func(t *testing.T) {
+		t.Setenv("PATH", t.TempDir())
+		_, ext, ok, err := optimizeImage(optimizeAVIF, bytes.NewReader(original))
+		if err != nil || !ok || ext != ".png" {
+			t.Errorf("Expected PNG fallback, got %s, %v, %v", ext, ok, err)
+		}
+	})
+}
+
+func TestProcessScreenshotOptimizes(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.OptimizeWatch = optimizePNG
+	original := testScreenshotPNG(t)
+	sourcePath := filepath.Join(config.ScreenshotDir, "shot.png")
+	if err := os.WriteFile(sourcePath, original, 0644); err != nil {
+		t.Fatalf("Failed to create screenshot: %v", err)
+	}
+
+	if err := processScreenshot(sourcePath, config); err != nil {
+		t.Fatalf("processScreenshot failed: %v", err)
+	}
+
+	all := loadAllMetadata(config)
+	if len(all) != 1 {
+		t.Fatalf("Expected 1 metadata entry, got %d", len(all))
+	}
+	metadata := all[0]
+	if metadata.OriginalSize != int64(len(original)) || metadata.Size >= metadata.OriginalSize {
+		t.Errorf("Unexpected sizes: original %d, stored %d", metadata.OriginalSize, metadata.Size)
+	}
+	info, err := os.Stat(filepath.Join(config.DataDir, "hosted", metadata.Filename))
+	if err != nil || info.Size() != metadata.Size {
+		t.Errorf("Hosted file doesn't match metadata size: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+// quotaReserved is the space makeRoom promised to writes that haven't
+// finished yet, so concurrent writers can't all claim the same free space.
+// Guarded by retentionMu.
+var quotaReserved int64
+
+// hostedUsage is the total size of the files in the hosted storage,
+// including the thumbnails recorded in the metadata
+func hostedUsage(config Config) (int64, error) {
+	files, err := config.hostedStorage().List()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, file := range files {
+		total += file.Size
+	}
+	for _, metadata := range loadAllMetadata(config) {
+		total += thumbnailsSize(metadata)
+	}
+	return total, nil
+}
+
+// evictionCandidates returns the hosted screenshots that may be evicted,
+// oldest (by timestamp or restore time) first. Preserved screenshots are
+// never candidates; hosted files without metadata use their mtime.
+func evictionCandidates(config Config) []ScreenshotMetadata {
+	hosted := make(map[string]hostedFile)
+	for _, file := range listHostedFiles(config) {
+		hosted[file.Name] = file
+	}
+
+	var candidates []ScreenshotMetadata
+	for _, metadata := range loadAllMetadata(config) {
+		// A screencast's video goes with its GIF
+		if metadata.Video != nil {
+			delete(hosted, metadata.Video.Filename)
+		}
+		file, ok := hosted[metadata.Filename]
+		if !ok {
+			continue
+		}
+		delete(hosted, metadata.Filename)
+		if metadata.Preserve {
+			continue
+		}
+		metadata.Size = file.Size + thumbnailsSize(metadata) + videoSize(metadata)
+		candidates = append(candidates, metadata)
+	}
+
+	// Whatever is left has no metadata
+	for _, file := range hosted {
+		candidates = append(candidates, ScreenshotMetadata{
+			Filename:  file.Name,
+			Timestamp: file.ModTime,
+			Size:      file.Size,
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return retentionTime(candidates[i]).Before(retentionTime(candidates[j]))
+	})
+	return candidates
+}
+
+// makeRoom archives the oldest screenshots that aren't preserved until
+// incoming more bytes fit within Config.StorageQuota and returns what it
+// evicted. The space stays reserved until the caller, done writing, calls
+// release. The hosted files named in keep are never evicted. If evicting
+// everything possible still wouldn't make enough room, nothing is evicted
+// and errQuotaExceeded is returned.
+func makeRoom(config Config, incoming int64, keep ...string) (evicted []retentionItem, release func(), err error) {
+	release = func() {}
+	if config.StorageQuota <= 0 {
+		return nil, release, nil
+	}
+
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+
+	usage, err := hostedUsage(config)
+	if err != nil {
+		return nil, release, fmt.Errorf("failed to measure hosted storage: %w", err)
+	}
+	excess := usage + quotaReserved + incoming - config.StorageQuota
+	if excess <= 0 {
+		return nil, reserveQuota(incoming), nil
+	}
+
+	// Plan the evictions first so a hopeless write doesn't archive anything
+	var plan []ScreenshotMetadata
+	var freed int64
+	for _, candidate := range evictionCandidates(config) {
+		if freed >= excess {
+			break
+		}
+		if containsString(keep, candidate.Filename) {
+			continue
+		}
+		plan = append(plan, candidate)
+		freed += candidate.Size
+	}
+	if freed < excess {
+		return nil, release, fmt.Errorf("%w: %s used of %s, %s more needed", errQuotaExceeded,
+			formatBytes(usage+quotaReserved), formatBytes(config.StorageQuota), formatBytes(incoming))
+	}
+
+	archiveDir := filepath.Join(archiveRoot(config), time.Now().Format("2006-01-02"))
+	evicted = []retentionItem{}
+	for _, metadata := range plan {
+		if err := archiveScreenshot(config, metadata, archiveDir); err != nil {
+			return evicted, release, fmt.Errorf("failed to evict %s: %w", metadata.Filename, err)
+		}
+		log.Printf("🧹 Evicted %s (%s) to stay within the storage quota", metadata.Filename, formatBytes(metadata.Size))
+		evicted = append(evicted, retentionItem{
+			ID:        metadata.ID,
+			Filename:  metadata.Filename,
+			Timestamp: metadata.Timestamp,
+			Size:      metadata.Size,
+		})
+	}
+	return evicted, reserveQuota(incoming), nil
+}
+
+// reserveQuota sets size aside until the returned release is called;
+// callers hold retentionMu
+func reserveQuota(size int64) func() {
+	quotaReserved += size
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			retentionMu.Lock()
+			quotaReserved -= size
+			retentionMu.Unlock()
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createQuotaData hosts three 100-byte screenshots, oldest first, with the
+// oldest one preserved
+func createQuotaData(t *testing.T, config Config) {
+	t.Helper()
+	now := time.Now()
+	entries := []ScreenshotMetadata{
+		{ID: "oldest", Filename: "oldest.png", Timestamp: now.Add(-3 * time.Hour), Preserve: true},
+		{ID: "older", Filename: "older.png", Timestamp: now.Add(-2 * time.Hour)},
+		{ID: "newer", Filename: "newer.png", Timestamp: now.Add(-time.Hour)},
+	}
+	for _, metadata := range entries {
+		os.WriteFile(filepath.Join(config.DataDir, "hosted", metadata.Filename), []byte(strings.Repeat("x", 100)), 0644)
+		if err := config.metadataRepo().Save(metadata); err != nil {
+			t.Fatalf("Failed to save metadata: %v", err)
+		}
+	}
+}
+
+func TestMakeRoomEvictsOldestUnpreserved(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.StorageQuota = 350
+	createQuotaData(t, config)
+
+	evicted, release, err := makeRoom(config, 50)
+	if err != nil || len(evicted) != 0 {
+		t.Fatalf("Expected room without eviction, got %v, %v", evicted, err)
+	}
+	release()
+
+	evicted, release, err = makeRoom(config, 100)
+	defer release()
+	if err != nil {
+		t.Fatalf("makeRoom failed: %v", err)
+	}
+	if len(evicted) != 1 || evicted[0].Filename != "older.png" {
+		t.Fatalf("Expected older.png to be evicted, got %+v", evicted)
+	}
+	if fileExists(filepath.Join(config.DataDir, "hosted", "older.png")) {
+		t.Error("Evicted file is still hosted")
+	}
+	if _, found, _ := findArchived(config, "older"); !found {
+		t.Error("Evicted screenshot was not archived")
+	}
+	if !fileExists(filepath.Join(config.DataDir, "hosted", "oldest.png")) {
+		t.Error("Preserved screenshot was evicted")
+	}
+}
+
+func TestMakeRoomEvictsNothingWhenHopeless(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.StorageQuota = 300
+	createQuotaData(t, config)
+
+	// Only 200 bytes are evictable; the preserved file stays
+	evicted, _, err := makeRoom(config, 250)
+	if err == nil || len(evicted) != 0 {
+		t.Fatalf("Expected errQuotaExceeded without evictions, got %v, %v", evicted, err)
+	}
+	if len(loadAllMetadata(config)) != 3 {
+		t.Error("A hopeless request evicted screenshots")
+	}
+}
+
+func TestMakeRoomCountsReservations(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.StorageQuota = 400
+	createQuotaData(t, config)
+
+	// Two writes in flight can't both take the last 100 bytes
+	_, first, err := makeRoom(config, 80)
+	if err != nil {
+		t.Fatalf("makeRoom failed: %v", err)
+	}
+	evicted, second, err := makeRoom(config, 80)
+	if err != nil || len(evicted) != 1 || evicted[0].Filename != "older.png" {
+		t.Fatalf("Expected older.png evicted for the second write, got %v, %v", evicted, err)
+	}
+
+	// Once they're done, the space they reserved is the files they wrote
+	first()
+	second()
+	second()
+	if quotaReserved != 0 {
+		t.Errorf("Expected nothing reserved, got %d", quotaReserved)
+	}
+}
+
+func TestUploadQuota(t *testing.T) {
+	t.Setenv("SSBNK_UPLOAD_KEY", "upload-key")
+	config, _ := createTestConfig(t)
+	config.StorageQuota = 350
+	createQuotaData(t, config)
+
+	w := httptest.NewRecorder()
+	handleUpload(w, multipartUpload(t, map[string]string{"big.png": strings.Repeat("y", 400)}), config)
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusInsufficientStorage, w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handleUpload(w, multipartUpload(t, map[string]string{"fits.png": strings.Repeat("y", 100)}), config)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response struct {
+		Evicted []retentionItem `json:"evicted"`
+	}
+	json.NewDecoder(w.Body).Decode(&response)
+	if len(response.Evicted) != 1 || response.Evicted[0].Filename != "older.png" {
+		t.Errorf("Expected the response to report older.png as evicted, got %+v", response.Evicted)
+	}
+	if usage, _ := hostedUsage(config); usage > config.StorageQuota {
+		t.Errorf("Usage %d exceeds quota %d", usage, config.StorageQuota)
+	}
+}
+
+func TestUploadQuotaIgnoresDuplicates(t *testing.T) {
+	t.Setenv("SSBNK_UPLOAD_KEY", "upload-key")
+	config, _ := createTestConfig(t)
+	config.StorageQuota = 300
+	createQuotaData(t, config)
+	older, _, _ := config.metadataRepo().Get("older")
+	older.SHA256, _ = hashReader(strings.NewReader(strings.Repeat("x", 100)))
+	config.metadataRepo().Save(older)
+
+	upload := func(files map[string]string) map[string]interface{} {
+		w := httptest.NewRecorder()
+		handleUpload(w, multipartUpload(t, files), config)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var response map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&response)
+		return response
+	}
+
+	// Re-uploading hosted content needs no room
+	response := upload(map[string]string{"again.png": strings.Repeat("x", 100)})
+	if response["duplicate"] != true || response["filename"] != "older.png" || response["evicted"] != nil {
+		t.Errorf("Expected a duplicate of older.png without evictions, got %v", response)
+	}
+
+	// With new content alongside, the duplicate isn't the one evicted
+	response = upload(map[string]string{"again.png": strings.Repeat("x", 100), "new.png": strings.Repeat("y", 100)})
+	evicted, _ := response["evicted"].([]interface{})
+	if len(evicted) != 1 || evicted[0].(map[string]interface{})["filename"] != "newer.png" {
+		t.Errorf("Expected only newer.png evicted, got %v", response["evicted"])
+	}
+	if !hostedExists(config, "older.png") {
+		t.Error("The reused screenshot was evicted")
+	}
+	if usage, _ := hostedUsage(config); usage > config.StorageQuota {
+		t.Errorf("Usage %d exceeds quota %d", usage, config.StorageQuota)
+	}
+}
+
+func TestHostedVideosFollowTheirGIF(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.RetentionDays = 30
+	hostedDir := filepath.Join(config.DataDir, "hosted")
+	now := time.Now()
+
+	clip := ScreenshotMetadata{ID: "clip", Filename: "clip.gif", Timestamp: now.Add(-time.Hour),
+		Video: &Video{Filename: "clip.mp4", Format: videoMP4, Size: 200}}
+	os.WriteFile(filepath.Join(hostedDir, "clip.gif"), []byte(strings.Repeat("g", 100)), 0644)
+	os.WriteFile(filepath.Join(hostedDir, "clip.mp4"), []byte(strings.Repeat("v", 200)), 0644)
+	config.metadataRepo().Save(clip)
+
+	// A video whose GIF metadata is gone
+	orphan := filepath.Join(hostedDir, "orphan.mp4")
+	os.WriteFile(orphan, []byte(strings.Repeat("o", 50)), 0644)
+	old := now.AddDate(0, 0, -40)
+	os.Chtimes(orphan, old, old)
+
+	sizes := map[string]int64{}
+	for _, candidate := range evictionCandidates(config) {
+		sizes[candidate.Filename] = candidate.Size
+	}
+	if len(sizes) != 2 || sizes["clip.gif"] != 300 || sizes["orphan.mp4"] != 50 {
+		t.Errorf("Expected clip.gif with its video and orphan.mp4 as candidates, got %v", sizes)
+	}
+
+	if untracked := untrackedHostedFiles(config); len(untracked) != 1 || untracked[0].Filename != "orphan.mp4" {
+		t.Errorf("Expected only orphan.mp4 untracked, got %+v", untracked)
+	}
+
+	report := runRetention(config, now, false)
+	if len(report.Archived) != 1 || report.Archived[0].Filename != "orphan.mp4" {
+		t.Errorf("Expected only orphan.mp4 archived, got %+v", report.Archived)
+	}
+	if !hostedExists(config, "clip.mp4") {
+		t.Error("A hosted screenshot's video was archived")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// startReconcile scans the watch directories for files fsnotify never saw
+// (e.g. ones that landed while the watcher was down), once at startup and
+// then every Config.ReconcileInterval
+func startReconcile(config Config) {
+	for {
+		if found := reconcileWatchDirs(config); found > 0 {
+			log.Printf("🔁 Reconcile: found %d unprocessed files", found)
+		}
+		if config.ReconcileInterval <= 0 {
+			return
+		}
+		time.Sleep(config.ReconcileInterval)
+	}
+}
+
+// reconcileWatchDirs queues the images and recordings left in the watch
+// directories. Like fsnotify events, each is tracked until it is completely
+// written first. Files with a pending job, or whose job failed since they
+// last changed, are skipped. It returns the number of files picked up.
+func reconcileWatchDirs(config Config) int {
+	queue := config.jobQueue()
+	found := 0
+	for _, dir := range reconcileDirs(config) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			log.Printf("Warning: Failed to list %s: %v", dir, err)
+			continue
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			kind := ""
+			switch {
+			case entry.IsDir():
+				continue
+			case isImageFile(path):
+				kind = jobScreenshot
+			case isVideoFile(path):
+				kind = jobVideo
+			default:
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil || isTracked(path) || queue.Handled(kind, path, info.ModTime()) {
+				continue
+			}
+			found++
+			go trackFile(config, kind, path)
+		}
+	}
+	return found
+}
+
+// reconcileDirs lists the watched directories: the screenshot directory,
+// its per-repository folders and the screencast directory
+func reconcileDirs(config Config) []string {
+	dirs := []string{config.ScreenshotDir}
+	entries, err := os.ReadDir(config.ScreenshotDir)
+	if err != nil {
+		log.Printf("Warning: Failed to list %s: %v", config.ScreenshotDir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			dirs = append(dirs, filepath.Join(config.ScreenshotDir, entry.Name()))
+		}
+	}
+	if config.ScreencastDir != config.ScreenshotDir {
+		dirs = append(dirs, config.ScreencastDir)
+	}
+	return dirs
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReconcileWatchDirs(t *testing.T) {
+	installFakeTool(t, "ffprobe", "echo matroska,webm\n")
+	installFakeTool(t, "ffmpeg", `for arg; do out="$arg"; done
+printf 'GIF89a converted' > "$out"
+`)
+	config, _ := createTestConfig(t)
+
+	repoDir := filepath.Join(config.ScreenshotDir, "ssbnk")
+	os.MkdirAll(repoDir, 0755)
+	files := map[string]string{
+		filepath.Join(config.ScreenshotDir, "missed.png"): "missed",
+		filepath.Join(repoDir, "repo.png"):                "repo",
+		filepath.Join(config.ScreencastDir, "demo.webm"):  "recording",
+		filepath.Join(config.ScreenshotDir, "notes.txt"):  "not media",
+		filepath.Join(config.ScreenshotDir, "bad.png"):    "rejected before",
+	}
+	for path, content := range files {
+		os.WriteFile(path, []byte(content), 0644)
+	}
+
+	// bad.png failed after it was last written, so it's left alone
+	rejected := Job{
+		ID:        "rejected",
+		Kind:      jobScreenshot,
+		Status:    jobFailed,
+		Path:      filepath.Join(config.ScreenshotDir, "bad.png"),
+		Error:     "rejected",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now().Add(time.Second),
+	}
+	data, _ := json.Marshal(rejected)
+	os.MkdirAll(filepath.Join(config.DataDir, "jobs"), 0755)
+	os.WriteFile(filepath.Join(config.DataDir, "jobs", "rejected.json"), data, 0644)
+
+	if found := reconcileWatchDirs(config); found != 3 {
+		t.Fatalf("Expected 3 unprocessed files, found %d", found)
+	}
+
+	// Each file is tracked until it's stable, then processed by the queue
+	deadline := time.Now().Add(20 * time.Second)
+	for len(config.jobQueue().List(jobDone)) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 3 jobs done, got %+v", config.jobQueue().List(""))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if all := loadAllMetadata(config); len(all) != 3 {
+		t.Errorf("Expected 3 screenshots, got %d", len(all))
+	}
+
+	for path := range files {
+		name := filepath.Base(path)
+		kept := name == "notes.txt" || name == "bad.png"
+		if fileExists(path) != kept {
+			t.Errorf("%s: expected kept=%v", name, kept)
+		}
+	}
+	if found := reconcileWatchDirs(config); found != 0 {
+		t.Errorf("Expected nothing left to pick up, found %d", found)
+	}
+}
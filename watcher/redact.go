@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// redactPadding grows each blacked-out box so anti-aliased glyph edges go too
+const redactPadding = 3
+
+// errRedactionFailed marks images that weren't published because they
+// couldn't be checked for secrets
+var errRedactionFailed = errors.New("redaction failed")
+
+// Redaction records the secrets blacked out of a screenshot before it was
+// published
+type Redaction struct {
+	Patterns []string `json:"patterns"` // names of the patterns that matched
+	Regions  int      `json:"regions"`  // number of boxes painted over
+	// OriginalKept is set when the unredacted image is kept in
+	// DataDir/originals, readable only through the API
+	OriginalKept bool `json:"original_kept,omitempty"`
+}
+
+// secretPattern is a named regular expression for a kind of secret
+type secretPattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+}
+
+// builtinSecretPatterns are enabled with SSBNK_REDACT_BUILTINS (all by default)
+var builtinSecretPatterns = []secretPattern{
+	{"aws_access_key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github_token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`)},
+	{"slack_token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"secret_assignment", regexp.MustCompile(`(?i)\b(?:api[_-]?key|secret|token|password|passwd)\s*[:=]\s*\S{8,}`)},
+}
+
+// loadSecretPatterns builds the redaction patterns from the environment:
+// the built-ins named in SSBNK_REDACT_BUILTINS ("all" or "none" work too)
+// plus "name: regex" lines from SSBNK_REDACT_PATTERNS_FILE. Redaction is
+// off unless SSBNK_REDACT=true.
+func loadSecretPatterns() []secretPattern {
+	if getEnv("SSBNK_REDACT", "false") != "true" {
+		return nil
+	}
+
+	var patterns []secretPattern
+	builtins := getEnv("SSBNK_REDACT_BUILTINS", "all")
+	for _, pattern := range builtinSecretPatterns {
+		if builtins == "all" || containsString(strings.Split(builtins, ","), pattern.Name) {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	if path := os.Getenv("SSBNK_REDACT_PATTERNS_FILE"); path != "" {
+		custom, err := readSecretPatterns(path)
+		if err != nil {
+			log.Printf("Warning: Failed to read SSBNK_REDACT_PATTERNS_FILE: %v", err)
+		}
+		patterns = append(patterns, custom...)
+	}
+	return patterns
+}
+
+// readSecretPatterns parses a patterns file with one "name: regex" per
+// line; blank lines and lines starting with # are ignored
+func readSecretPatterns(path string) ([]secretPattern, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []secretPattern
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, expr, ok := strings.Cut(line, ":")
+		name, expr = strings.TrimSpace(name), strings.TrimSpace(expr)
+		re, err := regexp.Compile(expr)
+		if !ok || name == "" || expr == "" || err != nil {
+			log.Printf("Warning: Ignoring invalid redaction pattern %q", line)
+			continue
+		}
+		patterns = append(patterns, secretPattern{Name: name, Regexp: re})
+	}
+	return patterns, scanner.Err()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ocrWord is a word tesseract found, with its bounding box
+type ocrWord struct {
+	Line string // block, paragraph and line numbers
+	Text string
+	Box  image.Rectangle
+}
+
+// parseTesseractTSV reads the words of tesseract's tsv output
+func parseTesseractTSV(output string) []ocrWord {
+	var words []ocrWord
+	for _, row := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimRight(row, "\r"), "\t")
+		// level page block par line word left top width height conf text
+		if len(fields) < 12 || fields[0] != "5" || strings.TrimSpace(fields[11]) == "" {
+			continue
+		}
+		var geometry [4]int
+		valid := true
+		for i := range geometry {
+			n, err := strconv.Atoi(fields[6+i])
+			if err != nil {
+				valid = false
+				break
+			}
+			geometry[i] = n
+		}
+		if !valid {
+			continue
+		}
+		left, top, width, height := geometry[0], geometry[1], geometry[2], geometry[3]
+		words = append(words, ocrWord{
+			Line: strings.Join(fields[1:5], "."),
+			Text: fields[11],
+			Box:  image.Rect(left, top, left+width, top+height),
+		})
+	}
+	return words
+}
+
+// findSecrets matches the patterns against each OCR line and returns the
+// boxes of the words covered by a match and the names of the patterns hit
+func findSecrets(words []ocrWord, patterns []secretPattern) ([]image.Rectangle, []string) {
+	var boxes []image.Rectangle
+	hit := make(map[string]bool)
+
+	for start := 0; start < len(words); {
+		end := start
+		for end < len(words) && words[end].Line == words[start].Line {
+			end++
+		}
+		line := words[start:end]
+		start = end
+
+		// Join the line, remembering where each word starts
+		var text strings.Builder
+		offsets := make([]int, len(line))
+		for i, word := range line {
+			if i > 0 {
+				text.WriteByte(' ')
+			}
+			offsets[i] = text.Len()
+			text.WriteString(word.Text)
+		}
+
+		covered := make([]bool, len(line))
+		for _, pattern := range patterns {
+			for _, match := range pattern.Regexp.FindAllStringIndex(text.String(), -1) {
+				for i, word := range line {
+					if offsets[i] < match[1] && offsets[i]+len(word.Text) > match[0] {
+						covered[i] = true
+						hit[pattern.Name] = true
+					}
+				}
+			}
+		}
+		for i, word := range line {
+			if covered[i] {
+				boxes = append(boxes, word.Box)
+			}
+		}
+	}
+
+	var names []string
+	for name := range hit {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return boxes, names
+}
+
+// redactImage finds secrets in a PNG or JPEG with tesseract and paints
+// over them. It returns nil when there was nothing to redact. Other formats
+// (GIF, WebP, AVIF) can't be painted over and are an error.
+func redactImage(patterns []secretPattern, data []byte) ([]byte, *Redaction, error) {
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unsupported format: %v", err)
+	}
+	if format != "png" && format != "jpeg" {
+		return nil, nil, fmt.Errorf("unsupported format %q", format)
+	}
+
+	temp, err := os.CreateTemp("", "ssbnk-redact-*."+format)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	output, err := runTesseract(temp.Name(), "tsv")
+	if err != nil {
+		return nil, nil, err
+	}
+	boxes, names := findSecrets(parseTesseractTSV(output), patterns)
+	if len(boxes) == 0 {
+		return nil, nil, nil
+	}
+
+	bounds := src.Bounds()
+	img := image.NewRGBA(bounds)
+	draw.Draw(img, bounds, src, bounds.Min, draw.Src)
+	black := image.NewUniform(color.Black)
+	for _, box := range boxes {
+		box = box.Add(bounds.Min).Inset(-redactPadding).Intersect(bounds)
+		draw.Draw(img, box, black, image.Point{}, draw.Src)
+	}
+
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode redacted image: %w", err)
+	}
+	return buf.Bytes(), &Redaction{Patterns: names, Regions: len(boxes)}, nil
+}
+
+// redactContent runs redaction on an image headed for the hosted storage.
+// It returns the content to store, the redaction (nil if nothing was
+// found) and the unredacted original. With redaction on, an image that
+// can't be checked (no OCR, tesseract failing) is not published: the error
+// wraps errRedactionFailed.
+func redactContent(config Config, content io.ReadSeeker) (io.ReadSeeker, *Redaction, []byte, error) {
+	if len(config.RedactPatterns) == 0 {
+		return content, nil, nil, nil
+	}
+	if !config.OCREnabled {
+		return nil, nil, nil, fmt.Errorf("%w: OCR (tesseract) is not available", errRedactionFailed)
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %v", errRedactionFailed, err)
+	}
+	original, err := io.ReadAll(content)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %v", errRedactionFailed, err)
+	}
+
+	redacted, redaction, err := redactImage(config.RedactPatterns, original)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %v", errRedactionFailed, err)
+	}
+	if redaction == nil {
+		return bytes.NewReader(original), nil, nil, nil
+	}
+	return bytes.NewReader(redacted), redaction, original, nil
+}
+
+// recordRedaction attaches a redaction to freshly stored metadata and keeps
+// the unredacted original unless SSBNK_REDACT_ORIGINAL=discard
+func recordRedaction(config Config, metadata *ScreenshotMetadata, redaction *Redaction, original []byte) {
+	if redaction == nil {
+		return
+	}
+	if config.RedactKeepOriginal {
+		path := originalPath(config, metadata.Filename)
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		if err == nil {
+			err = os.WriteFile(path, original, 0600)
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to keep unredacted original of %s: %v", metadata.Filename, err)
+		} else {
+			redaction.OriginalKept = true
+		}
+	}
+	metadata.Redaction = redaction
+	log.Printf("🕶️  Redacted %d region(s) (%s) in %s", redaction.Regions, strings.Join(redaction.Patterns, ", "), metadata.Filename)
+}
+
+// originalPath is where the unredacted original of a hosted file is kept,
+// outside the served hosted storage
+func originalPath(config Config, filename string) string {
+	return filepath.Join(config.DataDir, "originals", filepath.Base(filename))
+}
+
+// deleteOriginal removes the unredacted original of a screenshot, if kept
+func deleteOriginal(config Config, metadata ScreenshotMetadata) {
+	if metadata.Redaction == nil || !metadata.Redaction.OriginalKept {
+		return
+	}
+	if err := os.Remove(originalPath(config, metadata.Filename)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: Failed to remove unredacted original of %s: %v", metadata.Filename, err)
+	}
+}
+
+// archiveOriginal moves the unredacted original of a screenshot into the
+// originals directory of an archive day, next to the archived image
+func archiveOriginal(config Config, metadata ScreenshotMetadata, archiveDir string) {
+	if metadata.Redaction == nil || !metadata.Redaction.OriginalKept {
+		return
+	}
+	dst := filepath.Join(archiveDir, "originals", filepath.Base(metadata.Filename))
+	err := os.MkdirAll(filepath.Dir(dst), 0700)
+	if err == nil {
+		err = moveFile(originalPath(config, metadata.Filename), dst)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: Failed to archive unredacted original of %s: %v", metadata.Filename, err)
+	}
+}
+
+// restoreOriginal moves an archived unredacted original back
+func restoreOriginal(config Config, metadata ScreenshotMetadata, archiveDir string) {
+	if metadata.Redaction == nil || !metadata.Redaction.OriginalKept {
+		return
+	}
+	src := filepath.Join(archiveDir, "originals", filepath.Base(metadata.Filename))
+	dst := originalPath(config, metadata.Filename)
+	err := os.MkdirAll(filepath.Dir(dst), 0700)
+	if err == nil {
+		err = moveFile(src, dst)
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to restore unredacted original of %s: %v", metadata.Filename, err)
+	}
+	// Drop the originals directory once it is empty
+	os.Remove(filepath.Dir(src))
+}
+
+// handleScreenshotOriginal serves the unredacted original of a screenshot
+// at GET /api/screenshots/{id}/original
+func handleScreenshotOriginal(w http.ResponseWriter, r *http.Request, config Config, metadata ScreenshotMetadata) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if metadata.Redaction == nil || !metadata.Redaction.OriginalKept {
+		http.Error(w, "No unredacted original", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.ServeFile(w, r, originalPath(config, metadata.Filename))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// repoSidecarFile, placed in a watched directory, names the repository
+// that screenshots saved in that directory belong to
+const repoSidecarFile = ".ssbnk-repo"
+
+var repoNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)?$`)
+
+// validRepoName accepts "name" or "owner/name" in GitHub's character set
+func validRepoName(name string) bool {
+	if len(name) > 200 || !repoNamePattern.MatchString(name) {
+		return false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRepoMap parses SSBNK_REPO_MAP entries of the form
+// "subdir=owner/repo,other=owner/other"
+func parseRepoMap(value string) map[string]string {
+	repoMap := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		dir, repo, ok := strings.Cut(entry, "=")
+		dir, repo = strings.TrimSpace(dir), strings.TrimSpace(repo)
+		if !ok || dir == "" || !validRepoName(repo) {
+			log.Printf("Warning: Ignoring invalid SSBNK_REPO_MAP entry %q", entry)
+			continue
+		}
+		repoMap[dir] = repo
+	}
+	return repoMap
+}
+
+// resolveRepo determines the repository for a file in a watch directory:
+// a sidecar file in its directory wins over the SSBNK_REPO_MAP entry for
+// the subdirectory of SSBNK_SCREENSHOT_DIR it was saved in.
+func resolveRepo(config Config, sourcePath string) string {
+	dir := filepath.Dir(sourcePath)
+	if data, err := os.ReadFile(filepath.Join(dir, repoSidecarFile)); err == nil {
+		if name := strings.TrimSpace(string(data)); validRepoName(name) {
+			return name
+		}
+		log.Printf("Warning: Invalid repository name in %s", filepath.Join(dir, repoSidecarFile))
+	}
+
+	rel, err := filepath.Rel(config.ScreenshotDir, dir)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	subdir := strings.Split(filepath.ToSlash(rel), "/")[0]
+	return config.RepoMap[subdir]
+}
+
+// watchSubdirectories adds the immediate subdirectories of dir to the
+// watcher so per-repository folders are picked up
+func watchSubdirectories(add func(string) error, dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Warning: Failed to list %s: %v", dir, err)
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := add(path); err != nil {
+			log.Printf("Warning: Failed to watch %s: %v", path, err)
+			continue
+		}
+		log.Printf("Watching for screenshots in %s", path)
+	}
+}
+
+// writeMarkdown renders screenshots as Markdown image snippets for PRs
+func writeMarkdown(w http.ResponseWriter, screenshots []ScreenshotMetadata) {
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	for _, metadata := range screenshots {
+		alt := metadata.Description
+		if alt == "" {
+			alt = metadata.OriginalName
+		}
+		if alt == "" {
+			alt = metadata.Filename
+		}
+		alt = strings.NewReplacer("[", "(", "]", ")", "\n", " ").Replace(alt)
+		fmt.Fprintf(w, "![%s](%s)\n", alt, metadata.URL)
+	}
+}
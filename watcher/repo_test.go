@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRepoMap(t *testing.T) {
+	repoMap := parseRepoMap("web = acme/web, api=acme/api,broken,bad=not a repo")
+	if len(repoMap) != 2 || repoMap["web"] != "acme/web" || repoMap["api"] != "acme/api" {
+		t.Errorf("Unexpected repo map: %v", repoMap)
+	}
+}
+
+func TestResolveRepo(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.RepoMap = map[string]string{"web": "acme/web"}
+
+	for _, dir := range []string{"web", "api", "plain"} {
+		os.MkdirAll(filepath.Join(config.ScreenshotDir, dir), 0755)
+	}
+	os.WriteFile(filepath.Join(config.ScreenshotDir, "api", repoSidecarFile), []byte("acme/api\n"), 0644)
+
+	tests := map[string]string{
+		filepath.Join(config.ScreenshotDir, "web", "shot.png"):   "acme/web",
+		filepath.Join(config.ScreenshotDir, "api", "shot.png"):   "acme/api",
+		filepath.Join(config.ScreenshotDir, "plain", "shot.png"): "",
+		filepath.Join(config.ScreenshotDir, "shot.png"):          "",
+	}
+	for path, expected := range tests {
+		if got := resolveRepo(config, path); got != expected {
+			t.Errorf("resolveRepo(%s) = %q, expected %q", path, got, expected)
+		}
+	}
+}
+
+func TestUploadWithRepoAndMarkdownListing(t *testing.T) {
+	t.Setenv("SSBNK_UPLOAD_KEY", "upload-key")
+	config, _ := createTestConfig(t)
+
+	upload := func(repo string) int {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("repo", repo)
+		part, _ := writer.CreateFormFile("file", "bug.png")
+		part.Write([]byte("png"))
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/upload", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("X-Upload-Key", "upload-key")
+		w := httptest.NewRecorder()
+		handleUpload(w, req, config)
+		return w.Code
+	}
+
+	if code := upload("acme/web"); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := upload("../etc"); code != http.StatusBadRequest {
+		t.Errorf("Expected invalid repo to be rejected, got %d", code)
+	}
+
+	w := httptest.NewRecorder()
+	handleAPIScreenshots(w, httptest.NewRequest("GET", "/api/screenshots?repo=acme/web&format=markdown", nil), config)
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "![bug.png](http://test.example.com/") || strings.Count(body, "\n") != 1 {
+		t.Errorf("Unexpected markdown listing: %q", body)
+	}
+}
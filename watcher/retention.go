@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// retentionMu keeps scheduled and on-demand retention runs, quota evictions
+// and archive restores from overlapping
+var retentionMu sync.Mutex
+
+// retentionItem is a screenshot selected for archiving
+type retentionItem struct {
+	ID        string    `json:"id,omitempty"`
+	Filename  string    `json:"filename"`
+	Timestamp time.Time `json:"timestamp"`
+	Size      int64     `json:"size"`
+}
+
+// retentionReport describes what a retention run archived and deleted, or
+// would have in a dry run
+type retentionReport struct {
+	DryRun          bool            `json:"dry_run"`
+	RanAt           time.Time       `json:"ran_at"`
+	Cutoff          *time.Time      `json:"cutoff,omitempty"`
+	ArchiveDir      string          `json:"archive_dir"`
+	Archived        []retentionItem `json:"archived"`
+	Preserved       []string        `json:"preserved"`
+	ExpiredArchives []string        `json:"expired_archives"`
+	Errors          []string        `json:"errors,omitempty"`
+}
+
+func archiveRoot(config Config) string {
+	return filepath.Join(config.DataDir, "archive")
+}
+
+// startRetention runs retention now and then every Config.RetentionInterval
+func startRetention(config Config) {
+	if config.RetentionInterval <= 0 {
+		log.Printf("Retention: scheduled runs disabled")
+		return
+	}
+	for {
+		report := runRetention(config, time.Now(), false)
+		log.Printf("🗄️  Retention: archived %d screenshots, kept %d preserved, deleted %d expired archives",
+			len(report.Archived), len(report.Preserved), len(report.ExpiredArchives))
+		for _, err := range report.Errors {
+			log.Printf("⚠️  Retention: %s", err)
+		}
+		time.Sleep(config.RetentionInterval)
+	}
+}
+
+// runRetention archives screenshots whose metadata timestamp (or restore
+// time) is older than Config.RetentionDays into DataDir/archive/YYYY-MM-DD (the day of the run)
+// and deletes archive days older than Config.ArchiveRetentionDays.
+// Preserved screenshots are never archived. Hosted files without metadata
+// are judged by their modification time.
+func runRetention(config Config, now time.Time, dryRun bool) retentionReport {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+
+	report := retentionReport{
+		DryRun:          dryRun,
+		RanAt:           now,
+		ArchiveDir:      filepath.Join(archiveRoot(config), now.Format("2006-01-02")),
+		Archived:        []retentionItem{},
+		Preserved:       []string{},
+		ExpiredArchives: []string{},
+	}
+
+	if config.RetentionDays > 0 {
+		cutoff := now.AddDate(0, 0, -config.RetentionDays)
+		report.Cutoff = &cutoff
+
+		tracked := make(map[string]bool)
+		for _, metadata := range loadAllMetadata(config) {
+			tracked[metadata.Filename] = true
+			if metadata.Video != nil {
+				tracked[metadata.Video.Filename] = true
+			}
+			if !retentionTime(metadata).Before(cutoff) {
+				continue
+			}
+			if metadata.Preserve {
+				report.Preserved = append(report.Preserved, metadata.Filename)
+				continue
+			}
+			archiveItem(config, &report, metadata)
+		}
+
+		for _, file := range listHostedFiles(config) {
+			if tracked[file.Name] || !file.ModTime.Before(cutoff) {
+				continue
+			}
+			archiveItem(config, &report, ScreenshotMetadata{
+				Filename:  file.Name,
+				Timestamp: file.ModTime,
+				Size:      file.Size,
+			})
+		}
+	}
+
+	if config.ArchiveRetentionDays > 0 {
+		expireArchives(config, &report, now.AddDate(0, 0, -config.ArchiveRetentionDays))
+	}
+
+	return report
+}
+
+// retentionTime is when a screenshot's retention period started: its
+// timestamp, or the time it was last restored from the archive
+func retentionTime(metadata ScreenshotMetadata) time.Time {
+	if metadata.RestoredAt != nil && metadata.RestoredAt.After(metadata.Timestamp) {
+		return *metadata.RestoredAt
+	}
+	return metadata.Timestamp
+}
+
+func archiveItem(config Config, report *retentionReport, metadata ScreenshotMetadata) {
+	if !report.DryRun {
+		if err := archiveScreenshot(config, metadata, report.ArchiveDir); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			return
+		}
+	}
+	report.Archived = append(report.Archived, retentionItem{
+		ID:        metadata.ID,
+		Filename:  metadata.Filename,
+		Timestamp: metadata.Timestamp,
+		Size:      metadata.Size,
+	})
+}
+
+// archiveScreenshot moves a hosted file and its metadata into archiveDir
+// together: the metadata is written to the archive first and only removed
+// from the repository once the file has moved, and each step is undone if
+// a later one fails. Entries without an ID only move the hosted file.
+func archiveScreenshot(config Config, metadata ScreenshotMetadata, archiveDir string) error {
+	if metadata.Filename == "" {
+		return fmt.Errorf("metadata %s has no filename", metadata.ID)
+	}
+	if err := os.MkdirAll(archiveDir, 0755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	archivedPath := filepath.Join(archiveDir, filepath.Base(metadata.Filename))
+	metadataPath := filepath.Join(archiveDir, metadata.ID+".json")
+
+	if fileExists(archivedPath) {
+		return fmt.Errorf("%s is already archived in %s", metadata.Filename, archiveDir)
+	}
+
+	if metadata.ID != "" {
+		if err := saveMetadata(metadata, metadataPath); err != nil {
+			return fmt.Errorf("failed to archive metadata for %s: %w", metadata.Filename, err)
+		}
+	}
+
+	moved := false
+	if hostedExists(config, metadata.Filename) {
+		if err := exportHosted(config, metadata.Filename, archivedPath); err != nil {
+			if metadata.ID != "" {
+				os.Remove(metadataPath)
+			}
+			return fmt.Errorf("failed to archive %s: %w", metadata.Filename, err)
+		}
+		moved = true
+	}
+
+	if metadata.ID != "" {
+		if err := config.metadataRepo().Delete(metadata.ID); err != nil {
+			if moved {
+				if restoreErr := importHosted(config, archivedPath, metadata.Filename); restoreErr != nil {
+					log.Printf("Warning: Failed to restore %s after failed archive: %v", metadata.Filename, restoreErr)
+				}
+			}
+			os.Remove(metadataPath)
+			return fmt.Errorf("failed to remove metadata for %s: %w", metadata.Filename, err)
+		}
+	}
+
+	// Thumbnails are rebuilt if the screenshot is restored
+	deleteThumbnails(config, metadata)
+	archiveOriginal(config, metadata, archiveDir)
+	archiveVideo(config, metadata, archiveDir)
+
+	log.Printf("Archived: %s", metadata.Filename)
+	return nil
+}
+
+// expireArchives deletes archive day directories dated before cutoff
+func expireArchives(config Config, report *retentionReport, cutoff time.Time) {
+	entries, err := os.ReadDir(archiveRoot(config))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to read archive directory: %v", err))
+		}
+		return
+	}
+
+	cutoffDay := cutoff.Format("2006-01-02")
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", entry.Name()); err != nil || entry.Name() >= cutoffDay {
+			continue
+		}
+		if !report.DryRun {
+			if err := os.RemoveAll(filepath.Join(archiveRoot(config), entry.Name())); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("failed to delete archive %s: %v", entry.Name(), err))
+				continue
+			}
+			log.Printf("Deleted expired archive: %s", entry.Name())
+		}
+		report.ExpiredArchives = append(report.ExpiredArchives, entry.Name())
+	}
+}
+
+// handleRetention reports what retention would do (GET) or runs it now
+// (POST) at /api/retention
+func handleRetention(w http.ResponseWriter, r *http.Request, config Config) {
+	if !authorizeAPIRequest(w, r) {
+		return
+	}
+
+	var report retentionReport
+	switch r.Method {
+	case http.MethodGet:
+		report = runRetention(config, time.Now(), true)
+	case http.MethodPost:
+		report = runRetention(config, time.Now(), false)
+		log.Printf("API: Retention archived %d screenshots", len(report.Archived))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createRetentionData(t *testing.T, config Config, now time.Time) {
+	t.Helper()
+	hostedDir := filepath.Join(config.DataDir, "hosted")
+	old := now.AddDate(0, 0, -40)
+
+	entries := []ScreenshotMetadata{
+		{ID: "old", Filename: "old.png", Timestamp: old},
+		{ID: "kept", Filename: "kept.png", Timestamp: old, Preserve: true},
+		{ID: "new", Filename: "new.png", Timestamp: now.Add(-time.Hour)},
+	}
+	for _, metadata := range entries {
+		os.WriteFile(filepath.Join(hostedDir, metadata.Filename), []byte(metadata.ID), 0644)
+		if err := config.metadataRepo().Save(metadata); err != nil {
+			t.Fatalf("Failed to save metadata: %v", err)
+		}
+	}
+
+	// The retention decision uses the metadata timestamp, not the file's mtime
+	os.Chtimes(filepath.Join(hostedDir, "new.png"), old, old)
+
+	// Untracked files fall back to their modification time
+	untracked := filepath.Join(hostedDir, "untracked.png")
+	os.WriteFile(untracked, []byte("untracked"), 0644)
+	os.Chtimes(untracked, old, old)
+
+	os.MkdirAll(filepath.Join(archiveRoot(config), "2000-01-01"), 0755)
+	os.MkdirAll(filepath.Join(archiveRoot(config), now.AddDate(0, 0, -1).Format("2006-01-02")), 0755)
+}
+
+func TestRetentionDryRun(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.RetentionDays = 30
+	config.ArchiveRetentionDays = 30
+	now := time.Now()
+	createRetentionData(t, config, now)
+
+	report := runRetention(config, now, true)
+	if len(report.Archived) != 2 || len(report.Preserved) != 1 || len(report.ExpiredArchives) != 1 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	if !fileExists(filepath.Join(config.DataDir, "hosted", "old.png")) || len(loadAllMetadata(config)) != 3 {
+		t.Error("Dry run modified the hosted directory or metadata")
+	}
+	if !fileExists(filepath.Join(archiveRoot(config), "2000-01-01")) {
+		t.Error("Dry run deleted an expired archive")
+	}
+}
+
+func TestRetentionArchivesFileAndMetadataTogether(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.RetentionDays = 30
+	config.ArchiveRetentionDays = 30
+	now := time.Now()
+	createRetentionData(t, config, now)
+
+	report := runRetention(config, now, false)
+	if len(report.Errors) > 0 {
+		t.Fatalf("Retention failed: %v", report.Errors)
+	}
+
+	archiveDir := filepath.Join(archiveRoot(config), now.Format("2006-01-02"))
+	for _, name := range []string{"old.png", "old.json", "untracked.png"} {
+		if !fileExists(filepath.Join(archiveDir, name)) {
+			t.Errorf("Expected %s in the archive", name)
+		}
+	}
+	for _, name := range []string{"old.png", "untracked.png"} {
+		if fileExists(filepath.Join(config.DataDir, "hosted", name)) {
+			t.Errorf("Expected %s to leave the hosted directory", name)
+		}
+	}
+	for _, name := range []string{"kept.png", "new.png"} {
+		if !fileExists(filepath.Join(config.DataDir, "hosted", name)) {
+			t.Errorf("Expected %s to stay hosted", name)
+		}
+	}
+
+	if _, found, _ := config.metadataRepo().Get("old"); found {
+		t.Error("Archived metadata still in the repository")
+	}
+	if len(loadAllMetadata(config)) != 2 {
+		t.Errorf("Expected 2 remaining metadata entries, got %d", len(loadAllMetadata(config)))
+	}
+
+	if fileExists(filepath.Join(archiveRoot(config), "2000-01-01")) {
+		t.Error("Expired archive was not deleted")
+	}
+	if !fileExists(filepath.Join(archiveRoot(config), now.AddDate(0, 0, -1).Format("2006-01-02"))) {
+		t.Error("Recent archive was deleted")
+	}
+}
+
+func TestRetentionDisabled(t *testing.T) {
+	config, _ := createTestConfig(t)
+	now := time.Now()
+	createRetentionData(t, config, now)
+
+	report := runRetention(config, now, false)
+	if len(report.Archived) != 0 || len(report.ExpiredArchives) != 0 || report.Cutoff != nil {
+		t.Errorf("Expected retention to be disabled, got %+v", report)
+	}
+}
+
+func TestRetentionAPI(t *testing.T) {
+	t.Setenv("SSBNK_API_KEY", "test-key")
+	config, _ := createTestConfig(t)
+	config.RetentionDays = 30
+	createRetentionData(t, config, time.Now())
+
+	w := httptest.NewRecorder()
+	handleRetention(w, httptest.NewRequest("GET", "/api/retention", nil), config)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without key, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handleRetention(w, apiRequest("GET", "/api/retention", ""), config)
+	var report retentionReport
+	json.NewDecoder(w.Body).Decode(&report)
+	if w.Code != http.StatusOK || !report.DryRun || len(report.Archived) != 2 {
+		t.Errorf("Unexpected dry run response %d: %+v", w.Code, report)
+	}
+	if !fileExists(filepath.Join(config.DataDir, "hosted", "old.png")) {
+		t.Error("GET must not archive anything")
+	}
+
+	w = httptest.NewRecorder()
+	handleRetention(w, apiRequest("POST", "/api/retention", ""), config)
+	if w.Code != http.StatusOK || fileExists(filepath.Join(config.DataDir, "hosted", "old.png")) {
+		t.Errorf("Expected POST to archive, got %d", w.Code)
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Storage keeps hosted files in an S3-compatible bucket (AWS S3, MinIO,
+// R2, Garage, ...) using path-style requests signed with AWS Signature
+// Version 4. Reads are proxied through the watcher, or redirected to
+// presigned URLs when presignTTL is set.
+type s3Storage struct {
+	endpoint   *url.URL
+	bucket     string
+	prefix     string
+	region     string
+	accessKey  string
+	secretKey  string
+	presignTTL time.Duration
+	client     *http.Client
+}
+
+func newS3StorageFromEnv() (*s3Storage, error) {
+	endpoint, err := url.Parse(getEnv("SSBNK_S3_ENDPOINT", "https://s3.amazonaws.com"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid SSBNK_S3_ENDPOINT %q", os.Getenv("SSBNK_S3_ENDPOINT"))
+	}
+	storage := &s3Storage{
+		endpoint:   endpoint,
+		bucket:     os.Getenv("SSBNK_S3_BUCKET"),
+		prefix:     os.Getenv("SSBNK_S3_PREFIX"),
+		region:     getEnv("SSBNK_S3_REGION", "us-east-1"),
+		accessKey:  getEnv("SSBNK_S3_ACCESS_KEY", os.Getenv("AWS_ACCESS_KEY_ID")),
+		secretKey:  getEnv("SSBNK_S3_SECRET_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		presignTTL: getEnvDuration("SSBNK_S3_PRESIGN_TTL", 0),
+		client:     &http.Client{Timeout: 5 * time.Minute},
+	}
+	if storage.bucket == "" {
+		return nil, errors.New("SSBNK_S3_BUCKET is required for the s3 storage backend")
+	}
+	if storage.accessKey == "" || storage.secretKey == "" {
+		return nil, errors.New("SSBNK_S3_ACCESS_KEY and SSBNK_S3_SECRET_KEY are required for the s3 storage backend")
+	}
+	log.Printf("S3 storage: %s/%s/%s", endpoint, storage.bucket, storage.prefix)
+	return storage, nil
+}
+
+// objectURL is the path-style URL of a hosted file
+func (s *s3Storage) objectURL(name string) *url.URL {
+	return s.pathURL("/" + s.bucket + "/" + s.prefix + strings.TrimPrefix(path.Clean("/"+name), "/"))
+}
+
+func (s *s3Storage) pathURL(p string) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(s.endpoint.Path, "/") + p
+	u.RawPath = s3EscapePath(u.Path)
+	return &u
+}
+
+// do signs and sends a request for name, mapping 404 to os.ErrNotExist
+func (s *s3Storage) do(req *http.Request, payloadHash string, name string) (*http.Response, error) {
+	s.sign(req, payloadHash, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s: %w", req.Method, name, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed && req.Method == http.MethodPut {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", name, os.ErrExist)
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", req.Method, name, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (s *s3Storage) Create(name string, content io.ReadSeeker) (int64, error) {
+	// If-None-Match below makes the write conditional on stores that
+	// support it; the HEAD covers the ones that don't
+	if _, err := s.Stat(name); err == nil {
+		return 0, fmt.Errorf("%s: %w", name, os.ErrExist)
+	}
+
+	// The payload hash is part of the signature, so read the content twice
+	start, err := content.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	payloadHash, err := hashReader(content)
+	if err != nil {
+		return 0, err
+	}
+	end, err := content.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := content.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	size := end - start
+
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(name).String(), io.NopCloser(content))
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = size
+	req.Header.Set("If-None-Match", "*")
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req, payloadHash, name)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return size, nil
+}
+
+func (s *s3Storage) Open(name string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(name).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, emptyPayloadHash, name)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Storage) Stat(name string) (hostedFile, error) {
+	req, err := http.NewRequest(http.MethodHead, s.objectURL(name).String(), nil)
+	if err != nil {
+		return hostedFile{}, err
+	}
+	resp, err := s.do(req, emptyPayloadHash, name)
+	if err != nil {
+		return hostedFile{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return hostedFile{Name: filepath.Base(name), ModTime: modTime, Size: resp.ContentLength}, nil
+}
+
+// s3ListResult is the part of a ListObjectsV2 response we use
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *s3Storage) List() ([]hostedFile, error) {
+	var files []hostedFile
+	token := ""
+	for {
+		u := s.pathURL("/" + s.bucket)
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}, "delimiter": {"/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = query.Encode()
+
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req, emptyPayloadHash, s.bucket)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bucket listing: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(object.Key, s.prefix)
+			if name == "" || strings.Contains(name, "/") {
+				continue
+			}
+			files = append(files, hostedFile{Name: name, ModTime: object.LastModified, Size: object.Size})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return files, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3Storage) Delete(name string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(name).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyPayloadHash, name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3ProxiedHeaders are copied between the client and the bucket when reads
+// are proxied, so ranges and conditional requests keep working
+var (
+	s3ProxiedRequestHeaders  = []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"}
+	s3ProxiedResponseHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"}
+)
+
+func (s *s3Storage) ServeFile(w http.ResponseWriter, r *http.Request, name string) {
+	if s.presignTTL > 0 {
+		// The presigned URL dies with presignTTL, so the redirect to it
+		// mustn't be cached like the file itself
+		w.Header().Set("Cache-Control", "private, no-store")
+		http.Redirect(w, r, s.presignURL(http.MethodGet, s.objectURL(name), s.presignTTL, time.Now()), http.StatusFound)
+		return
+	}
+
+	method := http.MethodGet
+	if r.Method == http.MethodHead {
+		method = http.MethodHead
+	}
+	req, err := http.NewRequest(method, s.objectURL(name).String(), nil)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	for _, header := range s3ProxiedRequestHeaders {
+		if value := r.Header.Get(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+
+	s.sign(req, emptyPayloadHash, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		log.Printf("Error reading %s from S3: %v", name, err)
+		http.Error(w, "Failed to read file", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	// Client errors such as 304 and 416 pass through; the bucket refusing
+	// our credentials or failing is a gateway error
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		http.NotFound(w, r)
+		return
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode >= 500:
+		log.Printf("Error reading %s from S3: %s", name, resp.Status)
+		http.Error(w, "Failed to read file", http.StatusBadGateway)
+		return
+	}
+
+	for _, header := range s3ProxiedResponseHeaders {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// sign adds an AWS Signature Version 4 Authorization header covering the
+// host and every header already set on req
+func (s *s3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	scope := s.scope(now)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	signature := s.signature(now, amzDate, scope, canonicalRequest)
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// presignURL returns u with query parameters that authorize method on it
+// for ttl without further credentials
+func (s *s3Storage) presignURL(method string, u *url.URL, ttl time.Duration, now time.Time) string {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := s.scope(now)
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.accessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(ttl.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		s3CanonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	query.Set("X-Amz-Signature", s.signature(now, amzDate, scope, canonicalRequest))
+
+	signed := *u
+	signed.RawQuery = s3CanonicalQuery(query)
+	return signed.String()
+}
+
+func (s *s3Storage) scope(now time.Time) string {
+	return now.UTC().Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+func (s *s3Storage) signature(now time.Time, amzDate, scope, canonicalRequest string) string {
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.UTC().Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, as
+// Signature Version 4 requires
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// errInvalidImage is returned for uploads whose content can't be processed
+var errInvalidImage = errors.New("invalid image")
+
+// EXIF tags that identify the device or its owner, by IFD
+var (
+	exifDeviceTags = map[uint16]bool{
+		0x010F: true, // Make
+		0x0110: true, // Model
+		0x0131: true, // Software
+		0x013B: true, // Artist
+		0x013C: true, // HostComputer
+	}
+	exifPrivateDeviceTags = map[uint16]bool{
+		0x927C: true, // MakerNote
+		0xA420: true, // ImageUniqueID
+		0xA430: true, // CameraOwnerName
+		0xA431: true, // BodySerialNumber
+		0xA433: true, // LensMake
+		0xA434: true, // LensModel
+		0xA435: true, // LensSerialNumber
+	}
+)
+
+const (
+	exifIFDPointer = 0x8769
+	gpsIFDPointer  = 0x8825
+)
+
+var (
+	exifHeader        = []byte("Exif\x00\x00")
+	xmpHeader         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtendedHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+)
+
+// sanitizeImage strips location and device identifiers from a JPEG, PNG or
+// WebP: the GPS and device fields of its EXIF are blanked in place (keeping
+// orientation and the like) and XMP and IPTC blocks, which repeat them, are
+// dropped. It returns the cleaned image and what was removed ("gps",
+// "device", "xmp", "iptc", "exif"). Other formats are returned unchanged.
+func sanitizeImage(data []byte) ([]byte, []string, error) {
+	removed := make(map[string]bool)
+	var clean []byte
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		clean, err = sanitizeJPEG(data, removed)
+	case bytes.HasPrefix(data, pngSignature):
+		clean, err = sanitizePNG(data, removed)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		clean, err = sanitizeWebP(data, removed)
+	default:
+		return data, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+
+	var kinds []string
+	for kind := range removed {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return clean, kinds, nil
+}
+
+// sanitizeUpload reads an uploaded image and returns its sanitized content
+func sanitizeUpload(content io.ReadSeeker) (io.ReadSeeker, []string, error) {
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, nil, err
+	}
+	clean, removed, err := sanitizeImage(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bytes.NewReader(clean), removed, nil
+}
+
+func sanitizeJPEG(data []byte, removed map[string]bool) ([]byte, error) {
+	clean := append([]byte(nil), data[:2]...)
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, fmt.Errorf("JPEG marker expected at offset %d", i)
+		}
+		// Markers may be padded with any number of 0xFF bytes
+		start := i
+		for i < len(data) && data[i] == 0xFF {
+			i++
+		}
+		if i >= len(data) {
+			return nil, errors.New("truncated JPEG")
+		}
+		marker := data[i]
+		i++
+
+		switch {
+		case marker == 0xDA || marker == 0xD9:
+			// Start of scan or end of image: no metadata follows
+			return append(clean, data[start:]...), nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			clean = append(clean, data[start:i]...)
+			continue
+		}
+
+		if i+2 > len(data) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 || i+length > len(data) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		segment := data[start : i+length]
+		payload := data[i+2 : i+length]
+		i += length
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, exifHeader):
+			segment = append([]byte(nil), segment...)
+			tiff := segment[len(segment)-len(payload)+len(exifHeader):]
+			if err := scrubEXIF(tiff, removed); err != nil {
+				return nil, err
+			}
+		case marker == 0xE1 && (bytes.HasPrefix(payload, xmpHeader) || bytes.HasPrefix(payload, xmpExtendedHeader)):
+			removed["xmp"] = true
+			continue
+		case marker == 0xED:
+			// Photoshop resources carry the IPTC location fields
+			removed["iptc"] = true
+			continue
+		}
+		clean = append(clean, segment...)
+	}
+	return nil, errors.New("JPEG has no image data")
+}
+
+func sanitizePNG(data []byte, removed map[string]bool) ([]byte, error) {
+	chunks, err := splitPNG(data)
+	if err != nil {
+		return nil, err
+	}
+	kept := chunks[:0:0]
+	for _, chunk := range chunks {
+		payload := chunk.Raw[8 : len(chunk.Raw)-4]
+		switch chunk.Type {
+		case "eXIf":
+			raw := append([]byte(nil), chunk.Raw...)
+			if err := scrubEXIF(raw[8:len(raw)-4], removed); err != nil {
+				return nil, err
+			}
+			binary.BigEndian.PutUint32(raw[len(raw)-4:], crc32.ChecksumIEEE(raw[4:len(raw)-4]))
+			chunk.Raw = raw
+		case "iTXt", "tEXt", "zTXt":
+			keyword, _, _ := bytes.Cut(payload, []byte{0})
+			// Adobe XMP, and the hex-encoded profiles ImageMagick writes
+			switch string(keyword) {
+			case "XML:com.adobe.xmp", "Raw profile type xmp":
+				removed["xmp"] = true
+				continue
+			case "Raw profile type exif", "Raw profile type APP1":
+				removed["exif"] = true
+				continue
+			case "Raw profile type iptc":
+				removed["iptc"] = true
+				continue
+			}
+		}
+		kept = append(kept, chunk)
+	}
+	return joinPNG(kept), nil
+}
+
+func sanitizeWebP(data []byte, removed map[string]bool) ([]byte, error) {
+	clean := append([]byte(nil), data[:12]...)
+	dropped := false
+	for rest := data[12:]; len(rest) > 0; {
+		if len(rest) < 8 {
+			return nil, errors.New("truncated WebP chunk")
+		}
+		size := uint64(binary.LittleEndian.Uint32(rest[4:8]))
+		padded := size + size%2
+		if 8+size > uint64(len(rest)) {
+			return nil, errors.New("truncated WebP chunk")
+		}
+		if 8+padded > uint64(len(rest)) {
+			padded = size
+		}
+		chunk := rest[:8+padded]
+		rest = rest[8+padded:]
+
+		switch string(chunk[:4]) {
+		case "EXIF":
+			chunk = append([]byte(nil), chunk...)
+			tiff := chunk[8 : 8+size]
+			// Some writers keep the JPEG-style prefix
+			tiff = bytes.TrimPrefix(tiff, exifHeader)
+			if err := scrubEXIF(tiff, removed); err != nil {
+				return nil, err
+			}
+		case "XMP ":
+			removed["xmp"] = true
+			dropped = true
+			continue
+		}
+		clean = append(clean, chunk...)
+	}
+
+	if dropped && string(clean[12:16]) == "VP8X" && len(clean) > 20 {
+		clean[20] &^= 0x04 // XMP flag
+	}
+	binary.LittleEndian.PutUint32(clean[4:8], uint32(len(clean)-8))
+	return clean, nil
+}
+
+// tiffReader reads the IFDs of an EXIF block with its byte order
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func (t tiffReader) uint16At(offset uint32) (uint16, error) {
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return 0, errors.New("EXIF offset out of range")
+	}
+	return t.order.Uint16(t.data[offset:]), nil
+}
+
+func (t tiffReader) uint32At(offset uint32) (uint32, error) {
+	if uint64(offset)+4 > uint64(len(t.data)) {
+		return 0, errors.New("EXIF offset out of range")
+	}
+	return t.order.Uint32(t.data[offset:]), nil
+}
+
+// exifTypeSizes is the size in bytes of each TIFF field type
+var exifTypeSizes = map[uint16]uint64{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4}
+
+// valueBytes returns the bytes holding the value of the IFD entry at offset
+func (t tiffReader) valueBytes(entry uint32) ([]byte, error) {
+	typ, _ := t.uint16At(entry + 2)
+	count, err := t.uint32At(entry + 4)
+	if err != nil {
+		return nil, err
+	}
+	size := exifTypeSizes[typ] * uint64(count)
+	if size <= 4 {
+		return t.data[entry+8 : uint64(entry)+8+size], nil
+	}
+	offset, _ := t.uint32At(entry + 8)
+	if uint64(offset)+size > uint64(len(t.data)) {
+		return nil, errors.New("EXIF value out of range")
+	}
+	return t.data[offset : uint64(offset)+size], nil
+}
+
+// scrubEXIF blanks the GPS and device fields of a TIFF-structured EXIF
+// block in place. The layout is left intact so the remaining fields, such
+// as orientation, stay readable.
+func scrubEXIF(data []byte, removed map[string]bool) error {
+	if len(data) < 8 {
+		return errors.New("EXIF block too short")
+	}
+	var t tiffReader
+	switch string(data[:4]) {
+	case "II*\x00":
+		t = tiffReader{data: data, order: binary.LittleEndian}
+	case "MM\x00*":
+		t = tiffReader{data: data, order: binary.BigEndian}
+	default:
+		return errors.New("invalid EXIF header")
+	}
+
+	visited := make(map[uint32]bool)
+	var walk func(offset uint32, tags map[uint16]bool, chain bool) error
+	walk = func(offset uint32, tags map[uint16]bool, chain bool) error {
+		for offset != 0 && !visited[offset] {
+			visited[offset] = true
+			count, err := t.uint16At(offset)
+			if err != nil {
+				return err
+			}
+			if uint64(offset)+2+12*uint64(count)+4 > uint64(len(data)) {
+				return errors.New("EXIF directory out of range")
+			}
+			for n := uint32(0); n < uint32(count); n++ {
+				entry := offset + 2 + 12*n
+				tag, _ := t.uint16At(entry)
+				switch {
+				case tag == gpsIFDPointer:
+					pointer, _ := t.uint32At(entry + 8)
+					if err := blankIFD(t, pointer, removed); err != nil {
+						return err
+					}
+				case tag == exifIFDPointer:
+					pointer, _ := t.uint32At(entry + 8)
+					if err := walk(pointer, exifPrivateDeviceTags, false); err != nil {
+						return err
+					}
+				case tags[tag]:
+					value, err := t.valueBytes(entry)
+					if err != nil {
+						return err
+					}
+					if !isZero(value) {
+						clear(value)
+						removed["device"] = true
+					}
+				}
+			}
+			if !chain {
+				return nil
+			}
+			// IFD1 describes the embedded thumbnail
+			offset, _ = t.uint32At(offset + 2 + 12*uint32(count))
+		}
+		return nil
+	}
+	return walk(t.order.Uint32(data[4:]), exifDeviceTags, true)
+}
+
+// blankIFD zeroes every entry and value of the GPS directory and marks it
+// empty, leaving the pointer to it valid
+func blankIFD(t tiffReader, offset uint32, removed map[string]bool) error {
+	count, err := t.uint16At(offset)
+	if err != nil {
+		return err
+	}
+	if uint64(offset)+2+12*uint64(count) > uint64(len(t.data)) {
+		return errors.New("GPS directory out of range")
+	}
+	for n := uint32(0); n < uint32(count); n++ {
+		entry := offset + 2 + 12*n
+		if value, err := t.valueBytes(entry); err == nil {
+			clear(value)
+		}
+		clear(t.data[entry : entry+12])
+	}
+	if count > 0 {
+		t.order.PutUint16(t.data[offset:], 0)
+		removed["gps"] = true
+	}
+	return nil
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
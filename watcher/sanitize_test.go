@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testEXIF builds a little-endian EXIF block with a camera make, an
+// orientation, a body serial number and a GPS latitude
+func testEXIF() []byte {
+	data := make([]byte, 148)
+	le := binary.LittleEndian
+	copy(data, "II*\x00")
+	le.PutUint32(data[4:], 8)
+
+	entry := func(offset int, tag, typ uint16, count, value uint32) {
+		le.PutUint16(data[offset:], tag)
+		le.PutUint16(data[offset+2:], typ)
+		le.PutUint32(data[offset+4:], count)
+		le.PutUint32(data[offset+8:], value)
+	}
+
+	// IFD0 at 8
+	le.PutUint16(data[8:], 4)
+	entry(10, 0x010F, 2, 6, 110) // Make
+	entry(22, 0x0112, 3, 1, 6)   // Orientation
+	entry(34, exifIFDPointer, 4, 1, 62)
+	entry(46, gpsIFDPointer, 4, 1, 80)
+
+	// Exif IFD at 62
+	le.PutUint16(data[62:], 1)
+	entry(64, 0xA431, 2, 8, 116) // BodySerialNumber
+
+	// GPS IFD at 80
+	le.PutUint16(data[80:], 2)
+	entry(82, 0x0001, 2, 2, 0) // GPSLatitudeRef, inline
+	copy(data[90:], "N\x00")
+	entry(94, 0x0002, 5, 3, 124) // GPSLatitude
+
+	copy(data[110:], "Apple\x00")
+	copy(data[116:], "SN12345\x00")
+	for i, v := range []uint32{48, 1, 51, 1, 2400, 100} {
+		le.PutUint32(data[124+4*i:], v)
+	}
+	return data
+}
+
+// checkScrubbedEXIF verifies the EXIF block lost its identifiers but kept
+// its orientation
+func checkScrubbedEXIF(t *testing.T, data []byte) {
+	t.Helper()
+	exif := bytes.Index(data, []byte("II*\x00"))
+	if exif < 0 {
+		t.Fatal("EXIF block missing")
+	}
+	tiff := data[exif:]
+	for _, leaked := range []string{"Apple", "SN12345"} {
+		if bytes.Contains(data, []byte(leaked)) {
+			t.Errorf("%s survived sanitizing", leaked)
+		}
+	}
+	if orientation := binary.LittleEndian.Uint16(tiff[30:]); orientation != 6 {
+		t.Errorf("Orientation = %d, expected 6", orientation)
+	}
+	if entries := binary.LittleEndian.Uint16(tiff[80:]); entries != 0 {
+		t.Errorf("GPS directory still has %d entries", entries)
+	}
+	if !bytes.Equal(tiff[124:148], make([]byte, 24)) {
+		t.Error("GPS latitude not blanked")
+	}
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func TestSanitizeJPEG(t *testing.T) {
+	var data []byte
+	data = append(data, 0xFF, 0xD8)
+	data = append(data, jpegSegment(0xE0, []byte("JFIF\x00\x01\x02"))...)
+	data = append(data, jpegSegment(0xE1, append([]byte("Exif\x00\x00"), testEXIF()...))...)
+	data = append(data, jpegSegment(0xE1, append(append([]byte(nil), xmpHeader...), "<x:xmpmeta/>"...))...)
+	data = append(data, jpegSegment(0xED, []byte("Photoshop 3.0\x00IPTC"))...)
+	data = append(data, jpegSegment(0xDA, []byte{1, 2, 3})...)
+	data = append(data, 0xAB, 0xCD, 0xFF, 0xD9)
+
+	clean, removed, err := sanitizeImage(data)
+	if err != nil {
+		t.Fatalf("sanitizeImage failed: %v", err)
+	}
+	if expected := []string{"device", "gps", "iptc", "xmp"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Removed %v, expected %v", removed, expected)
+	}
+	checkScrubbedEXIF(t, clean)
+	if bytes.Contains(clean, []byte("xmpmeta")) || bytes.Contains(clean, []byte("IPTC")) {
+		t.Error("XMP or IPTC survived sanitizing")
+	}
+	if !bytes.Contains(clean, []byte("JFIF")) || !bytes.HasSuffix(clean, []byte{0xAB, 0xCD, 0xFF, 0xD9}) {
+		t.Error("Image data was not kept")
+	}
+
+	// Sanitizing again finds nothing left to remove
+	if _, removed, _ := sanitizeImage(clean); len(removed) != 0 {
+		t.Errorf("Second pass removed %v", removed)
+	}
+
+	truncated := append([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x10, 0x00}, "Exif"...)
+	if _, _, err := sanitizeImage(truncated); !errors.Is(err, errInvalidImage) {
+		t.Errorf("Expected errInvalidImage for a truncated JPEG, got %v", err)
+	}
+}
+
+func TestSanitizePNG(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4)))
+	data := withPNGChunk(t, buf.Bytes(), "eXIf", testEXIF())
+	data = withPNGChunk(t, data, "iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"))
+
+	clean, removed, err := sanitizeImage(data)
+	if err != nil {
+		t.Fatalf("sanitizeImage failed: %v", err)
+	}
+	if expected := []string{"device", "gps", "xmp"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Removed %v, expected %v", removed, expected)
+	}
+	checkScrubbedEXIF(t, clean)
+	// The decoder verifies the rewritten chunk's CRC
+	if _, err := png.Decode(bytes.NewReader(clean)); err != nil {
+		t.Errorf("Sanitized PNG doesn't decode: %v", err)
+	}
+}
+
+func TestSanitizeWebP(t *testing.T) {
+	chunk := func(fourcc string, payload []byte) []byte {
+		data := append([]byte(fourcc), 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(data[4:], uint32(len(payload)))
+		data = append(data, payload...)
+		if len(payload)%2 == 1 {
+			data = append(data, 0)
+		}
+		return data
+	}
+	var body []byte
+	body = append(body, chunk("VP8X", []byte{0x0C, 0, 0, 0, 3, 0, 0, 3, 0, 0})...)
+	body = append(body, chunk("VP8L", []byte{0x2F, 1, 2})...)
+	body = append(body, chunk("EXIF", testEXIF())...)
+	body = append(body, chunk("XMP ", []byte("<x:xmpmeta/>!"))...)
+	data := append([]byte("RIFF\x00\x00\x00\x00WEBP"), body...)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+
+	clean, removed, err := sanitizeImage(data)
+	if err != nil {
+		t.Fatalf("sanitizeImage failed: %v", err)
+	}
+	if expected := []string{"device", "gps", "xmp"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Removed %v, expected %v", removed, expected)
+	}
+	checkScrubbedEXIF(t, clean)
+	if bytes.Contains(clean, []byte("xmpmeta")) {
+		t.Error("XMP survived sanitizing")
+	}
+	if size := binary.LittleEndian.Uint32(clean[4:]); int(size) != len(clean)-8 {
+		t.Errorf("RIFF size %d doesn't match %d bytes", size, len(clean)-8)
+	}
+	if flags := clean[20]; flags != 0x08 {
+		t.Errorf("VP8X flags = %#x, expected only EXIF", flags)
+	}
+}
+
+func TestUploadStripsMetadata(t *testing.T) {
+	t.Setenv("SSBNK_UPLOAD_KEY", "upload-key")
+	config, _ := createTestConfig(t)
+	config.StripUploadMetadata = true
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4)))
+	upload := withPNGChunk(t, buf.Bytes(), "eXIf", testEXIF())
+
+	w := httptest.NewRecorder()
+	handleUpload(w, multipartUpload(t, map[string]string{"phone.png": string(upload)}), config)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response struct {
+		Filename string `json:"filename"`
+	}
+	json.NewDecoder(w.Body).Decode(&response)
+
+	hosted, err := os.ReadFile(filepath.Join(config.DataDir, "hosted", response.Filename))
+	if err != nil {
+		t.Fatalf("Failed to read hosted file: %v", err)
+	}
+	checkScrubbedEXIF(t, hosted)
+	metadata, _, _ := config.metadataRepo().GetByFilename(response.Filename)
+	if !reflect.DeepEqual(metadata.Sanitized, []string{"device", "gps"}) {
+		t.Errorf("Unexpected sanitized record %v", metadata.Sanitized)
+	}
+
+	// Unparseable metadata is rejected rather than published
+	w = httptest.NewRecorder()
+	handleUpload(w, multipartUpload(t, map[string]string{"broken.jpg": "\xff\xd8\xff\xe1\x10\x00Exif"}), config)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// screenshotPatch lists the fields PATCH /api/screenshots/{id} may change.
+// Nil fields are left untouched.
+type screenshotPatch struct {
+	Description *string   `json:"description"`
+	Preserve    *bool     `json:"preserve"`
+	Tags        *[]string `json:"tags"`
+	Private     *bool     `json:"private"`
+}
+
+// handleAPIScreenshot serves GET, PATCH and DELETE for a single screenshot
+// at /api/screenshots/{id}, signed links at /api/screenshots/{id}/sign and
+// the unredacted original at /api/screenshots/{id}/original. The ID may
+// also be the hosted filename.
+func handleAPIScreenshot(w http.ResponseWriter, r *http.Request, config Config) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/screenshots/")
+	id, sign := strings.CutSuffix(id, "/sign")
+	id, original := strings.CutSuffix(id, "/original")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if !authorizeAPIRequest(w, r) {
+		return
+	}
+
+	metadata, found, err := lookupScreenshot(config, id)
+	if err != nil {
+		log.Printf("API: Failed to look up %s: %v", id, err)
+		http.Error(w, "Failed to read metadata", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Screenshot not found", http.StatusNotFound)
+		return
+	}
+
+	if sign {
+		handleSignScreenshot(w, r, config, metadata)
+		return
+	}
+	if original {
+		handleScreenshotOriginal(w, r, config, metadata)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeScreenshot(w, metadata)
+	case http.MethodPatch:
+		handlePatchScreenshot(w, r, config, metadata)
+	case http.MethodDelete:
+		if err := deleteScreenshot(config, metadata); err != nil {
+			log.Printf("API: Failed to delete %s: %v", metadata.ID, err)
+			http.Error(w, "Failed to delete screenshot", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("API: Deleted %s (%s)", metadata.Filename, metadata.ID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// lookupScreenshot resolves an ID, falling back to a hosted filename
+func lookupScreenshot(config Config, id string) (ScreenshotMetadata, bool, error) {
+	repo := config.metadataRepo()
+	metadata, found, err := repo.Get(id)
+	if err != nil || found {
+		return metadata, found, err
+	}
+	return repo.GetByFilename(id)
+}
+
+func handlePatchScreenshot(w http.ResponseWriter, r *http.Request, config Config, metadata ScreenshotMetadata) {
+	var patch screenshotPatch
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Apply to the current entry so background jobs' fields aren't lost
+	found, err := updateMetadata(config, metadata.ID, func(current *ScreenshotMetadata) {
+		if patch.Description != nil {
+			current.Description = strings.TrimSpace(*patch.Description)
+		}
+		if patch.Preserve != nil {
+			current.Preserve = *patch.Preserve
+		}
+		if patch.Tags != nil {
+			current.Tags = normalizeTags(*patch.Tags)
+		}
+		if patch.Private != nil {
+			current.Private = *patch.Private
+		}
+		metadata = *current
+	})
+	if err != nil {
+		log.Printf("API: Failed to save metadata for %s: %v", metadata.ID, err)
+		http.Error(w, "Failed to save metadata", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Screenshot not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("API: Updated %s (%s)", metadata.Filename, metadata.ID)
+	writeScreenshot(w, metadata)
+}
+
+// normalizeTags trims tags and drops empty and duplicate entries
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result
+}
+
+// deleteScreenshot removes the hosted file and its metadata together. The
+// metadata goes first and is put back if the file can't be deleted.
+func deleteScreenshot(config Config, metadata ScreenshotMetadata) error {
+	if err := config.metadataRepo().Delete(metadata.ID); err != nil {
+		return fmt.Errorf("failed to delete metadata: %w", err)
+	}
+
+	if metadata.Filename != "" {
+		if err := config.hostedStorage().Delete(metadata.Filename); err != nil {
+			if restoreErr := config.metadataRepo().Save(metadata); restoreErr != nil {
+				log.Printf("Warning: Failed to restore metadata for %s after failed delete: %v", metadata.Filename, restoreErr)
+			}
+			return fmt.Errorf("failed to remove hosted file: %w", err)
+		}
+	}
+	deleteThumbnails(config, metadata)
+	deleteOriginal(config, metadata)
+	deleteVideo(config, metadata)
+	return nil
+}
+
+func writeScreenshot(w http.ResponseWriter, metadata ScreenshotMetadata) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metadata)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func apiRequest(method, path, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-API-Key", "test-key")
+	return req
+}
+
+func TestScreenshotAPI(t *testing.T) {
+	t.Setenv("SSBNK_API_KEY", "test-key")
+	config, _ := createTestConfig(t)
+	createTestData(t, config)
+
+	t.Run("RequiresKey", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/screenshots/test-id-0", nil)
+		w := httptest.NewRecorder()
+		handleAPIScreenshot(w, req, config)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+		}
+	})
+
+	t.Run("GetByIDAndFilename", func(t *testing.T) {
+		for _, key := range []string{"test-id-1", "20240101-1300.png"} {
+			w := httptest.NewRecorder()
+			handleAPIScreenshot(w, apiRequest("GET", "/api/screenshots/"+key, ""), config)
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET %s: expected status %d, got %d", key, http.StatusOK, w.Code)
+			}
+			var metadata ScreenshotMetadata
+			json.NewDecoder(w.Body).Decode(&metadata)
+			if metadata.ID != "test-id-1" {
+				t.Errorf("GET %s returned %s", key, metadata.ID)
+			}
+		}
+	})
+
+	t.Run("Patch", func(t *testing.T) {
+		body := `{"description": " panic trace ", "preserve": true, "tags": ["bug", "bug", " ui "]}`
+		w := httptest.NewRecorder()
+		handleAPIScreenshot(w, apiRequest("PATCH", "/api/screenshots/test-id-0", body), config)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		metadata, _, _ := config.metadataRepo().Get("test-id-0")
+		if metadata.Description != "panic trace" || !metadata.Preserve {
+			t.Errorf("Patch not applied: %+v", metadata)
+		}
+		if len(metadata.Tags) != 2 || metadata.Tags[0] != "bug" || metadata.Tags[1] != "ui" {
+			t.Errorf("Tags not normalized: %v", metadata.Tags)
+		}
+
+		w = httptest.NewRecorder()
+		handleAPIScreenshot(w, apiRequest("PATCH", "/api/screenshots/test-id-0", `{"filename": "x"}`), config)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected unknown field to be rejected, got %d", w.Code)
+		}
+	})
+
+	t.Run("PatchKeepsBackgroundUpdates", func(t *testing.T) {
+		// OCR finishes between the handler's lookup and the patch
+		stale, _, _ := config.metadataRepo().Get("test-id-1")
+		updateMetadata(config, "test-id-1", func(current *ScreenshotMetadata) {
+			current.OCRText = "recognized"
+		})
+
+		w := httptest.NewRecorder()
+		handlePatchScreenshot(w, apiRequest("PATCH", "/api/screenshots/test-id-1", `{"description": "late"}`), config, stale)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		metadata, _, _ := config.metadataRepo().Get("test-id-1")
+		if metadata.Description != "late" || metadata.OCRText != "recognized" {
+			t.Errorf("Expected both the patch and the OCR text, got %+v", metadata)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handleAPIScreenshot(w, apiRequest("DELETE", "/api/screenshots/test-id-2", ""), config)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+		}
+
+		if fileExists(filepath.Join(config.DataDir, "hosted", "20240101-1400.gif")) {
+			t.Error("Hosted file still exists after delete")
+		}
+		if _, found, _ := config.metadataRepo().Get("test-id-2"); found {
+			t.Error("Metadata still exists after delete")
+		}
+		if issues := checkMetadataConsistency(config); len(issues) != 0 {
+			t.Errorf("Delete left inconsistencies: %v", issues)
+		}
+
+		w = httptest.NewRecorder()
+		handleAPIScreenshot(w, apiRequest("DELETE", "/api/screenshots/test-id-2", ""), config)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// screenshotQuery holds the filters and ordering accepted by /api/screenshots.
+// Zero values mean "no filter".
+type screenshotQuery struct {
+	From        time.Time
+	To          time.Time
+	Text        string // q: any of filename, original name, description, tags, OCR text
+	Name        string // original name substring
+	Description string
+	Repo        string
+	BatchID     string
+	Preserve    *bool
+	MediaTypes  map[string]bool
+	MinSize     int64
+	MaxSize     int64
+	SortBy      string
+	Ascending   bool
+}
+
+// screenshotSorts maps the sort parameter to a "less" function in ascending order
+var screenshotSorts = map[string]func(a, b ScreenshotMetadata) bool{
+	"timestamp": func(a, b ScreenshotMetadata) bool { return a.Timestamp.Before(b.Timestamp) },
+	"size":      func(a, b ScreenshotMetadata) bool { return a.Size < b.Size },
+	"filename":  func(a, b ScreenshotMetadata) bool { return a.Filename < b.Filename },
+	"name": func(a, b ScreenshotMetadata) bool {
+		return strings.ToLower(a.OriginalName) < strings.ToLower(b.OriginalName)
+	},
+}
+
+// parseScreenshotQuery reads the search parameters from a request query
+func parseScreenshotQuery(values url.Values) (screenshotQuery, error) {
+	query := screenshotQuery{
+		Text:        strings.ToLower(strings.TrimSpace(values.Get("q"))),
+		Name:        strings.ToLower(strings.TrimSpace(values.Get("name"))),
+		Description: strings.ToLower(strings.TrimSpace(values.Get("description"))),
+		Repo:        values.Get("repo"),
+		BatchID:     values.Get("batch"),
+		SortBy:      "timestamp",
+	}
+
+	var err error
+	if val := values.Get("from"); val != "" {
+		if query.From, err = parseQueryTime(val, false); err != nil {
+			return query, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if val := values.Get("to"); val != "" {
+		if query.To, err = parseQueryTime(val, true); err != nil {
+			return query, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+
+	if val := values.Get("preserve"); val != "" {
+		preserve, err := strconv.ParseBool(val)
+		if err != nil {
+			return query, fmt.Errorf("invalid preserve: %q", val)
+		}
+		query.Preserve = &preserve
+	}
+
+	if val := values.Get("type"); val != "" {
+		query.MediaTypes = make(map[string]bool)
+		for _, mediaType := range strings.Split(strings.ToLower(val), ",") {
+			mediaType = strings.TrimPrefix(strings.TrimSpace(mediaType), ".")
+			if mediaType == "jpeg" {
+				mediaType = "jpg"
+			}
+			if !isImageFile("x." + mediaType) {
+				return query, fmt.Errorf("invalid type: %q", mediaType)
+			}
+			query.MediaTypes[mediaType] = true
+		}
+	}
+
+	if val := values.Get("min_size"); val != "" {
+		if query.MinSize, err = parseByteSize(val); err != nil {
+			return query, fmt.Errorf("invalid min_size: %w", err)
+		}
+	}
+	if val := values.Get("max_size"); val != "" {
+		if query.MaxSize, err = parseByteSize(val); err != nil {
+			return query, fmt.Errorf("invalid max_size: %w", err)
+		}
+	}
+
+	if val := values.Get("sort"); val != "" {
+		if _, ok := screenshotSorts[val]; !ok {
+			return query, fmt.Errorf("invalid sort: %q", val)
+		}
+		query.SortBy = val
+	}
+	switch order := values.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		return query, fmt.Errorf("invalid order: %q", order)
+	}
+
+	return query, nil
+}
+
+// parseQueryTime accepts RFC 3339 timestamps or plain dates. A plain date
+// used as an upper bound covers the whole day.
+func parseQueryTime(val string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", val, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD or RFC 3339, got %q", val)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// mediaType returns the normalized extension of a hosted filename
+func mediaType(filename string) string {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	if ext == "jpeg" {
+		return "jpg"
+	}
+	return ext
+}
+
+func (q screenshotQuery) matches(m ScreenshotMetadata) bool {
+	if !q.From.IsZero() && m.Timestamp.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && m.Timestamp.After(q.To) {
+		return false
+	}
+	if q.Name != "" && !strings.Contains(strings.ToLower(m.OriginalName), q.Name) {
+		return false
+	}
+	if q.Description != "" && !strings.Contains(strings.ToLower(m.Description), q.Description) {
+		return false
+	}
+	if q.Repo != "" && m.RepoName != q.Repo {
+		return false
+	}
+	if q.BatchID != "" && m.BatchID != q.BatchID {
+		return false
+	}
+	if q.Preserve != nil && m.Preserve != *q.Preserve {
+		return false
+	}
+	if q.MediaTypes != nil && !q.MediaTypes[mediaType(m.Filename)] {
+		return false
+	}
+	if q.MinSize > 0 && m.Size < q.MinSize {
+		return false
+	}
+	if q.MaxSize > 0 && m.Size > q.MaxSize {
+		return false
+	}
+	if q.Text != "" && !strings.Contains(searchableText(m), q.Text) {
+		return false
+	}
+	return true
+}
+
+// searchableText is the lowercased text matched by the q parameter
+func searchableText(m ScreenshotMetadata) string {
+	parts := []string{m.Filename, m.OriginalName, m.Description, m.OCRText}
+	parts = append(parts, m.Tags...)
+	return strings.ToLower(strings.Join(parts, "\n"))
+}
+
+// apply filters and sorts entries, returning a new slice
+func (q screenshotQuery) apply(entries []ScreenshotMetadata) []ScreenshotMetadata {
+	result := []ScreenshotMetadata{}
+	for _, m := range entries {
+		if q.matches(m) {
+			result = append(result, m)
+		}
+	}
+
+	less := screenshotSorts[q.SortBy]
+	sort.SliceStable(result, func(i, j int) bool {
+		if q.Ascending {
+			return less(result[i], result[j])
+		}
+		return less(result[j], result[i])
+	})
+	return result
+}
+
+// pageScreenshots returns the window of entries from repo matching filter,
+// merged with extra (already filtered), and the total number of matches.
+// The default newest-first order pages through the repository index; other
+// orders sort every match.
+func pageScreenshots(repo MetadataRepository, q screenshotQuery, filter func(ScreenshotMetadata) bool, extra []ScreenshotMetadata, offset, limit int) ([]ScreenshotMetadata, int, error) {
+	if q.SortBy != "timestamp" || q.Ascending {
+		matches, _, err := repo.Page(0, 0, filter)
+		if err != nil {
+			return nil, 0, err
+		}
+		all := q.apply(append(matches, extra...))
+		return paginateMetadata(all, offset, limit), len(all), nil
+	}
+
+	// Each extra entry shifts the repository entries back by at most one,
+	// so the window's repository entries start at most len(extra) earlier
+	sortMetadataByTimestamp(extra)
+	start := max(offset-len(extra), 0)
+	fetch := offset + limit - start
+	if limit <= 0 {
+		fetch = 0
+	}
+	page, total, err := repo.Page(start, fetch, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	total += len(extra)
+
+	// Extras newer than the fetched page lie before the window
+	if start > 0 && len(page) > 0 {
+		skipped := 0
+		for skipped < len(extra) && extra[skipped].Timestamp.After(page[0].Timestamp) {
+			skipped++
+		}
+		extra = extra[skipped:]
+		start += skipped
+	}
+
+	merged := make([]ScreenshotMetadata, 0, len(page)+len(extra))
+	for len(page) > 0 || len(extra) > 0 {
+		if len(extra) == 0 || (len(page) > 0 && !extra[0].Timestamp.After(page[0].Timestamp)) {
+			merged, page = append(merged, page[0]), page[1:]
+		} else {
+			merged, extra = append(merged, extra[0]), extra[1:]
+		}
+	}
+	return paginateMetadata(merged, offset-start, limit), total, nil
+}
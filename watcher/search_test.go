@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func searchFixtures() []ScreenshotMetadata {
+	base := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+	return []ScreenshotMetadata{
+		{ID: "a", Filename: "a.png", OriginalName: "Screenshot_terminal.png", Description: "panic in handler", Timestamp: base, Size: 1000, RepoName: "delorenj/ssbnk", Tags: []string{"bug"}},
+		{ID: "b", Filename: "b.gif", OriginalName: "recording.webm", Timestamp: base.AddDate(0, 0, 1), Size: 500000, BatchID: "batch-1", Preserve: true},
+		{ID: "c", Filename: "c.webp", OriginalName: "phone.webp", Description: "login page", Timestamp: base.AddDate(0, 0, 2), Size: 20000, BatchID: "batch-1"},
+		{ID: "d", Filename: "d.jpeg", OriginalName: "photo.jpeg", Timestamp: base.AddDate(0, 0, 3), Size: 3000, RepoName: "delorenj/other"},
+	}
+}
+
+func TestScreenshotQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{"d", "c", "b", "a"}},
+		{"from=2024-03-11&to=2024-03-12", []string{"c", "b"}},
+		{"to=2024-03-10", []string{"a"}},
+		{"name=TERMINAL", []string{"a"}},
+		{"description=login", []string{"c"}},
+		{"q=panic", []string{"a"}},
+		{"q=bug", []string{"a"}},
+		{"repo=delorenj/ssbnk", []string{"a"}},
+		{"batch=batch-1", []string{"c", "b"}},
+		{"preserve=true", []string{"b"}},
+		{"preserve=false", []string{"d", "c", "a"}},
+		{"type=gif,webp", []string{"c", "b"}},
+		{"type=jpg", []string{"d"}},
+		{"min_size=2KB&max_size=100KB", []string{"d", "c"}},
+		{"sort=size", []string{"b", "c", "d", "a"}},
+		{"sort=size&order=asc", []string{"a", "d", "c", "b"}},
+		{"sort=name&order=asc", []string{"c", "d", "b", "a"}},
+		{"sort=timestamp&order=asc", []string{"a", "b", "c", "d"}},
+	}
+
+	for _, test := range tests {
+		values, _ := url.ParseQuery(test.query)
+		query, err := parseScreenshotQuery(values)
+		if err != nil {
+			t.Errorf("parseScreenshotQuery(%q) failed: %v", test.query, err)
+			continue
+		}
+
+		var ids []string
+		for _, m := range query.apply(searchFixtures()) {
+			ids = append(ids, m.ID)
+		}
+		if len(ids) != len(test.expected) {
+			t.Errorf("%q: got %v, expected %v", test.query, ids, test.expected)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != test.expected[i] {
+				t.Errorf("%q: got %v, expected %v", test.query, ids, test.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestPageScreenshotsMergesExtras(t *testing.T) {
+	config, _ := createTestConfig(t)
+	repo := config.metadataRepo()
+	base := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	at := func(hour int) ScreenshotMetadata {
+		return ScreenshotMetadata{ID: fmt.Sprintf("h%d", hour), Filename: fmt.Sprintf("h%d.png", hour), Timestamp: base.Add(time.Duration(hour) * time.Hour)}
+	}
+	for _, hour := range []int{2, 4, 6, 8, 10} {
+		repo.Save(at(hour))
+	}
+	extras := []ScreenshotMetadata{at(1), at(5), at(9), at(11)}
+
+	query, _ := parseScreenshotQuery(url.Values{})
+	all, _ := repo.List()
+	expected := query.apply(append(all, extras...))
+
+	ids := func(entries []ScreenshotMetadata) []string {
+		result := []string{}
+		for _, m := range entries {
+			result = append(result, m.ID)
+		}
+		return result
+	}
+	for offset := 0; offset <= len(expected)+1; offset++ {
+		for limit := 1; limit <= 4; limit++ {
+			page, total, err := pageScreenshots(repo, query, nil, append([]ScreenshotMetadata(nil), extras...), offset, limit)
+			if err != nil {
+				t.Fatalf("pageScreenshots failed: %v", err)
+			}
+			want := ids(paginateMetadata(expected, offset, limit))
+			if total != len(expected) || !reflect.DeepEqual(ids(page), want) {
+				t.Errorf("pageScreenshots(%d, %d) = %v of %d, expected %v of %d", offset, limit, ids(page), total, want, len(expected))
+			}
+		}
+	}
+}
+
+func TestScreenshotQueryRejectsInvalidParameters(t *testing.T) {
+	for _, query := range []string{"from=yesterday", "preserve=maybe", "type=exe", "min_size=big", "sort=color", "order=up"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := parseScreenshotQuery(values); err == nil {
+			t.Errorf("Expected %q to be rejected", query)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{
+		"1024":  1024,
+		"512KB": 512 << 10,
+		"2mb":   2 << 20,
+		"1.5GB": 3 << 29,
+		"10 B":  10,
+	}
+	for input, expected := range tests {
+		if got, err := parseByteSize(input); err != nil || got != expected {
+			t.Errorf("parseByteSize(%q) = %d, %v; expected %d", input, got, err, expected)
+		}
+	}
+	if _, err := parseByteSize("-1"); err == nil {
+		t.Error("Expected negative size to be rejected")
+	}
+}
+
+func TestAPIScreenshotsSearch(t *testing.T) {
+	config, _ := createTestConfig(t)
+	createTestData(t, config)
+
+	req := httptest.NewRequest("GET", "/api/screenshots?type=gif", nil)
+	w := httptest.NewRecorder()
+	handleAPIScreenshots(w, req, config)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response struct {
+		Screenshots []ScreenshotMetadata `json:"screenshots"`
+		Total       int                  `json:"total"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.Total != 1 || response.Screenshots[0].Filename != "20240101-1400.gif" {
+		t.Errorf("Unexpected search result: %+v", response)
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIScreenshots(w, httptest.NewRequest("GET", "/api/screenshots?sort=bogus", nil), config)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// HostedStorage holds the files published under BaseURL. The default is the
+// local DataDir/hosted directory; s3Storage keeps them in an S3-compatible
+// bucket so the bank can live off the host.
+type HostedStorage interface {
+	// Create stores content under name and returns the number of bytes
+	// written. The error matches os.ErrExist if name is already taken.
+	Create(name string, content io.ReadSeeker) (int64, error)
+	// Open returns the content of name. The error matches os.ErrNotExist
+	// if it isn't stored.
+	Open(name string) (io.ReadCloser, error)
+	Stat(name string) (hostedFile, error)
+	// List returns every stored file, in no particular order
+	List() ([]hostedFile, error)
+	// Delete removes name; deleting a missing file is not an error
+	Delete(name string) error
+	// ServeFile answers an HTTP request for name
+	ServeFile(w http.ResponseWriter, r *http.Request, name string)
+}
+
+// localPather is implemented by storages that keep files on the local
+// filesystem, letting callers rename them instead of copying.
+type localPather interface {
+	LocalPath(name string) string
+}
+
+// hostedStorage returns the configured storage, defaulting to the local
+// DataDir/hosted directory.
+func (c Config) hostedStorage() HostedStorage {
+	if c.Storage != nil {
+		return c.Storage
+	}
+	return newLocalStorage(filepath.Join(c.DataDir, "hosted"))
+}
+
+// openHostedStorage creates the storage selected by SSBNK_STORAGE_BACKEND
+func openHostedStorage(config Config) (HostedStorage, error) {
+	switch backend := getEnv("SSBNK_STORAGE_BACKEND", "local"); backend {
+	case "local":
+		return newLocalStorage(filepath.Join(config.DataDir, "hosted")), nil
+	case "s3":
+		return newS3StorageFromEnv()
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// localStorage keeps hosted files in a directory
+type localStorage struct {
+	dir string
+}
+
+func newLocalStorage(dir string) *localStorage {
+	return &localStorage{dir: dir}
+}
+
+// LocalPath maps a storage name, which may include a subdirectory such as
+// thumbs/, to a path that can't escape dir
+func (s *localStorage) LocalPath(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (s *localStorage) Create(name string, content io.ReadSeeker) (int64, error) {
+	path := s.LocalPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return written, nil
+}
+
+func (s *localStorage) Open(name string) (io.ReadCloser, error) {
+	return os.Open(s.LocalPath(name))
+}
+
+func (s *localStorage) Stat(name string) (hostedFile, error) {
+	info, err := os.Stat(s.LocalPath(name))
+	if err != nil {
+		return hostedFile{}, err
+	}
+	return hostedFile{Name: filepath.Base(name), ModTime: info.ModTime(), Size: info.Size()}, nil
+}
+
+func (s *localStorage) List() ([]hostedFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []hostedFile
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, hostedFile{Name: entry.Name(), ModTime: info.ModTime(), Size: info.Size()})
+	}
+	return files, nil
+}
+
+func (s *localStorage) Delete(name string) error {
+	if err := os.Remove(s.LocalPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStorage) ServeFile(w http.ResponseWriter, r *http.Request, name string) {
+	http.ServeFile(w, r, s.LocalPath(name))
+}
+
+// hostedExists reports whether name is in the hosted storage
+func hostedExists(config Config, name string) bool {
+	_, err := config.hostedStorage().Stat(name)
+	return err == nil
+}
+
+// createUnique stores content as base+ext (or base-1+ext, base-2+ext, ...
+// if the name is taken) and returns the name used and its size
+func createUnique(storage HostedStorage, base, ext string, content io.ReadSeeker) (string, int64, error) {
+	name := base + ext
+	for counter := 1; counter <= 1000; counter++ {
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return "", 0, fmt.Errorf("failed to rewind content: %w", err)
+		}
+		written, err := storage.Create(name, content)
+		if err == nil {
+			return name, written, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", 0, fmt.Errorf("failed to store %s: %w", name, err)
+		}
+		name = fmt.Sprintf("%s-%d%s", base, counter, ext)
+	}
+	return "", 0, fmt.Errorf("no free filename for %s%s", base, ext)
+}
+
+// storeHostedFile stores content under a new name rendered from the
+// configured filename template
+func storeHostedFile(config Config, vars filenameVars, ext string, content io.ReadSeeker) (string, int64, error) {
+	base := renderFilename(config.FilenameTemplate, vars)
+	return createUnique(config.hostedStorage(), base, ext, content)
+}
+
+// exportHosted moves a hosted file out of the storage to a local path
+func exportHosted(config Config, name, dst string) error {
+	storage := config.hostedStorage()
+	if local, ok := storage.(localPather); ok {
+		return moveFile(local.LocalPath(name), dst)
+	}
+
+	content, err := storage.Open(name)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	if err := storage.Delete(name); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// importHosted moves a local file into the storage under name. The error
+// matches os.ErrExist if name is already taken.
+func importHosted(config Config, src, name string) error {
+	storage := config.hostedStorage()
+	if local, ok := storage.(localPather); ok {
+		if hostedExists(config, name) {
+			return fmt.Errorf("%s: %w", name, os.ErrExist)
+		}
+		return moveFile(src, local.LocalPath(name))
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	_, err = storage.Create(name, file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(src); err != nil {
+		log.Printf("Warning: Failed to remove %s: %v", src, err)
+	}
+	return nil
+}
+
+// hostedLocalCopy returns a local path with the content of a hosted file for
+// tools that need one (tesseract). cleanup removes any temporary copy.
+func hostedLocalCopy(config Config, name string) (string, func(), error) {
+	storage := config.hostedStorage()
+	if local, ok := storage.(localPather); ok {
+		return local.LocalPath(name), func() {}, nil
+	}
+
+	content, err := storage.Open(name)
+	if err != nil {
+		return "", nil, err
+	}
+	defer content.Close()
+	temp, err := os.CreateTemp("", "ssbnk-*"+filepath.Ext(name))
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(temp.Name()) }
+	_, err = io.Copy(temp, content)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return temp.Name(), cleanup, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// thumbnailDir is the storage prefix thumbnails live under. It keeps them
+// next to the originals but out of hosted listings, and serveHostedFile
+// refuses direct requests for it so thumbnails inherit the access checks
+// of their original.
+const thumbnailDir = "thumbs"
+
+// defaultThumbnailWidths are generated unless SSBNK_THUMBNAIL_WIDTHS says otherwise
+const defaultThumbnailWidths = "256,768"
+
+// thumbnailSlots bounds the number of images decoded at once
+var thumbnailSlots = make(chan struct{}, 2)
+
+// Thumbnail is a downscaled copy of a screenshot
+type Thumbnail struct {
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Size     int64  `json:"size"`
+	Filename string `json:"filename"` // storage name under thumbs/
+	URL      string `json:"url"`
+}
+
+// parseThumbnailWidths parses a comma-separated list of widths such as
+// "256,768" into ascending order. "0" (or any list without a positive
+// width) disables thumbnails.
+func parseThumbnailWidths(value string) []int {
+	seen := make(map[int]bool)
+	var widths []int
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		width, err := strconv.Atoi(entry)
+		if err != nil || width < 0 {
+			log.Printf("Warning: Ignoring invalid SSBNK_THUMBNAIL_WIDTHS entry %q", entry)
+			continue
+		}
+		if width > 0 && !seen[width] {
+			seen[width] = true
+			widths = append(widths, width)
+		}
+	}
+	sort.Ints(widths)
+	return widths
+}
+
+// canThumbnail reports whether thumbnails are generated for a hosted file.
+// GIFs are skipped since a still frame would lose the animation, and WebP
+// and AVIF (including PNGs optimized into them) have no decoder in the
+// standard library, so they are always served at full size.
+func canThumbnail(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".png", ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
+
+// thumbnailName is the storage name of the width-wide thumbnail of
+// filename. The full filename is kept so a.jpg and a.jpeg don't share one.
+func thumbnailName(filename string, width int, ext string) string {
+	return fmt.Sprintf("%s/%s-%d%s", thumbnailDir, filename, width, ext)
+}
+
+// generateThumbnails stores a downscaled copy of a hosted image for every
+// configured width narrower than the image. Thumbnails of JPEGs are JPEGs,
+// everything else becomes PNG.
+func generateThumbnails(config Config, metadata ScreenshotMetadata) ([]Thumbnail, error) {
+	storage := config.hostedStorage()
+	content, err := storage.Open(metadata.Filename)
+	if err != nil {
+		return nil, err
+	}
+	src, format, err := image.Decode(content)
+	content.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", metadata.Filename, err)
+	}
+
+	bounds := src.Bounds()
+	var thumbnails []Thumbnail
+	for _, width := range config.ThumbnailWidths {
+		if width >= bounds.Dx() {
+			// Never upscale; the original is the best match from here on
+			break
+		}
+		height := max(1, (bounds.Dy()*width+bounds.Dx()/2)/bounds.Dx())
+		resized := resizeImage(src, width, height)
+
+		var buf bytes.Buffer
+		ext := ".png"
+		if format == "jpeg" {
+			ext = ".jpg"
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, resized)
+		}
+		if err != nil {
+			return thumbnails, fmt.Errorf("failed to encode %dpx thumbnail: %w", width, err)
+		}
+
+		// Replace leftovers from an earlier run (e.g. before an archive restore)
+		name := thumbnailName(metadata.Filename, width, ext)
+		if err := storage.Delete(name); err != nil {
+			return thumbnails, fmt.Errorf("failed to replace %s: %w", name, err)
+		}
+		written, err := storage.Create(name, bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return thumbnails, fmt.Errorf("failed to store %s: %w", name, err)
+		}
+		thumbnails = append(thumbnails, Thumbnail{
+			Width:    width,
+			Height:   height,
+			Size:     written,
+			Filename: name,
+			URL:      fmt.Sprintf("%s/%s?w=%d", config.BaseURL, metadata.Filename, width),
+		})
+	}
+	return thumbnails, nil
+}
+
+// scheduleThumbnails generates thumbnails for a freshly ingested image in
+// the background and records them on the screenshot's metadata
+func scheduleThumbnails(config Config, metadata ScreenshotMetadata) {
+	if len(config.ThumbnailWidths) == 0 || !canThumbnail(metadata.Filename) {
+		return
+	}
+
+	go func() {
+		thumbnailSlots <- struct{}{}
+		defer func() { <-thumbnailSlots }()
+
+		thumbnails, err := generateThumbnails(config, metadata)
+		if err != nil {
+			log.Printf("Warning: Thumbnails failed for %s: %v", metadata.Filename, err)
+		}
+		if len(thumbnails) == 0 {
+			return
+		}
+
+		found, err := updateMetadata(config, metadata.ID, func(current *ScreenshotMetadata) {
+			current.Thumbnails = thumbnails
+		})
+		if err != nil || !found {
+			// The screenshot went away while we worked; don't leave orphans
+			deleteThumbnails(config, ScreenshotMetadata{Filename: metadata.Filename, Thumbnails: thumbnails})
+			if err != nil {
+				log.Printf("Warning: Failed to save thumbnails for %s: %v", metadata.Filename, err)
+			}
+			return
+		}
+		log.Printf("🖼️  Thumbnails: generated %d for %s", len(thumbnails), metadata.Filename)
+	}()
+}
+
+// deleteThumbnails removes a screenshot's thumbnails from the storage
+func deleteThumbnails(config Config, metadata ScreenshotMetadata) {
+	for _, thumbnail := range metadata.Thumbnails {
+		if err := config.hostedStorage().Delete(thumbnail.Filename); err != nil {
+			log.Printf("Warning: Failed to remove thumbnail %s: %v", thumbnail.Filename, err)
+		}
+	}
+}
+
+// thumbnailFor picks the thumbnail to serve for a requested width: the
+// smallest one at least that wide. It reports false when no thumbnail is
+// wide enough and the original should be served.
+func thumbnailFor(metadata ScreenshotMetadata, width int) (Thumbnail, bool) {
+	var best Thumbnail
+	found := false
+	for _, thumbnail := range metadata.Thumbnails {
+		if thumbnail.Width >= width && (!found || thumbnail.Width < best.Width) {
+			best, found = thumbnail, true
+		}
+	}
+	return best, found
+}
+
+// thumbnailsSize is the storage taken by a screenshot's thumbnails
+func thumbnailsSize(metadata ScreenshotMetadata) int64 {
+	var total int64
+	for _, thumbnail := range metadata.Thumbnails {
+		total += thumbnail.Size
+	}
+	return total
+}
+
+// resizeImage downscales src to width x height by averaging the source
+// pixels each destination pixel covers (a box filter), which keeps the
+// text in screenshots legible without pulling in an imaging library
+func resizeImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	rgba, ok := src.(*image.RGBA)
+	if !ok || bounds.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	}
+
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := max((y+1)*srcHeight/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := max((x+1)*srcWidth/width, x0+1)
+
+			// RGBA is premultiplied, so plain averaging blends alpha correctly
+			var sum [4]uint64
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += uint64(row[sx*4+c])
+					}
+				}
+			}
+			n := uint64((y1 - y0) * (x1 - x0))
+			offset := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[offset+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
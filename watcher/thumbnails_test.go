@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeTestPNG stores a width x height PNG in the hosted directory
+func writeTestPNG(t *testing.T, config Config, name string, width, height int) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(config.DataDir, "hosted", name), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+}
+
+func TestParseThumbnailWidths(t *testing.T) {
+	tests := map[string][]int{
+		"256,768":        {256, 768},
+		" 768, 256,256 ": {256, 768},
+		"128,abc,-1":     {128},
+		"0":              nil,
+		"":               nil,
+	}
+	for input, expected := range tests {
+		if got := parseThumbnailWidths(input); !reflect.DeepEqual(got, expected) {
+			t.Errorf("parseThumbnailWidths(%q) = %v, expected %v", input, got, expected)
+		}
+	}
+}
+
+func TestResizeImageAverages(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			if x < 2 {
+				src.Set(x, y, color.RGBA{R: 200, A: 255})
+			} else {
+				src.Set(x, y, color.RGBA{B: 100, A: 255})
+			}
+		}
+	}
+
+	halved := resizeImage(src, 2, 1)
+	if got := halved.RGBAAt(0, 0); got != (color.RGBA{R: 200, A: 255}) {
+		t.Errorf("Left pixel = %v", got)
+	}
+	if got := halved.RGBAAt(1, 0); got != (color.RGBA{B: 100, A: 255}) {
+		t.Errorf("Right pixel = %v", got)
+	}
+
+	single := resizeImage(src, 1, 1)
+	if got := single.RGBAAt(0, 0); got != (color.RGBA{R: 100, B: 50, A: 255}) {
+		t.Errorf("Averaged pixel = %v", got)
+	}
+}
+
+func TestThumbnailNamesDontCollide(t *testing.T) {
+	names := make(map[string]string)
+	for _, filename := range []string{"a.jpg", "a.jpeg", "a.png"} {
+		name := thumbnailName(filename, 256, ".jpg")
+		if other, taken := names[name]; taken {
+			t.Errorf("%s and %s share the thumbnail %s", other, filename, name)
+		}
+		names[name] = filename
+	}
+}
+
+func TestThumbnails(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.ThumbnailWidths = []int{256, 768, 2000}
+	writeTestPNG(t, config, "wide.png", 1000, 500)
+	metadata := ScreenshotMetadata{ID: "thumb-id", Filename: "wide.png", URL: config.BaseURL + "/wide.png", Timestamp: time.Now()}
+	config.metadataRepo().Save(metadata)
+
+	scheduleThumbnails(config, metadata)
+	if !waitFor(t, 5*time.Second, func() bool {
+		m, _, _ := config.metadataRepo().Get("thumb-id")
+		return len(m.Thumbnails) > 0
+	}) {
+		t.Fatal("Thumbnails not recorded")
+	}
+
+	metadata, _, _ = config.metadataRepo().Get("thumb-id")
+	// 2000px would be an upscale, so only two are generated
+	if len(metadata.Thumbnails) != 2 {
+		t.Fatalf("Expected 2 thumbnails, got %+v", metadata.Thumbnails)
+	}
+	small := metadata.Thumbnails[0]
+	if small.Width != 256 || small.Height != 128 || small.Filename != "thumbs/wide.png-256.png" ||
+		small.URL != "http://test.example.com/wide.png?w=256" {
+		t.Errorf("Unexpected thumbnail %+v", small)
+	}
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		serveHostedFile(w, httptest.NewRequest("GET", target, nil), config)
+		return w
+	}
+	servedWidth := func(w *httptest.ResponseRecorder) int {
+		img, err := png.DecodeConfig(w.Body)
+		if err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return img.Width
+	}
+
+	t.Run("ServesSmallestWideEnough", func(t *testing.T) {
+		for target, expected := range map[string]int{
+			"/wide.png?w=100":  256,
+			"/wide.png?w=256":  256,
+			"/wide.png?w=300":  768,
+			"/wide.png?w=1500": 1000,
+			"/wide.png":        1000,
+		} {
+			w := serve(target)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: expected 200, got %d", target, w.Code)
+			}
+			if got := servedWidth(w); got != expected {
+				t.Errorf("%s served %dpx, expected %dpx", target, got, expected)
+			}
+		}
+	})
+
+	t.Run("RejectsInvalidWidth", func(t *testing.T) {
+		if w := serve("/wide.png?w=abc"); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("HidesThumbnailPaths", func(t *testing.T) {
+		if w := serve("/thumbs/wide.png-256.png"); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for a direct thumbnail request, got %d", w.Code)
+		}
+	})
+
+	t.Run("PrivateThumbnailsNeedSignature", func(t *testing.T) {
+		config.URLSecret = []byte("secret")
+		private := metadata
+		private.Private = true
+		config.metadataRepo().Save(private)
+		defer config.metadataRepo().Save(metadata)
+
+		if w := serve("/wide.png?w=256"); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 without a signature, got %d", w.Code)
+		}
+		signed, _ := signURL(config, "wide.png", time.Now().Add(time.Hour))
+		w := serve(signed[len(config.BaseURL):] + "&w=256")
+		if w.Code != http.StatusOK || servedWidth(w) != 256 {
+			t.Errorf("Signed thumbnail request failed with %d", w.Code)
+		}
+	})
+
+	t.Run("DeleteRemovesThumbnails", func(t *testing.T) {
+		if err := deleteScreenshot(config, metadata); err != nil {
+			t.Fatalf("deleteScreenshot failed: %v", err)
+		}
+		for _, thumbnail := range metadata.Thumbnails {
+			if fileExists(filepath.Join(config.DataDir, "hosted", thumbnail.Filename)) {
+				t.Errorf("%s was not removed", thumbnail.Filename)
+			}
+		}
+	})
+}
+
+func TestRestoreRegeneratesThumbnails(t *testing.T) {
+	t.Setenv("SSBNK_API_KEY", "test-key")
+	config, _ := createTestConfig(t)
+	config.ThumbnailWidths = []int{256}
+	writeTestPNG(t, config, "old.png", 512, 512)
+	metadata := ScreenshotMetadata{ID: "old-id", Filename: "old.png", Timestamp: time.Now().AddDate(0, 0, -60)}
+	config.metadataRepo().Save(metadata)
+
+	thumbnails, err := generateThumbnails(config, metadata)
+	if err != nil || len(thumbnails) != 1 {
+		t.Fatalf("generateThumbnails = %v, %v", thumbnails, err)
+	}
+	metadata.Thumbnails = thumbnails
+	config.metadataRepo().Save(metadata)
+	thumbPath := filepath.Join(config.DataDir, "hosted", "thumbs", "old.png-256.png")
+
+	if err := archiveScreenshot(config, metadata, filepath.Join(archiveRoot(config), "2024-01-01")); err != nil {
+		t.Fatalf("archiveScreenshot failed: %v", err)
+	}
+	if fileExists(thumbPath) {
+		t.Error("Thumbnail kept after archiving")
+	}
+
+	w := httptest.NewRecorder()
+	handleAPIArchive(w, apiRequest("POST", "/api/archive/old-id/restore", ""), config)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Restore failed with %d: %s", w.Code, w.Body.String())
+	}
+	if !waitFor(t, 5*time.Second, func() bool {
+		m, _, _ := config.metadataRepo().Get("old-id")
+		return len(m.Thumbnails) == 1 && fileExists(thumbPath)
+	}) {
+		t.Error("Thumbnails not regenerated after restore")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxImageUpload caps each image of an /upload request
+	maxImageUpload = 50 << 20
+	// defaultMaxVideoUpload caps each recording unless
+	// SSBNK_MAX_VIDEO_UPLOAD says otherwise
+	defaultMaxVideoUpload = 500 << 20
+	// maxUploadFields caps the combined size of the non-file form fields
+	maxUploadFields = 1 << 20
+	// defaultMaxUploadSize caps a whole /upload request body unless
+	// SSBNK_MAX_UPLOAD_SIZE says otherwise
+	defaultMaxUploadSize = 1 << 30
+	// defaultMaxUploadFiles caps the file parts of one /upload request
+	// unless SSBNK_MAX_UPLOAD_FILES says otherwise
+	defaultMaxUploadFiles = 20
+)
+
+var errUploadTooLarge = errors.New("upload too large")
+
+// uploadForm is a parsed /upload request. File parts are streamed to disk
+// under DataDir/uploads as they arrive instead of being buffered in memory.
+type uploadForm struct {
+	Values url.Values
+	Files  []uploadedFile
+}
+
+// uploadedFile is a file part of an /upload request spooled to disk
+type uploadedFile struct {
+	Filename string
+	Size     int64
+	Path     string
+}
+
+// Open opens the spooled content
+func (f uploadedFile) Open() (*os.File, error) {
+	return os.Open(f.Path)
+}
+
+// uploadDir holds uploads while they are processed
+func uploadDir(config Config) string {
+	return filepath.Join(config.DataDir, "uploads")
+}
+
+// maxVideoUpload is the size cap for each uploaded recording
+func maxVideoUpload(config Config) int64 {
+	if config.MaxVideoUpload > 0 {
+		return config.MaxVideoUpload
+	}
+	return defaultMaxVideoUpload
+}
+
+// maxUploadSize is the size cap for a whole /upload request. By default it
+// fits at least one recording of maxVideoUpload.
+func maxUploadSize(config Config) int64 {
+	if config.MaxUploadSize > 0 {
+		return config.MaxUploadSize
+	}
+	return max(defaultMaxUploadSize, maxVideoUpload(config)+maxUploadFields)
+}
+
+// maxUploadFiles is the number of files accepted in one /upload request
+func maxUploadFiles(config Config) int {
+	if config.MaxUploadFiles > 0 {
+		return config.MaxUploadFiles
+	}
+	return defaultMaxUploadFiles
+}
+
+// readUpload streams a multipart /upload request to disk. Recordings (by
+// extension) may be up to maxVideoUpload, every other file maxImageUpload,
+// and the whole body maxUploadSize with at most maxUploadFiles files;
+// errUploadTooLarge is returned past any of them, after removing what was
+// spooled. Fields in the URL query are kept after those of the form.
+func readUpload(w http.ResponseWriter, r *http.Request, config Config) (*uploadForm, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize(config))
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(uploadDir(config), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	form := &uploadForm{Values: url.Values{}}
+	fail := func(err error) (*uploadForm, error) {
+		form.RemoveAll()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, fmt.Errorf("%w: request is over %s", errUploadTooLarge, formatBytes(tooLarge.Limit))
+		}
+		return nil, err
+	}
+
+	fieldBytes := int64(0)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxUploadFields-fieldBytes+1))
+			part.Close()
+			if err != nil {
+				return fail(err)
+			}
+			if fieldBytes += int64(len(value)); fieldBytes > maxUploadFields {
+				return fail(errUploadTooLarge)
+			}
+			form.Values.Add(part.FormName(), string(value))
+			continue
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		if len(form.Files) >= maxUploadFiles(config) {
+			part.Close()
+			return fail(fmt.Errorf("%w: more than %d files", errUploadTooLarge, maxUploadFiles(config)))
+		}
+
+		limit := int64(maxImageUpload)
+		if isVideoFile(part.FileName()) {
+			limit = maxVideoUpload(config)
+		}
+		file, err := spoolPart(config, part, limit)
+		part.Close()
+		if file.Path != "" {
+			form.Files = append(form.Files, file)
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	for key, values := range r.URL.Query() {
+		form.Values[key] = append(form.Values[key], values...)
+	}
+	return form, nil
+}
+
+// spoolPart copies one file part to a temp file, up to limit bytes
+func spoolPart(config Config, part *multipart.Part, limit int64) (uploadedFile, error) {
+	filename := part.FileName()
+	temp, err := os.CreateTemp(uploadDir(config), "upload-*"+strings.ToLower(filepath.Ext(filename)))
+	if err != nil {
+		return uploadedFile{}, fmt.Errorf("failed to spool upload: %w", err)
+	}
+	file := uploadedFile{Filename: filename, Path: temp.Name()}
+	file.Size, err = io.Copy(temp, io.LimitReader(part, limit+1))
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return file, fmt.Errorf("failed to spool upload: %w", err)
+	}
+	if file.Size > limit {
+		return file, fmt.Errorf("%w: %s is over %s", errUploadTooLarge, filename, formatBytes(limit))
+	}
+	return file, nil
+}
+
+// Keep hands the spooled file at index i over to the caller, which becomes
+// responsible for removing it
+func (form *uploadForm) Keep(i int) uploadedFile {
+	file := form.Files[i]
+	form.Files[i].Path = ""
+	return file
+}
+
+// RemoveAll deletes the spooled files that weren't kept
+func (form *uploadForm) RemoveAll() {
+	for _, file := range form.Files {
+		if file.Path != "" {
+			os.Remove(file.Path)
+		}
+	}
+}
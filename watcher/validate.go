@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+)
+
+const (
+	// maxImageDimension caps either side of a new image
+	maxImageDimension = 1 << 15
+	// defaultMaxImagePixels caps width*height, bounding the memory taken by
+	// decoding the image here and later for thumbnails and redaction
+	defaultMaxImagePixels = 50_000_000
+)
+
+// markupPatterns are the starts of markup a browser could render or run.
+// They only count when followed by whitespace, '>' or '/', which keeps
+// compressed pixel data from matching by chance.
+var markupPatterns = [][]byte{
+	[]byte("<!doctype"),
+	[]byte("<html"),
+	[]byte("<head"),
+	[]byte("<body"),
+	[]byte("<script"),
+	[]byte("<iframe"),
+	[]byte("<svg"),
+	[]byte("<object"),
+	[]byte("<embed"),
+	[]byte("<?xml"),
+	[]byte("<?php"),
+}
+
+// sniffImage identifies an image from its magic bytes and returns the
+// extension it should be hosted under, or "" for anything else
+func sniffImage(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, pngSignature):
+		return ".png"
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return ".jpg"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return ".gif"
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return ".webp"
+	case isAVIF(data):
+		return ".avif"
+	}
+	return ""
+}
+
+// isAVIF reports whether data starts with an ftyp box naming an AVIF brand
+func isAVIF(data []byte) bool {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	if size < 16 || size > len(data) {
+		return false
+	}
+	// The major brand, then the compatible brands after the minor version
+	for offset := 8; offset+4 <= size; offset += 4 {
+		if brand := string(data[offset : offset+4]); offset != 12 && (brand == "avif" || brand == "avis") {
+			return true
+		}
+	}
+	return false
+}
+
+// validateImage checks that content is an image that is safe to host
+// publicly: a known format going by its content rather than its name,
+// within the size limits, free of markup and of data appended after the
+// image. PNG and JPEG are fully decoded and GIF up to its first frame;
+// WebP and AVIF, which the standard library can't decode, are checked
+// structurally. It returns the extension matching the content and leaves
+// content rewound.
+func validateImage(config Config, content io.ReadSeeker) (string, error) {
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	ext := sniffImage(data)
+	if ext == "" {
+		return "", fmt.Errorf("%w: not a PNG, JPEG, GIF, WebP or AVIF file", errInvalidImage)
+	}
+	if containsMarkup(data) {
+		return "", fmt.Errorf("%w: contains HTML or script markup", errInvalidImage)
+	}
+
+	width, height, err := imageDimensions(ext, data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+	maxPixels := int64(config.MaxImagePixels)
+	if maxPixels <= 0 {
+		maxPixels = defaultMaxImagePixels
+	}
+	if width <= 0 || height <= 0 || width > maxImageDimension || height > maxImageDimension ||
+		int64(width)*int64(height) > maxPixels {
+		return "", fmt.Errorf("%w: %dx%d is outside the size limits", errInvalidImage, width, height)
+	}
+
+	// Only decode once the dimensions are known to be reasonable
+	if err := decodeImage(ext, data); err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidImage, err)
+	}
+	return ext, nil
+}
+
+// validateImageFile runs validateImage on a file
+func validateImageFile(config Config, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return validateImage(config, file)
+}
+
+// containsMarkup looks for HTML, SVG, XML or PHP tags anywhere in data, as
+// polyglot files hide them after (or inside) valid image data
+func containsMarkup(data []byte) bool {
+	lower := bytes.ToLower(data)
+	for _, pattern := range markupPatterns {
+		for rest := lower; ; {
+			i := bytes.Index(rest, pattern)
+			if i < 0 {
+				break
+			}
+			end := i + len(pattern)
+			if end == len(rest) {
+				return true
+			}
+			switch rest[end] {
+			case ' ', '\t', '\n', '\r', '\f', '>', '/':
+				return true
+			}
+			rest = rest[end:]
+		}
+	}
+	return false
+}
+
+// imageDimensions reads the size of an image from its header and checks
+// that nothing follows the image where the format makes that detectable
+func imageDimensions(ext string, data []byte) (width, height int, err error) {
+	switch ext {
+	case ".png":
+		chunks, err := splitPNG(data)
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(chunks) == 0 {
+			return 0, 0, fmt.Errorf("PNG has no chunks")
+		}
+		if chunks[len(chunks)-1].Type != "IEND" {
+			return 0, 0, fmt.Errorf("data after the end of the PNG")
+		}
+		cfg, err := png.DecodeConfig(bytes.NewReader(data))
+		return cfg.Width, cfg.Height, err
+	case ".jpg":
+		// Phones append extra images after the JPEG's end, so trailing data
+		// is allowed here and left to the markup check
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		return cfg.Width, cfg.Height, err
+	case ".gif":
+		cfg, err := gif.DecodeConfig(bytes.NewReader(data))
+		return cfg.Width, cfg.Height, err
+	case ".webp":
+		return webpDimensions(data)
+	case ".avif":
+		return avifDimensions(data)
+	}
+	return 0, 0, fmt.Errorf("unsupported format %s", ext)
+}
+
+// decodeImage decodes the pixel data of the formats the standard library
+// supports, catching truncated or corrupt images. Only the first frame of
+// a GIF is decoded, since every frame may take up to the full size limit.
+func decodeImage(ext string, data []byte) error {
+	var err error
+	switch ext {
+	case ".png":
+		_, err = png.Decode(bytes.NewReader(data))
+	case ".jpg":
+		_, err = jpeg.Decode(bytes.NewReader(data))
+	case ".gif":
+		_, err = gif.Decode(bytes.NewReader(data))
+	}
+	return err
+}
+
+// webpDimensions reads the canvas size from the first chunk of a WebP file
+func webpDimensions(data []byte) (int, int, error) {
+	if size := binary.LittleEndian.Uint32(data[4:8]); uint64(size)+8 != uint64(len(data)) {
+		return 0, 0, fmt.Errorf("RIFF size doesn't match the file")
+	}
+	if len(data) < 30 {
+		return 0, 0, fmt.Errorf("truncated WebP")
+	}
+	payload := data[20:]
+	switch string(data[12:16]) {
+	case "VP8X":
+		width := int(payload[4]) | int(payload[5])<<8 | int(payload[6])<<16
+		height := int(payload[7]) | int(payload[8])<<8 | int(payload[9])<<16
+		return width + 1, height + 1, nil
+	case "VP8 ":
+		if !bytes.Equal(payload[3:6], []byte{0x9D, 0x01, 0x2A}) {
+			return 0, 0, fmt.Errorf("bad VP8 start code")
+		}
+		width := binary.LittleEndian.Uint16(payload[6:8]) & 0x3FFF
+		height := binary.LittleEndian.Uint16(payload[8:10]) & 0x3FFF
+		return int(width), int(height), nil
+	case "VP8L":
+		if payload[0] != 0x2F {
+			return 0, 0, fmt.Errorf("bad VP8L signature")
+		}
+		bits := binary.LittleEndian.Uint32(payload[1:5])
+		return int(bits&0x3FFF) + 1, int(bits>>14&0x3FFF) + 1, nil
+	}
+	return 0, 0, fmt.Errorf("unknown WebP chunk %q", data[12:16])
+}
+
+// avifDimensions reads the largest image spatial extent (ispe) property
+// of an AVIF file, after checking its boxes span exactly the whole file
+func avifDimensions(data []byte) (int, int, error) {
+	if _, err := isoBoxes(data, ""); err != nil {
+		return 0, 0, err
+	}
+	width, height := 0, 0
+	metas, _ := isoBoxes(data, "meta")
+	for _, meta := range metas {
+		if len(meta) < 4 {
+			continue
+		}
+		iprps, _ := isoBoxes(meta[4:], "iprp")
+		for _, iprp := range iprps {
+			ipcos, _ := isoBoxes(iprp, "ipco")
+			for _, ipco := range ipcos {
+				ispes, _ := isoBoxes(ipco, "ispe")
+				for _, ispe := range ispes {
+					if len(ispe) < 12 {
+						continue
+					}
+					width = max(width, int(binary.BigEndian.Uint32(ispe[4:8])))
+					height = max(height, int(binary.BigEndian.Uint32(ispe[8:12])))
+				}
+			}
+		}
+	}
+	if width == 0 || height == 0 {
+		return 0, 0, fmt.Errorf("no image size in AVIF")
+	}
+	return width, height, nil
+}
+
+// isoBoxes returns the payloads of the ISO BMFF boxes of type typ (every
+// box when typ is empty) in data, which must consist of whole boxes
+func isoBoxes(data []byte, typ string) ([][]byte, error) {
+	var boxes [][]byte
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("truncated box")
+		}
+		size, header := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("truncated box")
+			}
+			size, header = binary.BigEndian.Uint64(data[8:16]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, fmt.Errorf("bad box size")
+		}
+		if typ == "" || string(data[4:8]) == typ {
+			boxes = append(boxes, data[header:size])
+		}
+		data = data[size:]
+	}
+	return boxes, nil
+}
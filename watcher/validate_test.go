@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// isoBox builds an ISO BMFF box
+func isoBox(typ string, payloads ...[]byte) []byte {
+	payload := bytes.Join(payloads, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(box, typ...), payload...)
+}
+
+// testAVIF builds the boxes of an AVIF file declaring a width x height image
+func testAVIF(width, height uint32) []byte {
+	ispe := binary.BigEndian.AppendUint32(make([]byte, 4), width)
+	ispe = binary.BigEndian.AppendUint32(ispe, height)
+	meta := isoBox("meta", make([]byte, 4), isoBox("iprp", isoBox("ipco", isoBox("ispe", ispe))))
+	return append(isoBox("ftyp", []byte("avif\x00\x00\x00\x00mif1")), meta...)
+}
+
+// testWebP builds a lossless WebP header declaring a width x height image
+func testWebP(width, height uint32) []byte {
+	payload := binary.LittleEndian.AppendUint32([]byte{0x2F}, (width-1)|(height-1)<<14)
+	payload = append(payload, make([]byte, 5)...)
+	data := append([]byte("RIFF\x00\x00\x00\x00WEBPVP8L"), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+	data = append(data, payload...)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	return data
+}
+
+func TestValidateImage(t *testing.T) {
+	var pngData, jpegData, gifData bytes.Buffer
+	img := image.NewGray(image.Rect(0, 0, 20, 10))
+	png.Encode(&pngData, img)
+	jpeg.Encode(&jpegData, img, nil)
+	gif.Encode(&gifData, img, nil)
+
+	valid := map[string][]byte{
+		".png":  pngData.Bytes(),
+		".jpg":  jpegData.Bytes(),
+		".gif":  gifData.Bytes(),
+		".webp": testWebP(640, 480),
+		".avif": testAVIF(640, 480),
+	}
+	for expected, data := range valid {
+		ext, err := validateImage(Config{}, bytes.NewReader(data))
+		if err != nil || ext != expected {
+			t.Errorf("validateImage(%s) = %q, %v", expected, ext, err)
+		}
+	}
+
+	invalid := map[string][]byte{
+		"Text":          []byte("just some text"),
+		"HTML":          []byte("<!DOCTYPE html><html><script>alert(1)</script></html>"),
+		"PNGWithScript": append(bytes.Clone(pngData.Bytes()), "<script>alert(1)</script>"...),
+		"PNGWithZip":    append(bytes.Clone(pngData.Bytes()), "PK\x03\x04 appended archive"...),
+		"JPEGWithHTML":  append(bytes.Clone(jpegData.Bytes()), "<HTML>"...),
+		"TruncatedPNG":  pngData.Bytes()[:pngData.Len()-20],
+		"TruncatedJPEG": jpegData.Bytes()[:jpegData.Len()/2],
+		"HugeWebP":      testWebP(16000, 16000),
+		"HugeAVIF":      testAVIF(100000, 10),
+		"AVIFWithTail":  append(testAVIF(640, 480), "tail"...),
+		"PNGSignature":  bytes.Clone(pngSignature),
+	}
+	for name, data := range invalid {
+		if ext, err := validateImage(Config{}, bytes.NewReader(data)); !errors.Is(err, errInvalidImage) {
+			t.Errorf("%s: expected errInvalidImage, got %q, %v", name, ext, err)
+		}
+	}
+
+	// The pixel limit is configurable
+	if _, err := validateImage(Config{MaxImagePixels: 100}, bytes.NewReader(pngData.Bytes())); !errors.Is(err, errInvalidImage) {
+		t.Errorf("Expected 20x10 to exceed a 100 pixel limit, got %v", err)
+	}
+
+	// Markup only counts as a whole tag
+	if containsMarkup([]byte("\x89PNG <htmlx <svgfoo")) {
+		t.Error("Tag prefixes matched as markup")
+	}
+}
+
+func TestUploadValidatesContent(t *testing.T) {
+	t.Setenv("SSBNK_UPLOAD_KEY", "upload-key")
+	config, _ := createTestConfig(t)
+	config.ValidateImages = true
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)))
+
+	// Named as a JPEG but hosted as the PNG it is
+	w := httptest.NewRecorder()
+	handleUpload(w, multipartUpload(t, map[string]string{"photo.jpg": buf.String()}), config)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response struct {
+		Filename string `json:"filename"`
+	}
+	json.NewDecoder(w.Body).Decode(&response)
+	if !strings.HasSuffix(response.Filename, ".png") {
+		t.Errorf("Expected a .png filename, got %s", response.Filename)
+	}
+
+	// One bad file rejects the whole batch
+	var other bytes.Buffer
+	png.Encode(&other, image.NewGray(image.Rect(0, 0, 9, 9)))
+	w = httptest.NewRecorder()
+	files := map[string]string{"ok.png": other.String(), "page.png": "<html><body>hi</body></html>"}
+	handleUpload(w, multipartUpload(t, files), config)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if all := loadAllMetadata(config); len(all) != 1 {
+		t.Errorf("Expected only the first upload stored, got %d", len(all))
+	}
+}
+
+func TestProcessScreenshotValidatesContent(t *testing.T) {
+	config, _ := createTestConfig(t)
+	config.ValidateImages = true
+
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil)
+	sourcePath := filepath.Join(config.ScreenshotDir, "shot.png")
+	os.WriteFile(sourcePath, buf.Bytes(), 0644)
+	if err := processScreenshot(sourcePath, config); err != nil {
+		t.Fatalf("processScreenshot failed: %v", err)
+	}
+	all := loadAllMetadata(config)
+	if len(all) != 1 || filepath.Ext(all[0].Filename) != ".jpg" {
+		t.Fatalf("Expected one screenshot hosted as .jpg, got %+v", all)
+	}
+
+	fakePath := filepath.Join(config.ScreenshotDir, "fake.png")
+	os.WriteFile(fakePath, []byte("<svg onload=alert(1)>"), 0644)
+	if err := processScreenshot(fakePath, config); !errors.Is(err, errInvalidImage) {
+		t.Errorf("Expected errInvalidImage, got %v", err)
+	}
+	if !fileExists(fakePath) {
+		t.Error("Rejected file was removed")
+	}
+	if all := loadAllMetadata(config); len(all) != 1 {
+		t.Errorf("Rejected file was hosted: %d entries", len(all))
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultGIFPreset = "default"
+
+// GIFPreset holds the settings for converting a screencast to a GIF
+type GIFPreset struct {
+	Name       string `json:"name"`
+	MaxSeconds int    `json:"max_seconds"` // 0 converts the whole recording
+	FPS        int    `json:"fps"`
+	Width      int    `json:"width"`  // 0 keeps the recording's width
+	Dither     string `json:"dither"` // ffmpeg paletteuse dither mode
+	Loop       int    `json:"loop"`   // 0 loops forever, -1 plays once, N repeats N times
+}
+
+// gifPresets are the built-in presets, by name
+var gifPresets = map[string]GIFPreset{
+	"default": {Name: "default", MaxSeconds: 30, FPS: 10, Width: 640, Dither: "sierra2_4a"},
+	"short":   {Name: "short", MaxSeconds: 10, FPS: 10, Width: 640, Dither: "sierra2_4a"},
+	"small":   {Name: "small", MaxSeconds: 15, FPS: 8, Width: 480, Dither: "bayer"},
+	"hq":      {Name: "hq", MaxSeconds: 60, FPS: 15, Width: 960, Dither: "floyd_steinberg"},
+	"full":    {Name: "full", FPS: 10, Width: 640, Dither: "sierra2_4a"},
+}
+
+// gifDithers are the paletteuse dither modes a preset may pick
+var gifDithers = []string{"none", "bayer", "heckbert", "floyd_steinberg", "sierra2", "sierra2_4a"}
+
+// presetSuffixPattern matches the ".preset-<name>" suffix that picks the
+// preset for a recording saved to the screencast directory, e.g.
+// "demo.preset-hq.mp4"
+var presetSuffixPattern = regexp.MustCompile(`\.preset-([a-z0-9_-]+)$`)
+
+// gifPresetNamed returns the built-in preset called name
+func gifPresetNamed(name string) (GIFPreset, error) {
+	preset, ok := gifPresets[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		names := make([]string, 0, len(gifPresets))
+		for known := range gifPresets {
+			names = append(names, known)
+		}
+		sort.Strings(names)
+		return GIFPreset{}, fmt.Errorf("unknown GIF preset %q (known: %s)", name, strings.Join(names, ", "))
+	}
+	return preset, nil
+}
+
+// withGIFParams returns preset with the settings given by params changed.
+// get looks a parameter up by name (max_seconds, fps, width, dither, loop)
+// and returns "" when it is unset.
+func withGIFParams(preset GIFPreset, get func(string) string) (GIFPreset, error) {
+	ints := []struct {
+		name     string
+		field    *int
+		min, max int
+	}{
+		{"max_seconds", &preset.MaxSeconds, 0, 3600},
+		{"fps", &preset.FPS, 1, 50},
+		{"width", &preset.Width, 0, 3840},
+		{"loop", &preset.Loop, -1, 1000},
+	}
+	for _, param := range ints {
+		value := get(param.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < param.min || n > param.max {
+			return GIFPreset{}, fmt.Errorf("%s must be a number from %d to %d", param.name, param.min, param.max)
+		}
+		*param.field = n
+	}
+	if dither := get("dither"); dither != "" {
+		if !containsString(gifDithers, dither) {
+			return GIFPreset{}, fmt.Errorf("dither must be one of %s", strings.Join(gifDithers, ", "))
+		}
+		preset.Dither = dither
+	}
+	return preset, nil
+}
+
+// loadGIFPreset resolves the configured preset: SSBNK_GIF_PRESET picks a
+// built-in one and SSBNK_GIF_<PARAM> variables override its settings.
+// Invalid settings fall back to the default preset.
+func loadGIFPreset() GIFPreset {
+	preset, err := gifPresetNamed(getEnv("SSBNK_GIF_PRESET", defaultGIFPreset))
+	if err != nil {
+		log.Printf("Warning: %v, using %s", err, defaultGIFPreset)
+		return gifPresets[defaultGIFPreset]
+	}
+	preset, err = withGIFParams(preset, func(name string) string {
+		return os.Getenv("SSBNK_GIF_" + strings.ToUpper(name))
+	})
+	if err != nil {
+		log.Printf("Warning: Invalid GIF setting: %v, using %s", err, defaultGIFPreset)
+		return gifPresets[defaultGIFPreset]
+	}
+	return preset
+}
+
+// splitPresetSuffix strips a ".preset-<name>" suffix from a filename and
+// returns the cleaned name and the preset name ("" if there was none)
+func splitPresetSuffix(filename string) (string, string) {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	match := presetSuffixPattern.FindStringSubmatch(base)
+	if match == nil {
+		return filename, ""
+	}
+	return strings.TrimSuffix(base, match[0]) + ext, match[1]
+}
+
+// recordingPreset picks the preset for a recording: the one named by its
+// filename suffix if it is known, the configured one otherwise
+func recordingPreset(config Config, presetName string) GIFPreset {
+	if presetName != "" {
+		preset, err := gifPresetNamed(presetName)
+		if err == nil {
+			return preset
+		}
+		log.Printf("Warning: %v, using the configured preset", err)
+	}
+	if config.GIFPreset.Name == "" {
+		return gifPresets[defaultGIFPreset]
+	}
+	return config.GIFPreset
+}
+
+// videoDemuxers are the container formats accepted from ffprobe; anything
+// else (playlists such as HLS or concat in particular) could make ffmpeg
+// read other files or fetch URLs
+var videoDemuxers = map[string]bool{
+	"mov": true, "mp4": true, "matroska": true, "webm": true,
+	"avi": true, "flv": true, "asf": true,
+}
+
+// errInvalidVideo marks recordings ffprobe doesn't recognize as a
+// supported container
+var errInvalidVideo = errors.New("not a supported video")
+
+// probeVideoFormat asks ffprobe for the container of a recording and
+// returns the demuxer to force with -f
+func probeVideoFormat(path string) (string, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-protocol_whitelist", "file",
+		"-show_entries", "format=format_name", "-of", "default=noprint_wrappers=1:nokey=1", path).Output()
+	if err != nil {
+		return "", fmt.Errorf("%w: ffprobe failed: %v", errInvalidVideo, err)
+	}
+	// ffprobe lists a demuxer's aliases, e.g. "mov,mp4,m4a,3gp,3g2,mj2";
+	// the first one names it for -f
+	names := strings.TrimSpace(string(out))
+	demuxer, _, _ := strings.Cut(names, ",")
+	if !videoDemuxers[demuxer] {
+		return "", fmt.Errorf("%w: %s is %q", errInvalidVideo, filepath.Base(path), names)
+	}
+	return demuxer, nil
+}
+
+// ffmpegInputArgs reads input only as a local file in the probed format
+func ffmpegInputArgs(input, format string) []string {
+	return []string{"-protocol_whitelist", "file", "-f", format, "-i", input}
+}
+
+// ffmpegGIFArgs builds the ffmpeg arguments converting input, in the
+// container format probed by probeVideoFormat, to a looping GIF at output
+// with a generated palette
+func ffmpegGIFArgs(preset GIFPreset, input, output, format string) []string {
+	args := append([]string{"-y"}, ffmpegInputArgs(input, format)...)
+	if preset.MaxSeconds > 0 {
+		args = append(args, "-t", strconv.Itoa(preset.MaxSeconds))
+	}
+
+	var filters []string
+	if preset.FPS > 0 {
+		filters = append(filters, fmt.Sprintf("fps=%d", preset.FPS))
+	}
+	if preset.Width > 0 {
+		filters = append(filters, fmt.Sprintf("scale=%d:-1:flags=lanczos", preset.Width))
+	}
+	dither := preset.Dither
+	if dither == "" {
+		dither = "sierra2_4a"
+	}
+	filters = append(filters, "split[s0][s1];[s0]palettegen[p];[s1][p]paletteuse=dither="+dither)
+
+	return append(args, "-vf", strings.Join(filters, ","), "-loop", strconv.Itoa(preset.Loop), output)
+}
+
+// runFFmpeg runs ffmpeg and checks that it produced a non-empty output
+func runFFmpeg(args []string, output string) error {
+	if out, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg error: %w\nOutput: %s", err, string(out))
+	}
+	if info, err := os.Stat(output); err != nil || info.Size() == 0 {
+		return fmt.Errorf("output file not created")
+	}
+	return nil
+}
+
+// Video transcode formats
+const (
+	videoOff  = "off"
+	videoMP4  = "mp4"
+	videoWebM = "webm"
+)
+
+// Video is the web-friendly transcode of a screencast, hosted next to its
+// GIF under the same base name
+type Video struct {
+	Filename string `json:"filename"`
+	URL      string `json:"url"`
+	Format   string `json:"format"` // mp4 (H.264/AAC) or webm (VP9/Opus)
+	Size     int64  `json:"size"`
+}
+
+func init() {
+	// Missing from Go's built-in table; hosted videos need them to play
+	// inline, locally and from S3
+	mime.AddExtensionType(".mp4", "video/mp4")
+	mime.AddExtensionType(".webm", "video/webm")
+}
+
+// parseVideoFormat reads the transcode format from key, defaulting to off
+func parseVideoFormat(key string) string {
+	format := strings.ToLower(getEnv(key, videoOff))
+	switch format {
+	case videoOff, videoMP4, videoWebM:
+		return format
+	}
+	log.Printf("Warning: Unknown %s=%q, video transcoding disabled", key, format)
+	return videoOff
+}
+
+// ffmpegVideoArgs builds the ffmpeg arguments transcoding input (in the
+// probed inputFormat) to a full-length video at output that browsers play
+// inline, keeping the resolution (rounded down to even dimensions) and the
+// audio
+func ffmpegVideoArgs(format, input, inputFormat, output string) []string {
+	args := append([]string{"-y"}, ffmpegInputArgs(input, inputFormat)...)
+	args = append(args, "-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2")
+	if format == videoWebM {
+		args = append(args, "-c:v", "libvpx-vp9", "-crf", "32", "-b:v", "0", "-row-mt", "1",
+			"-c:a", "libopus", "-b:a", "96k")
+	} else {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+			"-c:a", "aac", "-b:a", "128k", "-movflags", "+faststart")
+	}
+	return append(args, output)
+}
+
+// transcodeVideo converts a screencast (in the probed inputFormat) to
+// Config.VideoFormat and hosts it next to gifFilename. Failures are logged
+// and leave the GIF on its own.
+func transcodeVideo(config Config, sourcePath, inputFormat, gifFilename string) *Video {
+	format := config.VideoFormat
+	if format == "" || format == videoOff {
+		return nil
+	}
+
+	tempVideo, err := os.CreateTemp("", "ssbnk-*."+format)
+	if err != nil {
+		log.Printf("Warning: Failed to create temp video: %v", err)
+		return nil
+	}
+	tempVideo.Close()
+	defer os.Remove(tempVideo.Name())
+
+	log.Printf("Transcoding video to %s: %s", format, filepath.Base(sourcePath))
+	if err := runFFmpeg(ffmpegVideoArgs(format, sourcePath, inputFormat, tempVideo.Name()), tempVideo.Name()); err != nil {
+		log.Printf("Warning: Video transcoding failed, hosting the GIF only: %v", err)
+		return nil
+	}
+
+	info, err := os.Stat(tempVideo.Name())
+	if err != nil {
+		log.Printf("Warning: Failed to get video file info: %v", err)
+		return nil
+	}
+	_, release, err := makeRoom(config, info.Size())
+	if err != nil {
+		log.Printf("Warning: No room for the video, hosting the GIF only: %v", err)
+		return nil
+	}
+	defer release()
+
+	// The video shares the GIF's base name so requests for it can find
+	// the GIF's metadata
+	filename := strings.TrimSuffix(gifFilename, filepath.Ext(gifFilename)) + "." + format
+	file, err := os.Open(tempVideo.Name())
+	if err != nil {
+		log.Printf("Warning: Failed to open video: %v", err)
+		return nil
+	}
+	size, err := config.hostedStorage().Create(filename, file)
+	file.Close()
+	if err != nil {
+		log.Printf("Warning: Failed to store video %s: %v", filename, err)
+		return nil
+	}
+
+	log.Printf("🎞️  Video hosted: %s (%s)", filename, formatBytes(size))
+	return &Video{
+		Filename: filename,
+		URL:      fmt.Sprintf("%s/%s", config.BaseURL, filename),
+		Format:   format,
+		Size:     size,
+	}
+}
+
+// videoOwner finds the screenshot a hosted video belongs to: the GIF with
+// the same base name, if it links the video
+func videoOwner(config Config, filename string) (ScreenshotMetadata, bool, error) {
+	gifFilename := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".gif"
+	metadata, found, err := config.metadataRepo().GetByFilename(gifFilename)
+	if err != nil || !found || metadata.Video == nil || metadata.Video.Filename != filename {
+		return ScreenshotMetadata{}, false, err
+	}
+	return metadata, true, nil
+}
+
+// videoAccessURL is the URL to hand out for a screenshot's video; for
+// private ones it carries the signature of the GIF, which covers both
+func videoAccessURL(config Config, metadata ScreenshotMetadata) string {
+	if metadata.Video == nil {
+		return ""
+	}
+	if !metadata.Private {
+		return metadata.Video.URL
+	}
+	signed := accessURL(config, metadata)
+	if i := strings.IndexByte(signed, '?'); i >= 0 {
+		return metadata.Video.URL + signed[i:]
+	}
+	return metadata.Video.URL
+}
+
+// videoSize is the size of a screenshot's hosted video, if it has one
+func videoSize(metadata ScreenshotMetadata) int64 {
+	if metadata.Video == nil {
+		return 0
+	}
+	return metadata.Video.Size
+}
+
+// deleteVideo removes a screenshot's hosted video
+func deleteVideo(config Config, metadata ScreenshotMetadata) {
+	if metadata.Video == nil {
+		return
+	}
+	if err := config.hostedStorage().Delete(metadata.Video.Filename); err != nil {
+		log.Printf("Warning: Failed to remove video %s: %v", metadata.Video.Filename, err)
+	}
+}
+
+// archiveVideo moves a screenshot's hosted video into its archive day
+// directory, next to the GIF
+func archiveVideo(config Config, metadata ScreenshotMetadata, archiveDir string) {
+	if metadata.Video == nil {
+		return
+	}
+	dst := filepath.Join(archiveDir, filepath.Base(metadata.Video.Filename))
+	if err := exportHosted(config, metadata.Video.Filename, dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Warning: Failed to archive video %s: %v", metadata.Video.Filename, err)
+	}
+}
+
+// restoreVideo moves an archived video back into the hosted storage
+func restoreVideo(config Config, metadata ScreenshotMetadata, archiveDir string) {
+	if metadata.Video == nil {
+		return
+	}
+	src := filepath.Join(archiveDir, filepath.Base(metadata.Video.Filename))
+	if err := importHosted(config, src, metadata.Video.Filename); err != nil {
+		log.Printf("Warning: Failed to restore video %s: %v", metadata.Video.Filename, err)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFFmpegGIFArgs(t *testing.T) {
+	args := ffmpegGIFArgs(gifPresets["short"], "in.webm", "out.gif", "matroska")
+	expected := []string{
+		"-y", "-protocol_whitelist", "file", "-f", "matroska", "-i", "in.webm", "-t", "10",
+		"-vf", "fps=10,scale=640:-1:flags=lanczos,split[s0][s1];[s0]palettegen[p];[s1][p]paletteuse=dither=sierra2_4a",
+		"-loop", "0", "out.gif",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("ffmpegGIFArgs = %q\nexpected %q", args, expected)
+	}
+
+	// No duration cap or scaling, played once
+	preset := GIFPreset{FPS: 12, Dither: "bayer", Loop: -1}
+	args = ffmpegGIFArgs(preset, "in.mov", "out.gif", "mov")
+	expected = []string{
+		"-y", "-protocol_whitelist", "file", "-f", "mov", "-i", "in.mov",
+		"-vf", "fps=12,split[s0][s1];[s0]palettegen[p];[s1][p]paletteuse=dither=bayer",
+		"-loop", "-1", "out.gif",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("ffmpegGIFArgs = %q\nexpected %q", args, expected)
+	}
+}
+
+func TestWithGIFParams(t *testing.T) {
+	params := map[string]string{"max_seconds": "0", "fps": "24", "dither": "none"}
+	preset, err := withGIFParams(gifPresets["default"], func(name string) string { return params[name] })
+	if err != nil {
+		t.Fatalf("withGIFParams failed: %v", err)
+	}
+	expected := GIFPreset{Name: "default", FPS: 24, Width: 640, Dither: "none"}
+	if preset != expected {
+		t.Errorf("withGIFParams = %+v, expected %+v", preset, expected)
+	}
+
+	for _, bad := range []map[string]string{{"fps": "0"}, {"width": "wide"}, {"loop": "-2"}, {"dither": "random"}} {
+		if _, err := withGIFParams(gifPresets["default"], func(name string) string { return bad[name] }); err == nil {
+			t.Errorf("Expected an error for %v", bad)
+		}
+	}
+
+	t.Setenv("SSBNK_GIF_PRESET", "hq")
+	t.Setenv("SSBNK_GIF_WIDTH", "1280")
+	if preset := loadGIFPreset(); preset.Name != "hq" || preset.Width != 1280 || preset.FPS != 15 {
+		t.Errorf("loadGIFPreset = %+v", preset)
+	}
+	t.Setenv("SSBNK_GIF_PRESET", "huge")
+	if preset := loadGIFPreset(); preset != gifPresets[defaultGIFPreset] {
+		t.Errorf("Expected the default preset for an unknown name, got %+v", preset)
+	}
+}
+
+func TestSplitPresetSuffix(t *testing.T) {
+	tests := []struct{ filename, name, preset string }{
+		{"demo.preset-hq.mp4", "demo.mp4", "hq"},
+		{"demo.expires-1h.preset-small.webm", "demo.expires-1h.webm", "small"},
+		{"demo.mp4", "demo.mp4", ""},
+		{"preset-hq.mp4", "preset-hq.mp4", ""},
+	}
+	for _, tt := range tests {
+		name, preset := splitPresetSuffix(tt.filename)
+		if name != tt.name || preset != tt.preset {
+			t.Errorf("splitPresetSuffix(%q) = %q, %q", tt.filename, name, preset)
+		}
+	}
+}
+
+func TestProbeVideoFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		probed string
+		format string
+	}{
+		{"MP4", "mov,mp4,m4a,3gp,3g2,mj2", "mov"},
+		{"WebM", "matroska,webm", "matroska"},
+		{"AVI", "avi", "avi"},
+		{"HLS", "hls", ""},
+		{"Concat", "concat", ""},
+		{"Image", "png_pipe", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installFakeTool(t, "ffprobe", "echo "+tt.probed+"\n")
+			format, err := probeVideoFormat("in.video")
+			if tt.format == "" {
+				if !errors.Is(err, errInvalidVideo) {
+					t.Errorf("Expected errInvalidVideo, got %q, %v", format, err)
+				}
+				return
+			}
+			if err != nil || format != tt.format {
+				t.Errorf("probeVideoFormat = %q, %v; expected %q", format, err, tt.format)
+			}
+		})
+	}
+
+	t.Run("ProbeFails", func(t *testing.T) {
+		installFakeTool(t, "ffprobe", "exit 1\n")
+		if _, err := probeVideoFormat("in.video"); !errors.Is(err, errInvalidVideo) {
+			t.Errorf("Expected errInvalidVideo, got %v", err)
+		}
+	})
+}
+
+func TestVideoJobRejectsPlaylist(t *testing.T) {
+	state := t.TempDir()
+	installFakeTool(t, "ffprobe", "echo hls\n")
+	installFakeTool(t, "ffmpeg", "touch "+state+"/called\n")
+	config, _ := createTestConfig(t)
+	sourcePath := filepath.Join(config.ScreencastDir, "playlist.webm")
+	os.WriteFile(sourcePath, []byte("#EXTM3U\nfile:///etc/passwd\n"), 0644)
+
+	queue := config.jobQueue()
+	job := waitForQueuedJob(t, queue, queue.Enqueue(jobVideo, sourcePath, nil).ID)
+	if job.Status != jobFailed || job.Attempts != 1 {
+		t.Errorf("Expected an immediate failure, got %+v", job)
+	}
+	if fileExists(filepath.Join(state, "called")) {
+		t.Error("ffmpeg ran on a playlist")
+	}
+}
+
+func TestProcessVideoRetriesAndRecordsPreset(t *testing.T) {
+	state := t.TempDir()
+	installFakeTool(t, "ffprobe", "echo matroska,webm\n")
+	// Fails once, then writes a GIF to the last argument
+	installFakeTool(t, "ffmpeg", `echo "$*" >> `+state+`/calls
+if [ ! -f `+state+`/failed ]; then touch `+state+`/failed; exit 1; fi
+for arg; do out="$arg"; done
+printf 'GIF89a converted' > "$out"
+`)
+
+	config, _ := createTestConfig(t)
+	sourcePath := filepath.Join(config.ScreencastDir, "demo.preset-small.webm")
+	os.WriteFile(sourcePath, []byte("recording"), 0644)
+
+	if err := processVideo(sourcePath, config); err != nil {
+		t.Fatalf("processVideo failed after a successful retry: %v", err)
+	}
+
+	all := loadAllMetadata(config)
+	if len(all) != 1 {
+		t.Fatalf("Expected 1 metadata entry, got %d", len(all))
+	}
+	if all[0].GIFPreset == nil || *all[0].GIFPreset != gifPresets["small"] {
+		t.Errorf("Unexpected preset %+v", all[0].GIFPreset)
+	}
+	if all[0].OriginalName != "demo.webm" {
+		t.Errorf("Preset suffix kept in original name %q", all[0].OriginalName)
+	}
+
+	calls, _ := os.ReadFile(filepath.Join(state, "calls"))
+	if lines := strings.Split(strings.TrimSpace(string(calls)), "\n"); len(lines) != 2 || !strings.Contains(lines[1], "-t 15 ") {
+		t.Errorf("Unexpected ffmpeg calls:\n%s", calls)
+	}
+}
+
+func TestFFmpegVideoArgs(t *testing.T) {
+	mp4 := strings.Join(ffmpegVideoArgs(videoMP4, "in.mkv", "matroska", "out.mp4"), " ")
+	for _, want := range []string{"-protocol_whitelist file -f matroska -i in.mkv", "-c:v libx264", "-pix_fmt yuv420p", "-c:a aac", "-movflags +faststart"} {
+		if !strings.Contains(mp4, want) {
+			t.Errorf("MP4 args %q lack %q", mp4, want)
+		}
+	}
+	webm := strings.Join(ffmpegVideoArgs(videoWebM, "in.mkv", "matroska", "out.webm"), " ")
+	for _, want := range []string{"-c:v libvpx-vp9", "-c:a libopus"} {
+		if !strings.Contains(webm, want) {
+			t.Errorf("WebM args %q lack %q", webm, want)
+		}
+	}
+	if !strings.HasSuffix(webm, " out.webm") {
+		t.Errorf("Output not last: %q", webm)
+	}
+}
+
+func TestProcessVideoKeepsVideo(t *testing.T) {
+	installFakeTool(t, "ffprobe", "echo matroska,webm\n")
+	installFakeTool(t, "ffmpeg", `for arg; do out="$arg"; done
+case "$out" in *.gif) printf 'GIF89a converted' ;; *) printf 'full video' ;; esac > "$out"
+`)
+	config, _ := createTestConfig(t)
+	config.VideoFormat = videoMP4
+	config.URLSecret = []byte("secret")
+	sourcePath := filepath.Join(config.ScreencastDir, "demo.webm")
+	os.WriteFile(sourcePath, []byte("recording"), 0644)
+
+	if err := processVideo(sourcePath, config); err != nil {
+		t.Fatalf("processVideo failed: %v", err)
+	}
+	all := loadAllMetadata(config)
+	if len(all) != 1 || all[0].Video == nil {
+		t.Fatalf("Expected a screenshot with a video, got %+v", all)
+	}
+	metadata := all[0]
+	expected := strings.TrimSuffix(metadata.Filename, ".gif") + ".mp4"
+	if metadata.Video.Filename != expected || metadata.Video.Size != int64(len("full video")) {
+		t.Errorf("Unexpected video %+v", metadata.Video)
+	}
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		serveHostedFile(w, httptest.NewRequest("GET", target, nil), config)
+		return w
+	}
+	if w := serve("/" + expected); w.Code != http.StatusOK || w.Body.String() != "full video" {
+		t.Errorf("Expected the video, got %d %q", w.Code, w.Body.String())
+	}
+
+	t.Run("PrivateNeedsGIFSignature", func(t *testing.T) {
+		metadata.Private = true
+		config.metadataRepo().Save(metadata)
+		if w := serve("/" + expected); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for a private video, got %d", w.Code)
+		}
+		signed := strings.TrimPrefix(videoAccessURL(config, metadata), config.BaseURL)
+		if w := serve(signed); w.Code != http.StatusOK {
+			t.Errorf("Expected the GIF's signature to open %s, got %d", signed, w.Code)
+		}
+	})
+
+	t.Run("ArchiveMovesVideo", func(t *testing.T) {
+		archiveDir := filepath.Join(archiveRoot(config), "2024-01-01")
+		if err := archiveScreenshot(config, metadata, archiveDir); err != nil {
+			t.Fatalf("archiveScreenshot failed: %v", err)
+		}
+		if hostedExists(config, expected) || !fileExists(filepath.Join(archiveDir, expected)) {
+			t.Error("Video not moved to the archive")
+		}
+		item, _, _ := findArchived(config, metadata.ID)
+		if _, err := restoreArchived(config, item); err != nil {
+			t.Fatalf("restoreArchived failed: %v", err)
+		}
+		if !hostedExists(config, expected) {
+			t.Error("Video not restored")
+		}
+	})
+
+	t.Run("DeleteRemovesVideo", func(t *testing.T) {
+		if err := deleteScreenshot(config, metadata); err != nil {
+			t.Fatalf("deleteScreenshot failed: %v", err)
+		}
+		if hostedExists(config, expected) {
+			t.Error("Video kept after delete")
+		}
+	})
+}